@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/cclose/dnsmasq-api/constant/key"
+	"github.com/cclose/dnsmasq-api/internal/util"
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/cclose/dnsmasq-api/service"
+	"github.com/spf13/cobra"
+	"io"
+	"strings"
+)
+
+const exportCmdName = "export"
+
+var (
+	// exportFormat The format to export in, guessed from the output file's extension if empty
+	exportFormat string
+	// exportOutput The file to write the export to, stdout if empty
+	exportOutput string
+	// exportType Only export records of this type
+	exportType string
+	// exportOwner Only export records with this owner
+	exportOwner string
+	// exportTag Only export records with this tag
+	exportTag string
+)
+
+// exportCmd The export subcommand
+var exportCmd = &cobra.Command{
+	Use:   exportCmdName,
+	Short: "export records as a hosts file, dnsmasq config, zone file, CSV, YAML or JSON",
+	Long: `Exports the DNS records of the database, reading it directly without starting the server or touching the
+dnsmasq config. The server holds the database open, so stop it before exporting, or GET /export instead.
+
+Every format but yaml can be imported again. A hosts file only holds the host records.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		appConfig, ok := cmd.Context().Value(key.ContextConfig).(model.AppConfig)
+		if !ok {
+			return fmt.Errorf("app config not found in context. Context failed to load")
+		}
+
+		return runExport(appConfig.Config, cmd.OutOrStdout())
+	},
+}
+
+// init Register the export subcommand with cobra root cmd
+func init() {
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "",
+		"format to export in: "+strings.Join(model.ExportFormats, ", ")+
+			" (default guessed from the output file extension, or json)")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "file to write the export to (default stdout)")
+	exportCmd.Flags().StringVarP(&exportType, "type", "t", "", "only export records of this type")
+	exportCmd.Flags().StringVar(&exportOwner, "owner", "", "only export records with this owner")
+	exportCmd.Flags().StringVar(&exportTag, "tag", "", "only export records with this tag")
+	rootCmd.AddCommand(exportCmd)
+}
+
+// runExport renders the records of the DB, writing them to the output file, or out if there is none
+func runExport(config model.Config, out io.Writer) error {
+	format := exportFormat
+	if format == "" {
+		format = formatOf(exportOutput)
+	}
+	if format == "" {
+		format = model.ExportFormatJSON
+	}
+	filter := model.DNSRecordFilter{Owner: exportOwner, Tag: exportTag}
+	if exportType != "" {
+		recordType, err := model.ParseRecordType(exportType)
+		if err != nil {
+			return err
+		}
+		filter.Type = recordType
+	}
+
+	records, err := service.ReadRecords(config.DB, filter)
+	if err != nil {
+		return err
+	}
+	data, err := service.RenderExport(format, records)
+	if err != nil {
+		return err
+	}
+
+	if exportOutput == "" {
+		_, err = out.Write(data)
+		return err
+	}
+
+	return util.WriteFileAtomic(exportOutput, data, 0644, 0)
+}
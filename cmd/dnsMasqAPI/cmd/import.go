@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cclose/dnsmasq-api/constant/key"
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/cclose/dnsmasq-api/service"
+	"github.com/spf13/cobra"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const importCmdName = "import"
+
+var (
+	// importFormat The format of the imported file, guessed from its extension if empty
+	importFormat string
+	// importMode Whether the import merges into or replaces the records
+	importMode string
+	// importDryRun Only report the changes
+	importDryRun bool
+	// importJSON Print the result as JSON
+	importJSON bool
+)
+
+// importCmd The import subcommand
+var importCmd = &cobra.Command{
+	Use:   importCmdName + " <file>",
+	Short: "import records from a hosts file, CSV, JSON, zone file or dnsmasq config",
+	Long: `Imports DNS records from a file into the database, then rewrites the dnsmasq config and reloads dnsmasq.
+
+A merge adds the records of each hostname and type that has none yet, and reports hostnames that already have
+different records as conflicts. A replace makes the file's records the only ones. Use --dry-run to see the changes
+first, which only reads the database and leaves the dnsmasq config alone. The server holds the database open, so
+stop it before importing, or POST the file to /import instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		appConfig, ok := cmd.Context().Value(key.ContextConfig).(model.AppConfig)
+		if !ok {
+			return fmt.Errorf("app config not found in context. Context failed to load")
+		}
+
+		return runImport(cmd.Context(), appConfig.Config, args[0], cmd.OutOrStdout())
+	},
+}
+
+// init Register the import subcommand with cobra root cmd
+func init() {
+	importCmd.Flags().StringVarP(&importFormat, "format", "f", "",
+		"format of the file: "+strings.Join(model.ImportFormats, ", ")+" (default guessed from the file extension)")
+	importCmd.Flags().StringVarP(&importMode, "mode", "m", model.ImportModeMerge,
+		"merge into the records or replace them: "+model.ImportModeMerge+" or "+model.ImportModeReplace)
+	importCmd.Flags().BoolVarP(&importDryRun, "dry-run", "n", false, "only show the changes the import would make")
+	importCmd.Flags().BoolVar(&importJSON, "json", false, "print the result as JSON")
+	rootCmd.AddCommand(importCmd)
+}
+
+// formatOf guesses the import or export format of a file from its extension, returning an empty string if it has none
+// of the known extensions
+func formatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return model.ImportFormatCSV
+	case ".json":
+		return model.ImportFormatJSON
+	case ".zone":
+		return model.ImportFormatZone
+	case ".conf":
+		return model.ImportFormatDNSMasq
+	case ".yaml", ".yml":
+		return model.ExportFormatYAML
+	}
+
+	return ""
+}
+
+// runImport imports the records of a file, printing the changes, and applies them to dnsmasq
+func runImport(ctx context.Context, config model.Config, path string, out io.Writer) error {
+	format := importFormat
+	if format == "" {
+		format = formatOf(path)
+	}
+	if format == "" {
+		format = model.ImportFormatHosts
+	}
+	mode, err := model.ParseImportMode(importMode)
+	if err != nil {
+		return err
+	}
+	records, issues, err := service.ParseImportFile(format, path)
+	if err != nil {
+		return err
+	}
+
+	logger, err := configureLogging(config.Logging)
+	if logCloser, ok := logger.Out.(io.Closer); ok {
+		defer logCloser.Close()
+	}
+	if err != nil {
+		return err
+	}
+	// Imported changes are audited under the name of the command
+	ctx = context.WithValue(ctx, key.ContextIdentity, &model.Identity{Name: importCmdName})
+	if importDryRun {
+		// A dry run leaves the DB and the dnsmasq config alone, so it skips the service and its BuildDatabase
+		result, err := service.DryRunImport(ctx, config, records, mode)
+		return printImport(out, result, issues, err)
+	}
+
+	db, err := service.OpenDB(config.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ds, err := service.NewDNSMasqService(config, service.WithLogger(logger), service.WithConfig(config.DB),
+		service.WithDB(db))
+	if err != nil {
+		return err
+	}
+
+	result, err := ds.ImportRecords(ctx, records, model.ImportOptions{Mode: mode})
+	if err = printImport(out, result, issues, err); err != nil || len(result.Changes) == 0 {
+		return err
+	}
+
+	return ds.UpdateDNSMasq()
+}
+
+// printImport prints the result of an import along with the issues found parsing the file, as JSON with --json,
+// returning err
+func printImport(out io.Writer, result model.ImportResult, issues []model.ImportIssue, err error) error {
+	result.Skipped = append(result.Skipped, issues...)
+	if importJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if encodeErr := encoder.Encode(result); encodeErr != nil {
+			return encodeErr
+		}
+	} else {
+		printImportResult(out, result)
+	}
+
+	return err
+}
+
+// printImportResult prints the changes of an import as a diff, followed by the conflicts, the skipped lines and any
+// failed operations
+func printImportResult(out io.Writer, result model.ImportResult) {
+	added, changed, deleted := 0, 0, 0
+	for _, change := range result.Changes {
+		switch {
+		case change.Op == model.BulkOpDelete:
+			deleted += 1
+			fmt.Fprintf(out, "- %s %s: %s\n", change.Hostname, change.Type, recordValues(change.Before))
+		case len(change.Before) == 0:
+			added += 1
+			fmt.Fprintf(out, "+ %s %s: %s\n", change.Hostname, change.Type, recordValues(change.After))
+		default:
+			changed += 1
+			fmt.Fprintf(out, "~ %s %s: %s (was %s)\n", change.Hostname, change.Type, recordValues(change.After),
+				recordValues(change.Before))
+		}
+	}
+	for _, conflict := range result.Conflicts {
+		fmt.Fprintf(out, "! %s %s: %s conflicts with %s\n", conflict.Hostname, conflict.Type,
+			recordValues(conflict.Imported), recordValues(conflict.Existing))
+	}
+	for _, issue := range result.Skipped {
+		fmt.Fprintf(out, "? %s: %s (%s)\n", issue, issue.Reason, issue.Text)
+	}
+	for _, opResult := range result.Results {
+		if opResult.Error != "" {
+			fmt.Fprintf(out, "x %s: %s\n", opResult.Hostname, opResult.Error)
+		}
+	}
+
+	summary := fmt.Sprintf("%d added, %d changed, %d deleted, %d unchanged, %d conflicts, %d skipped",
+		added, changed, deleted, result.Unchanged, len(result.Conflicts), len(result.Skipped))
+	if len(result.Results) > 0 {
+		summary += " (failed, nothing was changed)"
+	} else if result.DryRun {
+		summary += " (dry run, nothing was changed)"
+	}
+	fmt.Fprintln(out, summary)
+}
+
+// recordValues joins the values of records for printing
+func recordValues(records []model.DNSRecord) string {
+	var values []string
+	for _, record := range records {
+		values = append(values, record.Value())
+	}
+
+	return strings.Join(values, ", ")
+}
@@ -82,6 +82,11 @@ func init() {
 
 	viper.SetDefault("author", "Cory Close <pulsar2612@hotmail.com>")
 	viper.SetDefault("license", "bsd-3-clause")
+	viper.SetDefault("dnsmasq_backups", 3)
+	viper.SetDefault("reload.sudo", true)
+	viper.SetDefault("output.reload.sudo", true)
+	viper.SetDefault("expiry.reap_interval", "1m")
+	viper.SetDefault("history.max_revisions", 50)
 	cobra.OnInitialize(initViper)
 
 	// Add -c flag and bind to viper
@@ -2,9 +2,12 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/cclose/dnsmasq-api/constant/key"
 	"github.com/cclose/dnsmasq-api/controller"
+	"github.com/cclose/dnsmasq-api/internal/tlsreload"
 	"github.com/cclose/dnsmasq-api/model"
 	"github.com/cclose/dnsmasq-api/service"
 	"github.com/labstack/echo/v4"
@@ -12,8 +15,11 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"io"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 )
 
 const serverCmdName = "server"
@@ -44,28 +50,75 @@ func initMetrics(e *echo.Echo) {
 }
 
 // startUpMessage creates the boot up message for the service, informing the log of the service's Version and config
-func startUpMessage(aConfig model.AppConfig, lAddr string) string {
+func startUpMessage(aConfig model.AppConfig, lAddr string, reloader service.Reloader) string {
 	msg := strings.Repeat("#", 73)
 	msg += fmt.Sprintf(
 		"\n%s - %s Version %s (%s)\n"+
 			"Starting Server:\n"+
 			"  Listening on %s\n"+
 			"  Tracking DNSMasq Config: %s\n"+
-			"  DNSMasq Service Reloading: %v\n",
+			"  DNSMasq Reload: %s\n",
 		rootCmd.Name(), serverCmdName, aConfig.BuildInfo.Version, aConfig.BuildInfo.Commit,
 		//
 		lAddr,
 		aConfig.Config.DnsmasqConfig,
-		!aConfig.Config.SkipDNSMasqReload, // invert the boolean since it is for skipping
+		reloader,
 	)
+	if strings.EqualFold(aConfig.Config.Output.Mode, service.OutputModeHosts) {
+		msg += fmt.Sprintf("  Host Records File: %s\n", aConfig.Config.Output.HostsFile)
+	}
+	if aConfig.Config.Debounce.Window > 0 {
+		msg += fmt.Sprintf("  Debouncing Updates: %s (max delay %s)\n",
+			aConfig.Config.Debounce.Window, aConfig.Config.Debounce.MaxDelay)
+	}
+	if aConfig.Config.Expiry.ReapInterval > 0 {
+		msg += fmt.Sprintf("  Reaping Expired Records: every %s\n", aConfig.Config.Expiry.ReapInterval)
+	}
+	if aConfig.Config.Audit.ExportFile != "" {
+		msg += fmt.Sprintf("  Exporting Audit Log: %s\n", aConfig.Config.Audit.ExportFile)
+	}
+	if aConfig.Config.DHCPConfig != "" {
+		msg += fmt.Sprintf("  Tracking DHCP Config: %s\n", aConfig.Config.DHCPConfig)
+	}
 	if aConfig.Config.SSL.Enabled {
 		msg += "  SSL Enabled\n"
+		if aConfig.Config.SSL.ClientCAFile != "" {
+			msg += fmt.Sprintf("  Verifying Client Certificates: %s\n", aConfig.Config.SSL.ClientCAFile)
+		}
+	}
+	if aConfig.Config.Auth.Enabled {
+		msg += fmt.Sprintf("  Auth Enabled: %d tokens\n", len(aConfig.Config.Auth.Tokens))
 	}
 	msg += strings.Repeat("#", 73)
 
 	return msg
 }
 
+// configureTLS Builds the server TLS config, reloading the certificate and client CA files when they change
+func configureTLS(sslConfig model.SSLConfig, logger *logrus.Logger) (*tls.Config, error) {
+	clientAuth := tls.NoClientCert
+	if sslConfig.ClientCAFile != "" {
+		switch strings.ToLower(sslConfig.ClientAuth) {
+		case "", "require":
+			clientAuth = tls.RequireAndVerifyClientCert
+		case "verify":
+			clientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("unknown ssl.client_auth mode '%s'", sslConfig.ClientAuth)
+		}
+	}
+
+	store, err := tlsreload.New(sslConfig.CertFile, sslConfig.KeyFile, sslConfig.ClientCAFile, clientAuth)
+	if err != nil {
+		return nil, err
+	}
+	store.OnError = func(err error) {
+		logger.Errorf("Failed to reload TLS certificates, keeping the previous ones: %v", err)
+	}
+
+	return store.TLSConfig(), nil
+}
+
 // configureLogging Configures the logging provider based on the logging config
 func configureLogging(lConfig model.LoggingConfig) (*logrus.Logger, error) {
 	logger := logrus.New()
@@ -133,27 +186,93 @@ func startServer(ctx context.Context, appConfig model.AppConfig) error {
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	initMetrics(e)
+	// Client certificates are only worth verifying if they map to identities
+	if config.Auth.Enabled || config.SSL.ClientCAFile != "" {
+		as, err := service.NewAuthService(config.Auth)
+		if err != nil {
+			return err
+		}
+		e.Use(controller.AuthMiddleware(as, logger))
+	}
 
-	// Boot our services
-	ds, err := service.NewDNSMasqService(config, service.WithLogger(logger), service.WithConfig(config.DB))
+	// Boot our services, sharing a single DB handle
+	db, err := service.OpenDB(config.DB)
 	if err != nil {
 		return err
 	}
+	defer db.Close()
+	reloader, err := service.NewReloader(config.Reload, config.SkipDNSMasqReload)
+	if err != nil {
+		return err
+	}
+	ds, err := service.NewDNSMasqService(config, service.WithLogger(logger), service.WithConfig(config.DB),
+		service.WithDB(db), service.WithReloader(reloader))
+	if err != nil {
+		return err
+	}
+
+	stopReaper := ds.StartReaper(config.Expiry.ReapInterval)
+	stopWatcher := func() {}
+	if config.Sync.Watch {
+		if stopWatcher, err = ds.StartSyncWatcher(config.Sync.WatchDelay); err != nil {
+			stopReaper()
+			return err
+		}
+	}
 
 	// Register our Controllers
 	dc := controller.NewDnsController(ds)
 	dc.Register(e)
+	ac := controller.NewAuditController(ds)
+	ac.Register(e)
+	snc := controller.NewSnapshotController(ds)
+	snc.Register(e)
+	ic := controller.NewImportController(ds)
+	ic.Register(e)
+	ec := controller.NewExportController(ds)
+	ec.Register(e)
+	syc := controller.NewSyncController(ds)
+	syc.Register(e)
+	if config.DHCPConfig != "" {
+		dhs, err := service.NewDHCPService(config, db, ds, service.WithDHCPLogger(logger))
+		if err != nil {
+			return err
+		}
+		hc := controller.NewDhcpController(dhs)
+		hc.Register(e)
+	}
 	sc := controller.NewStatusController(appConfig.BuildInfo)
 	sc.Register(e)
 
 	// Calculate service address and boot
 	address := fmt.Sprintf(":%d", config.Port)
-	logger.Print(startUpMessage(appConfig, address))
+	logger.Print(startUpMessage(appConfig, address, reloader))
+
+	// Shut down cleanly on SIGINT or SIGTERM, so debounced updates are written out before exiting
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = e.Shutdown(context.Background())
+	}()
+
 	if config.SSL.Enabled {
-		err = e.StartTLS(address, config.SSL.CertFile, config.SSL.KeyFile)
+		e.TLSServer.Addr = address
+		if e.TLSServer.TLSConfig, err = configureTLS(config.SSL, logger); err != nil {
+			return err
+		}
+		err = e.StartServer(e.TLSServer)
 	} else {
 		err = e.Start(address)
 	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	stopReaper()
+	stopWatcher()
+	if flushErr := ds.FlushUpdates(); flushErr != nil {
+		logger.Errorf("Failed to apply pending dnsmasq updates on shutdown: %v", flushErr)
+	}
 
 	return err
 }
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/cclose/dnsmasq-api/service"
+	"github.com/spf13/cobra"
+)
+
+const tokenCmdName = "token"
+
+// tokenCmd The token subcommand
+var tokenCmd = &cobra.Command{
+	Use:   tokenCmdName + " [token]",
+	Short: "generate an API token and the hash to put in auth.tokens",
+	Long: `Generates a random API token and prints it along with its hash for the auth.tokens config.
+If a token is given, only its hash is printed.`,
+	Args: cobra.MaximumNArgs(1),
+	// Tokens are made before there is a config to read
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 1 {
+			fmt.Println(service.HashToken(args[0]))
+			return nil
+		}
+
+		token, err := service.GenerateToken()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("token: %s\nhash:  %s\n", token, service.HashToken(token))
+
+		return nil
+	},
+}
+
+// init Register the token subcommand with cobra root cmd
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+}
@@ -3,3 +3,12 @@ package key
 type Context string
 
 const ContextConfig Context = "config"
+
+// ContextIdentity holds the *model.Identity making an API request
+const ContextIdentity Context = "identity"
+
+// ContextSourceIP holds the IP address an API request came from
+const ContextSourceIP Context = "source_ip"
+
+// ContextPrecondition holds the model.Precondition a change to a hostname must meet
+const ContextPrecondition Context = "precondition"
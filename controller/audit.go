@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/cclose/dnsmasq-api/service"
+	"github.com/labstack/echo/v4"
+)
+
+type IAuditController interface {
+	GetAuditLog(ctx echo.Context) error
+	Register(e *echo.Echo)
+}
+
+type AuditController struct {
+	ds service.IDNSMasqService
+}
+
+func NewAuditController(ds service.IDNSMasqService) IAuditController {
+	return &AuditController{
+		ds: ds,
+	}
+}
+
+func (ac *AuditController) Register(e *echo.Echo) {
+	e.GET("/audit", ac.GetAuditLog)
+}
+
+// auditQueryParams parses the from, to, hostname, after and limit query parameters. Times are RFC 3339
+func auditQueryParams(ctx echo.Context) (model.AuditQuery, error) {
+	query := model.AuditQuery{Hostname: ctx.QueryParam("hostname")}
+	var err error
+	if from := ctx.QueryParam("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return query, err
+		}
+	}
+	if to := ctx.QueryParam("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return query, err
+		}
+	}
+	if after := ctx.QueryParam("after"); after != "" {
+		if query.After, err = strconv.ParseUint(after, 10, 64); err != nil {
+			return query, err
+		}
+	}
+	if limit := ctx.QueryParam("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return query, err
+		}
+	}
+
+	return query, nil
+}
+
+// GetAuditLog Lists a page of the audit log, oldest first. Identities limited to hostnames must ask for one of them
+func (ac *AuditController) GetAuditLog(ctx echo.Context) error {
+	query, err := auditQueryParams(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	if !unrestricted(ctx) && (query.Hostname == "" || !hostnameAllowed(ctx, query.Hostname)) {
+		return forbiddenHostname(ctx, query.Hostname)
+	}
+
+	page, err := ac.ds.GetAuditLog(query)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, page)
+}
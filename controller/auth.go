@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/constant/key"
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/cclose/dnsmasq-api/service"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// publicPaths are served without authentication, so health checks keep working
+var publicPaths = map[string]bool{
+	"/statusz": true,
+}
+
+// adminPaths need the admin scope, whatever the method
+var adminPaths = map[string]bool{
+	"/audit":                 true,
+	"/snapshots":             true,
+	"/snapshots/:id/restore": true,
+	"/import":                true,
+	"/sync":                  true,
+	"/sync/status":           true,
+}
+
+// AuthMiddleware Requires a valid bearer token or verified client certificate on every request except the public
+// paths. A bearer token takes precedence over a certificate. Reads need the read scope and everything else the write
+// scope, and the admin paths the admin scope. Changes are logged with the name of the identity that made them
+func AuthMiddleware(auth service.IAuthService, logger *logrus.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if publicPaths[c.Path()] {
+				return next(c)
+			}
+
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			if token, found := strings.CutPrefix(header, "Bearer "); found {
+				identity, err := auth.Authenticate(strings.TrimSpace(token))
+				if err != nil {
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+					return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+				}
+				return authorize(c, next, identity, logger)
+			}
+
+			if state := c.Request().TLS; state != nil && len(state.VerifiedChains) > 0 {
+				identity, err := auth.AuthenticateCertificate(state.VerifiedChains[0][0])
+				if err != nil {
+					return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+				}
+				return authorize(c, next, identity, logger)
+			}
+
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "missing API token or client certificate"})
+		}
+	}
+}
+
+// authorize checks the identity has the scope the request needs, then serves it with the identity in its context
+func authorize(c echo.Context, next echo.HandlerFunc, identity *model.Identity, logger *logrus.Logger) error {
+	method := c.Request().Method
+	readOnly := method == http.MethodGet || method == http.MethodHead
+	scope := model.ScopeWrite
+	if adminPaths[c.Path()] {
+		scope = model.ScopeAdmin
+	} else if readOnly {
+		scope = model.ScopeRead
+	}
+	if !identity.HasScope(scope) {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "'" + identity.Name + "' lacks the " + string(scope) + " scope"})
+	}
+
+	c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), key.ContextIdentity, identity)))
+	err := next(c)
+	if !readOnly && c.Response().Status < http.StatusBadRequest {
+		logger.WithField("actor", identity.Name).Infof("%s %s -> %d", method, c.Request().URL.Path, c.Response().Status)
+	}
+
+	return err
+}
+
+// identityFromContext returns the identity making the request, or nil if authentication is disabled
+func identityFromContext(c echo.Context) *model.Identity {
+	identity, _ := c.Request().Context().Value(key.ContextIdentity).(*model.Identity)
+	return identity
+}
+
+// requestContext returns the context of the request with its source IP added, so the services can audit changes
+// under the identity and address that made them
+func requestContext(c echo.Context) context.Context {
+	return context.WithValue(c.Request().Context(), key.ContextSourceIP, c.RealIP())
+}
+
+// hostnameAllowed reports whether the request may manage the hostname
+func hostnameAllowed(c echo.Context, hostname string) bool {
+	identity := identityFromContext(c)
+	return identity == nil || identity.AllowsHostname(hostname)
+}
+
+// unrestricted reports whether the request may manage every hostname
+func unrestricted(c echo.Context) bool {
+	identity := identityFromContext(c)
+	return identity == nil || len(identity.Hostnames) == 0
+}
+
+// forbiddenHostname responds that the request may not manage the hostname
+func forbiddenHostname(c echo.Context, hostname string) error {
+	return c.JSON(http.StatusForbidden, echo.Map{"error": "not allowed to manage hostname '" + hostname + "'"})
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/cclose/dnsmasq-api/service"
+	"github.com/labstack/echo/v4"
+	"net"
+	"net/http"
+)
+
+type IDHCPController interface {
+	GetAllDHCPHosts(ctx echo.Context) error
+	GetDHCPHost(ctx echo.Context) error
+	SetDHCPHost(ctx echo.Context) error
+	DeleteDHCPHost(ctx echo.Context) error
+	GetDHCPLeases(ctx echo.Context) error
+	PromoteDHCPLease(ctx echo.Context) error
+	Register(e *echo.Echo)
+}
+
+type DhcpController struct {
+	dhs service.IDHCPService
+}
+
+func NewDhcpController(dhs service.IDHCPService) IDHCPController {
+	return &DhcpController{
+		dhs: dhs,
+	}
+}
+
+func (hc *DhcpController) Register(e *echo.Echo) {
+	e.GET("/dhcp/hosts", hc.GetAllDHCPHosts)
+	e.GET("/dhcp/hosts/:mac", hc.GetDHCPHost)
+	e.POST("/dhcp/hosts/:mac", hc.SetDHCPHost)
+	e.DELETE("/dhcp/hosts/:mac", hc.DeleteDHCPHost)
+	e.GET("/dhcp/leases", hc.GetDHCPLeases)
+	e.POST("/dhcp/leases/:mac/promote", hc.PromoteDHCPLease)
+}
+
+// forbiddenReservations responds that tokens limited to some hostnames may not change reservations, since a
+// reservation can hand out any hostname
+func forbiddenReservations(ctx echo.Context) error {
+	return ctx.JSON(http.StatusForbidden, echo.Map{"error": "tokens restricted to hostnames may not change reservations"})
+}
+
+func (hc *DhcpController) GetAllDHCPHosts(ctx echo.Context) error {
+	hosts, err := hc.dhs.GetAllHosts()
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	} // implicit else
+
+	return ctx.JSON(http.StatusOK, hosts)
+}
+
+func (hc *DhcpController) GetDHCPHost(ctx echo.Context) error {
+	host, err := hc.dhs.GetHostByMAC(ctx.Param("mac"))
+	if err != nil {
+		if err.Error() == service.ErrorNoDHCPHost {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"message": "reservation not found"})
+		} // implicit else
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	} // implicit else
+
+	return ctx.JSON(http.StatusOK, host)
+}
+
+func (hc *DhcpController) SetDHCPHost(ctx echo.Context) error {
+	if !unrestricted(ctx) {
+		return forbiddenReservations(ctx)
+	}
+	req := model.SetDHCPHostRequest{}
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body: " + err.Error()})
+	}
+
+	host, err := hc.dhs.SetHost(model.DHCPHost{
+		MAC:       ctx.Param("mac"),
+		IP:        req.IP,
+		Hostname:  req.Hostname,
+		LeaseTime: req.LeaseTime,
+	})
+	if err != nil {
+		if err.Error() == service.ErrorDHCPIPConflict {
+			return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		} // implicit else
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	if err = hc.dhs.UpdateDHCP(); err != nil {
+		return updateErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, host)
+}
+
+func (hc *DhcpController) DeleteDHCPHost(ctx echo.Context) error {
+	if !unrestricted(ctx) {
+		return forbiddenReservations(ctx)
+	}
+	err := hc.dhs.DeleteHostByMAC(ctx.Param("mac"))
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	if err = hc.dhs.UpdateDHCP(); err != nil {
+		return updateErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "reservation deleted"})
+}
+
+func (hc *DhcpController) GetDHCPLeases(ctx echo.Context) error {
+	filter := model.DHCPLeaseFilter{
+		MAC:      ctx.QueryParam("mac"),
+		Hostname: ctx.QueryParam("hostname"),
+	}
+	if subnet := ctx.QueryParam("subnet"); subnet != "" {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "invalid subnet: " + err.Error()})
+		}
+		filter.Subnet = ipNet
+	}
+
+	leases, err := hc.dhs.GetLeases(filter)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, leases)
+}
+
+func (hc *DhcpController) PromoteDHCPLease(ctx echo.Context) error {
+	if !unrestricted(ctx) {
+		return forbiddenReservations(ctx)
+	}
+	req := model.PromoteDHCPLeaseRequest{}
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body: " + err.Error()})
+	}
+
+	host, err := hc.dhs.PromoteLease(ctx.Param("mac"), req)
+	if err != nil {
+		switch err.Error() {
+		case service.ErrorNoDHCPLease:
+			return ctx.JSON(http.StatusNotFound, echo.Map{"message": "lease not found"})
+		case service.ErrorDHCPIPConflict:
+			return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	if err = hc.dhs.UpdateDHCP(); err != nil {
+		return updateErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, host)
+}
@@ -1,18 +1,29 @@
 package controller
 
 import (
+	"context"
+	"errors"
+	"github.com/cclose/dnsmasq-api/constant/key"
 	"github.com/cclose/dnsmasq-api/model"
 	"github.com/cclose/dnsmasq-api/service"
 	"github.com/labstack/echo/v4"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type IDNSController interface {
 	GetAllDNSRecords(ctx echo.Context) error
+	ResolveDNSName(ctx echo.Context) error
 	GetDNSRecord(ctx echo.Context) error
 	SetDNSRecord(ctx echo.Context) error
 	DeleteDNSRecord(ctx echo.Context) error
+	RemoveDNSRecordIP(ctx echo.Context) error
+	PatchDNSRecord(ctx echo.Context) error
+	BulkDNSRecords(ctx echo.Context) error
+	RenewDNSRecord(ctx echo.Context) error
+	GetDNSRecordHistory(ctx echo.Context) error
+	RevertDNSRecord(ctx echo.Context) error
 	Register(e *echo.Echo)
 }
 
@@ -28,23 +39,191 @@ func NewDnsController(ds service.IDNSMasqService) IDNSController {
 
 func (dc *DnsController) Register(e *echo.Echo) {
 	e.GET("/dns", dc.GetAllDNSRecords)
+	e.GET("/dns/resolve/:name", dc.ResolveDNSName)
 	e.GET("/dns/:hostname", dc.GetDNSRecord)
+	e.POST("/dns/_bulk", dc.BulkDNSRecords)
 	e.POST("/dns/:hostname", dc.SetDNSRecord)
 	e.DELETE("/dns/:hostname", dc.DeleteDNSRecord)
+	e.PATCH("/dns/:hostname", dc.PatchDNSRecord)
+	e.DELETE("/dns/:hostname/:ip", dc.RemoveDNSRecordIP)
+	e.DELETE("/dns/:hostname/ips/:ip", dc.RemoveDNSRecordIP)
+	e.PUT("/dns/:hostname/renew", dc.RenewDNSRecord)
+	e.GET("/dns/:hostname/history", dc.GetDNSRecordHistory)
+	e.POST("/dns/:hostname/revert", dc.RevertDNSRecord)
+}
+
+// updateErrorResponse Responds to a failed config update. A config rejected by the preflight check is a 422, a config
+// left drifted by the fail sync policy is a 409, anything else is a 500. Config errors include the failed stage, the
+// command output, and whether it was rolled back
+func updateErrorResponse(ctx echo.Context, err error) error {
+	if err.Error() == service.ErrorConfigDrift {
+		return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	}
+	var configErr *service.ConfigError
+	if !errors.As(err, &configErr) {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	status := http.StatusInternalServerError
+	if configErr.Stage == service.ConfigStageValidate {
+		status = http.StatusUnprocessableEntity
+	}
+
+	return ctx.JSON(status, echo.Map{
+		"error":       configErr.Error(),
+		"stage":       configErr.Stage,
+		"output":      configErr.Output,
+		"rolled_back": configErr.RolledBack,
+	})
+}
+
+// recordErrorResponse Responds to records that could not be changed. Records failing validation are a 422 listing the
+// invalid fields, a hostname failing the If-Match or If-None-Match precondition is a 412, a change refused while the
+// fail sync policy leaves the config drifted is a 409, and anything else is a 400
+func recordErrorResponse(ctx echo.Context, err error) error {
+	var validationErr *service.ValidationError
+	if errors.As(err, &validationErr) {
+		return ctx.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error(), "errors": validationErr.Errors})
+	}
+	if err.Error() == service.ErrorPreconditionFailed {
+		return ctx.JSON(http.StatusPreconditionFailed, echo.Map{"error": err.Error()})
+	}
+	if err.Error() == service.ErrorConfigDrift {
+		return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+}
+
+// awaitUpdate Waits for a scheduled config update if the wait query parameter is set. A debounced update that is
+// not waited for is still pending, and its result is only logged
+func awaitUpdate(ctx echo.Context, done <-chan error) (pending bool, err error) {
+	if wait, _ := strconv.ParseBool(ctx.QueryParam("wait")); wait {
+		return false, <-done
+	}
+
+	select {
+	case err = <-done:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+// etagList splits an If-Match or If-None-Match header into its ETags
+func etagList(header string) []string {
+	var etags []string
+	for _, etag := range strings.Split(header, ",") {
+		if etag = strings.TrimSpace(etag); etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+
+	return etags
+}
+
+// preconditionContext returns the request context with the If-Match and If-None-Match headers added as the
+// precondition for changing the hostname. If-None-Match: * only creates hostnames that have no records
+func preconditionContext(ctx echo.Context) context.Context {
+	reqCtx := requestContext(ctx)
+	precondition := model.Precondition{
+		IfMatch:     etagList(ctx.Request().Header.Get("If-Match")),
+		IfNoneMatch: etagList(ctx.Request().Header.Get("If-None-Match")),
+	}
+	if len(precondition.IfMatch) == 0 && len(precondition.IfNoneMatch) == 0 {
+		return reqCtx
+	}
+
+	return context.WithValue(reqCtx, key.ContextPrecondition, precondition)
+}
+
+// setETag Sets the ETag header to the current revision of the hostname
+func (dc *DnsController) setETag(ctx echo.Context, hostname string) {
+	if rev, err := dc.ds.GetHostRevision(hostname); err == nil && rev > 0 {
+		ctx.Response().Header().Set("ETag", model.RevisionETag(rev))
+	}
+}
+
+// recordTypeParam parses the optional type query parameter. An empty type matches all record types
+func recordTypeParam(ctx echo.Context) (model.RecordType, error) {
+	typeStr := ctx.QueryParam("type")
+	if typeStr == "" {
+		return "", nil
+	}
+
+	return model.ParseRecordType(typeStr)
+}
+
+// defaultOwner Fills in the caller as the owner when the request does not name one
+func defaultOwner(ctx echo.Context, meta model.RecordMetadata) model.RecordMetadata {
+	if identity := identityFromContext(ctx); meta.Owner == "" && identity != nil {
+		meta.Owner = identity.Name
+	}
+
+	return meta
 }
 
 func (dc *DnsController) GetAllDNSRecords(ctx echo.Context) error {
-	records, err := dc.ds.GetAllIPs()
+	recordType, err := recordTypeParam(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	filter := model.DNSRecordFilter{Type: recordType, Owner: ctx.QueryParam("owner"), Tag: ctx.QueryParam("tag")}
+	records, err := dc.ds.FindRecords(filter)
 	if err != nil {
 		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
 	} // implicit else
 
-	return ctx.JSON(http.StatusOK, records)
+	return ctx.JSON(http.StatusOK, allowedRecords(ctx, records))
+}
+
+// allowedRecords Keeps only the records the caller may manage
+func allowedRecords(ctx echo.Context, records []model.DNSRecord) []model.DNSRecord {
+	if unrestricted(ctx) {
+		return records
+	}
+
+	var allowed []model.DNSRecord
+	for _, record := range records {
+		if hostnameAllowed(ctx, record.Hostname) {
+			allowed = append(allowed, record)
+		}
+	}
+
+	return allowed
+}
+
+// ResolveDNSName Shows which records would answer an address query for a name, and which wildcards they shadow
+func (dc *DnsController) ResolveDNSName(ctx echo.Context) error {
+	name := ctx.Param("name")
+	if !hostnameAllowed(ctx, name) {
+		return forbiddenHostname(ctx, name)
+	}
+
+	resolution, err := dc.ds.Resolve(name)
+	if err != nil {
+		if err.Error() == service.ErrorNoAnswer {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"message": "no record answers for '" + name + "'"})
+		} // implicit else
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, resolution)
 }
 
 func (dc *DnsController) GetDNSRecord(ctx echo.Context) error {
 	hostname := ctx.Param("hostname")
-	records, err := dc.ds.GetIPByHost(hostname)
+	if !hostnameAllowed(ctx, hostname) {
+		return forbiddenHostname(ctx, hostname)
+	}
+	recordType, err := recordTypeParam(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	records, rev, err := dc.ds.GetRecordsByHostWithRevision(hostname, recordType)
 	if err != nil {
 		if err.Error() == service.ErrorNoIPForHost {
 			return ctx.JSON(http.StatusNotFound, echo.Map{"message": "hostname not found"})
@@ -53,11 +232,23 @@ func (dc *DnsController) GetDNSRecord(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
 	} // implicit else
 
+	// The ETag is the hostname's revision, which changes with every change to any of its records
+	etag := model.RevisionETag(rev)
+	ctx.Response().Header().Set("ETag", etag)
+	if ifNoneMatch := etagList(ctx.Request().Header.Get("If-None-Match")); len(ifNoneMatch) > 0 {
+		if !(model.Precondition{IfNoneMatch: ifNoneMatch}).Met(etag) {
+			return ctx.NoContent(http.StatusNotModified)
+		}
+	}
+
 	return ctx.JSON(http.StatusOK, records)
 }
 
 func (dc *DnsController) SetDNSRecord(ctx echo.Context) error {
 	hostname := ctx.Param("hostname")
+	if !hostnameAllowed(ctx, hostname) {
+		return forbiddenHostname(ctx, hostname)
+	}
 	// Parse the append query parameter
 	appendStr := ctx.QueryParam("append")
 	appendIP, err := strconv.ParseBool(appendStr)
@@ -70,16 +261,43 @@ func (dc *DnsController) SetDNSRecord(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body: " + err.Error()})
 	}
 
-	if len(req.IPs) == 0 {
-		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "IP address list is required"})
+	// A wildcard may be asked for on the request or on any of its records
+	wildcard := req.Wildcard
+	for _, record := range req.Records {
+		wildcard = wildcard || record.Wildcard
 	}
-
-	records, err := dc.ds.SetIPByHost(hostname, req.IPs, appendIP)
+	defaultType, err := dc.ds.DefaultRecordType(hostname)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	recordType, err := model.RequestRecordType(req.Type, wildcard, defaultType)
 	if err != nil {
 		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
 	}
-	if err = dc.ds.UpdateDNSMasq(); err != nil {
-		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+
+	// ips is shorthand for records that only carry an IP
+	records := req.Records
+	for _, ip := range req.IPs {
+		records = append(records, model.DNSRecord{IP: ip})
+	}
+	records = model.WithDefaults(records, defaultOwner(ctx, req.RecordMetadata), req.TTL)
+	if len(records) == 0 {
+		if recordType == model.RecordTypeAddress || recordType == model.RecordTypeHost {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "IP address list is required"})
+		}
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "record list is required"})
+	}
+
+	records, err = dc.ds.SetRecordsByHost(preconditionContext(ctx), hostname, recordType, records, appendIP)
+	if err != nil {
+		return recordErrorResponse(ctx, err)
+	}
+	dc.setETag(ctx, hostname)
+	pending, err := awaitUpdate(ctx, dc.ds.ScheduleUpdate())
+	if err != nil {
+		return updateErrorResponse(ctx, err)
+	} else if pending {
+		return ctx.JSON(http.StatusAccepted, records)
 	}
 
 	return ctx.JSON(http.StatusOK, records)
@@ -87,14 +305,235 @@ func (dc *DnsController) SetDNSRecord(ctx echo.Context) error {
 
 func (dc *DnsController) DeleteDNSRecord(ctx echo.Context) error {
 	hostname := ctx.Param("hostname")
+	if !hostnameAllowed(ctx, hostname) {
+		return forbiddenHostname(ctx, hostname)
+	}
+	recordType, err := recordTypeParam(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
 
-	err := dc.ds.DeleteByHost(hostname)
+	err = dc.ds.DeleteRecordsByHost(preconditionContext(ctx), hostname, recordType)
+	if err != nil {
+		return recordErrorResponse(ctx, err)
+	}
+	pending, err := awaitUpdate(ctx, dc.ds.ScheduleUpdate())
+	if err != nil {
+		return updateErrorResponse(ctx, err)
+	} else if pending {
+		return ctx.JSON(http.StatusAccepted, echo.Map{"message": "hostname deleted, update pending"})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "hostname deleted"})
+}
+
+// RemoveDNSRecordIP Removes a single IP from a hostname, leaving its other records. The hostname is deleted along with
+// its last record
+func (dc *DnsController) RemoveDNSRecordIP(ctx echo.Context) error {
+	hostname := ctx.Param("hostname")
+	if !hostnameAllowed(ctx, hostname) {
+		return forbiddenHostname(ctx, hostname)
+	}
+	recordType, err := recordTypeParam(ctx)
 	if err != nil {
 		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
 	}
-	if err = dc.ds.UpdateDNSMasq(); err != nil {
+
+	records, err := dc.ds.RemoveIPByHost(preconditionContext(ctx), hostname, recordType, ctx.Param("ip"))
+	if err != nil {
+		if err.Error() == service.ErrorNoIPForHost || err.Error() == service.ErrorIPNotFound {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"message": err.Error()})
+		} // implicit else
+
+		return recordErrorResponse(ctx, err)
+	}
+	if len(records) == 0 {
+		records = []model.DNSRecord{}
+	} else {
+		dc.setETag(ctx, hostname)
+	}
+	pending, err := awaitUpdate(ctx, dc.ds.ScheduleUpdate())
+	if err != nil {
+		return updateErrorResponse(ctx, err)
+	} else if pending {
+		return ctx.JSON(http.StatusAccepted, records)
+	}
+
+	return ctx.JSON(http.StatusOK, records)
+}
+
+// PatchDNSRecord Adds and removes IPs of a hostname in one change, leaving its other records. The hostname is deleted
+// if no records are left
+func (dc *DnsController) PatchDNSRecord(ctx echo.Context) error {
+	hostname := ctx.Param("hostname")
+	if !hostnameAllowed(ctx, hostname) {
+		return forbiddenHostname(ctx, hostname)
+	}
+
+	req := model.PatchDNSRecordRequest{}
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body: " + err.Error()})
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "add or remove list is required"})
+	}
+
+	defaultType, err := dc.ds.DefaultRecordType(hostname)
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
+	recordType, err := model.RequestRecordType(req.Type, req.Wildcard, defaultType)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
 
-	return ctx.JSON(http.StatusOK, echo.Map{"message": "hostname deleted"})
+	var add []model.DNSRecord
+	for _, ip := range req.Add {
+		add = append(add, model.DNSRecord{IP: ip})
+	}
+	add = model.WithDefaults(add, defaultOwner(ctx, req.RecordMetadata), req.TTL)
+
+	records, err := dc.ds.PatchRecordsByHost(preconditionContext(ctx), hostname, recordType, add, req.Remove)
+	if err != nil {
+		if err.Error() == service.ErrorNoIPForHost || err.Error() == service.ErrorIPNotFound {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"message": err.Error()})
+		} // implicit else
+
+		return recordErrorResponse(ctx, err)
+	}
+	if len(records) == 0 {
+		records = []model.DNSRecord{}
+	} else {
+		dc.setETag(ctx, hostname)
+	}
+	pending, err := awaitUpdate(ctx, dc.ds.ScheduleUpdate())
+	if err != nil {
+		return updateErrorResponse(ctx, err)
+	} else if pending {
+		return ctx.JSON(http.StatusAccepted, records)
+	}
+
+	return ctx.JSON(http.StatusOK, records)
+}
+
+// RenewDNSRecord Extends the expiry of a hostname's records, as a heartbeat from clients that register temporary
+// hostnames
+func (dc *DnsController) RenewDNSRecord(ctx echo.Context) error {
+	hostname := ctx.Param("hostname")
+	if !hostnameAllowed(ctx, hostname) {
+		return forbiddenHostname(ctx, hostname)
+	}
+
+	req := model.RenewDNSRecordRequest{}
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body: " + err.Error()})
+	}
+
+	records, err := dc.ds.RenewRecordsByHost(preconditionContext(ctx), hostname, req)
+	if err != nil {
+		if err.Error() == service.ErrorNoIPForHost {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"message": "hostname not found"})
+		} // implicit else
+
+		return recordErrorResponse(ctx, err)
+	}
+	dc.setETag(ctx, hostname)
+	// The new expiry is only kept in the config file's metadata, so dnsmasq is not reloaded for it
+	pending, err := awaitUpdate(ctx, dc.ds.ScheduleUpdate())
+	if err != nil {
+		return updateErrorResponse(ctx, err)
+	} else if pending {
+		return ctx.JSON(http.StatusAccepted, records)
+	}
+
+	return ctx.JSON(http.StatusOK, records)
+}
+
+// GetDNSRecordHistory Lists the revisions kept for a hostname, oldest first
+func (dc *DnsController) GetDNSRecordHistory(ctx echo.Context) error {
+	hostname := ctx.Param("hostname")
+	if !hostnameAllowed(ctx, hostname) {
+		return forbiddenHostname(ctx, hostname)
+	}
+
+	revisions, err := dc.ds.GetHostHistory(hostname)
+	if err != nil {
+		if err.Error() == service.ErrorNoHistory {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"message": "hostname not found"})
+		} // implicit else
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, revisions)
+}
+
+// RevertDNSRecord Sets a hostname's records back to those of the revision in the to query parameter
+func (dc *DnsController) RevertDNSRecord(ctx echo.Context) error {
+	hostname := ctx.Param("hostname")
+	if !hostnameAllowed(ctx, hostname) {
+		return forbiddenHostname(ctx, hostname)
+	}
+	rev, err := strconv.ParseUint(ctx.QueryParam("to"), 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "the to query parameter must be a revision number"})
+	}
+
+	records, err := dc.ds.RevertHost(requestContext(ctx), hostname, rev)
+	if err != nil {
+		var validationErr *service.ValidationError
+		if err.Error() == service.ErrorNoHistory || err.Error() == service.ErrorNoRevision {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"message": err.Error()})
+		} else if errors.As(err, &validationErr) || err.Error() == service.ErrorConfigDrift {
+			return recordErrorResponse(ctx, err)
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	pending, err := awaitUpdate(ctx, dc.ds.ScheduleUpdate())
+	if err != nil {
+		return updateErrorResponse(ctx, err)
+	} else if pending {
+		return ctx.JSON(http.StatusAccepted, records)
+	}
+
+	return ctx.JSON(http.StatusOK, records)
+}
+
+// BulkDNSRecords Applies a list of operations all at once, followed by a single config update.
+// If any operation fails none are applied, and the results say which failed
+func (dc *DnsController) BulkDNSRecords(ctx echo.Context) error {
+	req := model.DNSBulkRequest{}
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body: " + err.Error()})
+	}
+	if len(req.Operations) == 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "operation list is required"})
+	}
+	for i, op := range req.Operations {
+		if !hostnameAllowed(ctx, op.Hostname) {
+			return forbiddenHostname(ctx, op.Hostname)
+		}
+		req.Operations[i].RecordMetadata = defaultOwner(ctx, op.RecordMetadata)
+	}
+
+	results, err := dc.ds.ApplyBulk(requestContext(ctx), req.Operations)
+	if err != nil {
+		if err.Error() == service.ErrorBulkFailed {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error(), "results": results})
+		} else if err.Error() == service.ErrorConfigDrift {
+			return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	pending, err := awaitUpdate(ctx, dc.ds.ScheduleUpdate())
+	if err != nil {
+		return updateErrorResponse(ctx, err)
+	} else if pending {
+		return ctx.JSON(http.StatusAccepted, echo.Map{"results": results})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"results": results})
 }
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/cclose/dnsmasq-api/service"
+	"github.com/labstack/echo/v4"
+)
+
+// exportContentTypes The content type of each export format
+var exportContentTypes = map[string]string{
+	model.ExportFormatHosts:   echo.MIMETextPlainCharsetUTF8,
+	model.ExportFormatDNSMasq: echo.MIMETextPlainCharsetUTF8,
+	model.ExportFormatZone:    echo.MIMETextPlainCharsetUTF8,
+	model.ExportFormatCSV:     "text/csv; charset=UTF-8",
+	model.ExportFormatYAML:    "application/yaml; charset=UTF-8",
+	model.ExportFormatJSON:    echo.MIMEApplicationJSONCharsetUTF8,
+}
+
+type IExportController interface {
+	Export(ctx echo.Context) error
+	Register(e *echo.Echo)
+}
+
+type ExportController struct {
+	ds service.IDNSMasqService
+}
+
+func NewExportController(ds service.IDNSMasqService) IExportController {
+	return &ExportController{
+		ds: ds,
+	}
+}
+
+func (ec *ExportController) Register(e *echo.Echo) {
+	e.GET("/export", ec.Export)
+}
+
+// Export Renders the records in the format given by the format query parameter, json by default. The type, owner and
+// tag query parameters filter the records as they do for GET /dns
+func (ec *ExportController) Export(ctx echo.Context) error {
+	format := strings.ToLower(ctx.QueryParam("format"))
+	if format == "" {
+		format = model.ExportFormatJSON
+	}
+	recordType, err := recordTypeParam(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	filter := model.DNSRecordFilter{Type: recordType, Owner: ctx.QueryParam("owner"), Tag: ctx.QueryParam("tag")}
+	records, err := ec.ds.FindRecords(filter)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	data, err := service.RenderExport(format, allowedRecords(ctx, records))
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.Blob(http.StatusOK, exportContentTypes[format], data)
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/cclose/dnsmasq-api/service"
+	"github.com/labstack/echo/v4"
+)
+
+type IImportController interface {
+	Import(ctx echo.Context) error
+	Register(e *echo.Echo)
+}
+
+type ImportController struct {
+	ds service.IDNSMasqService
+}
+
+func NewImportController(ds service.IDNSMasqService) IImportController {
+	return &ImportController{
+		ds: ds,
+	}
+}
+
+func (ic *ImportController) Register(e *echo.Echo) {
+	e.POST("/import", ic.Import)
+}
+
+// Import Imports the records of the file in the request body, in the format given by the format query parameter. The
+// mode query parameter merges them into the records or replaces them, and dry_run only reports the changes
+func (ic *ImportController) Import(ctx echo.Context) error {
+	format := ctx.QueryParam("format")
+	if format == "" {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "format is required"})
+	}
+	mode, err := model.ParseImportMode(ctx.QueryParam("mode"))
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	dryRun, _ := strconv.ParseBool(ctx.QueryParam("dry_run"))
+
+	data, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body: " + err.Error()})
+	}
+	records, issues, err := service.ParseImport(format, "", data)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	for i := range records {
+		records[i].RecordMetadata = defaultOwner(ctx, records[i].RecordMetadata)
+	}
+
+	result, err := ic.ds.ImportRecords(requestContext(ctx), records, model.ImportOptions{Mode: mode, DryRun: dryRun})
+	result.Skipped = append(result.Skipped, issues...)
+	if err != nil {
+		if err.Error() == service.ErrorBulkFailed {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error(), "result": result})
+		} else if err.Error() == service.ErrorConfigDrift {
+			return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	if dryRun || len(result.Changes) == 0 {
+		return ctx.JSON(http.StatusOK, result)
+	}
+
+	pending, err := awaitUpdate(ctx, ic.ds.ScheduleUpdate())
+	if err != nil {
+		return updateErrorResponse(ctx, err)
+	} else if pending {
+		return ctx.JSON(http.StatusAccepted, result)
+	}
+
+	return ctx.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/cclose/dnsmasq-api/service"
+	"github.com/labstack/echo/v4"
+)
+
+type ISnapshotController interface {
+	GetSnapshots(ctx echo.Context) error
+	CreateSnapshot(ctx echo.Context) error
+	RestoreSnapshot(ctx echo.Context) error
+	Register(e *echo.Echo)
+}
+
+type SnapshotController struct {
+	ds service.IDNSMasqService
+}
+
+func NewSnapshotController(ds service.IDNSMasqService) ISnapshotController {
+	return &SnapshotController{
+		ds: ds,
+	}
+}
+
+func (sc *SnapshotController) Register(e *echo.Echo) {
+	e.GET("/snapshots", sc.GetSnapshots)
+	e.POST("/snapshots", sc.CreateSnapshot)
+	e.POST("/snapshots/:id/restore", sc.RestoreSnapshot)
+}
+
+func (sc *SnapshotController) GetSnapshots(ctx echo.Context) error {
+	snapshots, err := sc.ds.ListSnapshots()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, snapshots)
+}
+
+func (sc *SnapshotController) CreateSnapshot(ctx echo.Context) error {
+	req := model.CreateSnapshotRequest{}
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body: " + err.Error()})
+	}
+
+	snapshot, err := sc.ds.CreateSnapshot(requestContext(ctx), req.Description)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusCreated, snapshot)
+}
+
+// RestoreSnapshot Replaces every DNS record with those in the snapshot and rewrites the config
+func (sc *SnapshotController) RestoreSnapshot(ctx echo.Context) error {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": "invalid snapshot id '" + ctx.Param("id") + "'"})
+	}
+
+	changed, err := sc.ds.RestoreSnapshot(requestContext(ctx), id)
+	if err != nil {
+		var validationErr *service.ValidationError
+		if err.Error() == service.ErrorNoSnapshot {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"message": err.Error()})
+		} else if errors.As(err, &validationErr) || err.Error() == service.ErrorConfigDrift {
+			return recordErrorResponse(ctx, err)
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	pending, err := awaitUpdate(ctx, sc.ds.ScheduleUpdate())
+	if err != nil {
+		return updateErrorResponse(ctx, err)
+	} else if pending {
+		return ctx.JSON(http.StatusAccepted, echo.Map{"message": "snapshot restored, update pending", "hostnames_changed": changed})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "snapshot restored", "hostnames_changed": changed})
+}
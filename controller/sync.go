@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/service"
+	"github.com/labstack/echo/v4"
+)
+
+type ISyncController interface {
+	GetSyncStatus(ctx echo.Context) error
+	Reconcile(ctx echo.Context) error
+	Register(e *echo.Echo)
+}
+
+type SyncController struct {
+	ds service.IDNSMasqService
+}
+
+func NewSyncController(ds service.IDNSMasqService) ISyncController {
+	return &SyncController{
+		ds: ds,
+	}
+}
+
+func (sc *SyncController) Register(e *echo.Echo) {
+	e.GET("/sync/status", sc.GetSyncStatus)
+	e.POST("/sync", sc.Reconcile)
+}
+
+// GetSyncStatus Reports the differences between the database and the managed files
+func (sc *SyncController) GetSyncStatus(ctx echo.Context) error {
+	status, err := sc.ds.SyncStatus()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, status)
+}
+
+// Reconcile Resolves changes made to the managed files outside the API by the policy query parameter, or the
+// configured policy, and writes the result out
+func (sc *SyncController) Reconcile(ctx echo.Context) error {
+	status, err := sc.ds.Reconcile(ctx.QueryParam("policy"))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "unknown sync policy") {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		} else if err.Error() == service.ErrorConfigDrift {
+			return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error(), "status": status})
+		} // implicit else
+
+		return updateErrorResponse(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, status)
+}
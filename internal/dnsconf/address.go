@@ -0,0 +1,60 @@
+package dnsconf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// DomainAll is the domain of an address matching every domain, as in address=/#/10.0.0.1
+	DomainAll = "#"
+	// NullIP is the IP of an address answering 0.0.0.0 and ::, as in address=/ads.example/#
+	NullIP = "#"
+)
+
+// Address The value of an address=/<domain>[/<domain>...]/[<ip>] option. Each domain matches itself and every name
+// under it
+type Address struct {
+	Domains []string
+	// IP is empty for a blackhole, which answers NXDOMAIN, or NullIP
+	IP string
+}
+
+// Blackhole reports whether the address answers NXDOMAIN for its domains
+func (a Address) Blackhole() bool {
+	return a.IP == ""
+}
+
+// Null reports whether the address answers the null addresses 0.0.0.0 and :: for its domains
+func (a Address) Null() bool {
+	return a.IP == NullIP
+}
+
+// AllDomains reports whether the address matches every domain
+func (a Address) AllDomains() bool {
+	for _, domain := range a.Domains {
+		if domain == DomainAll {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseAddress parses the value of an address option
+func ParseAddress(value string) (Address, error) {
+	var address Address
+	parts := strings.Split(strings.TrimSpace(value), "/")
+	if len(parts) < 3 || parts[0] != "" {
+		return address, fmt.Errorf("address must be /<domain>[/<domain>...]/[<ip>]")
+	}
+
+	address.Domains = parts[1 : len(parts)-1]
+	address.IP = strings.TrimSpace(parts[len(parts)-1])
+	if !address.Blackhole() && !address.Null() && net.ParseIP(address.IP) == nil {
+		return address, fmt.Errorf("invalid ip '%s' for address", address.IP)
+	}
+
+	return address, nil
+}
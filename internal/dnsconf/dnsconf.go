@@ -0,0 +1,145 @@
+// Package dnsconf parses dnsmasq config files into their lines and options, keeping the file and line number each
+// came from. It follows the conf-file and conf-dir includes the way dnsmasq does, and reports the lines it could not
+// use instead of dropping them.
+package dnsconf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Options that include other config files
+const (
+	OptionConfFile = "conf-file"
+	OptionConfDir  = "conf-dir"
+)
+
+// LineKind What a line of a config file holds
+type LineKind int
+
+const (
+	KindBlank LineKind = iota
+	KindComment
+	KindOption
+)
+
+// Position Where a line came from
+type Position struct {
+	File string `json:"file,omitempty"`
+	Line int    `json:"line"`
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d", p.Line)
+	}
+
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// Line A single line of a config file. Options are key=value, or just a key for flags like domain-needed
+type Line struct {
+	Position
+	Kind LineKind
+	// Raw is the line as written
+	Raw string
+	// Key and Value are set for options. The value has any trailing comment removed
+	Key   string
+	Value string
+}
+
+// Issue A line that could not be used, and why
+type Issue struct {
+	Position
+	Text   string `json:"text"`
+	Reason string `json:"reason"`
+}
+
+// IssueAt Creates an Issue for the line
+func IssueAt(line Line, reason string) Issue {
+	return Issue{Position: line.Position, Text: line.Raw, Reason: reason}
+}
+
+// Config The lines of a config and every file it includes, in the order dnsmasq reads them
+type Config struct {
+	Lines []Line
+	// Files are the files that were read, in the order they were read
+	Files []string
+	// Issues are the includes that could not be followed
+	Issues []Issue
+}
+
+// Options returns the option lines of the config
+func (c *Config) Options() []Line {
+	var options []Line
+	for _, line := range c.Lines {
+		if line.Kind == KindOption {
+			options = append(options, line)
+		}
+	}
+
+	return options
+}
+
+// ParseLine parses a single line of a config. Like dnsmasq, a # starts a comment at the start of the line or after
+// whitespace outside of quotes, so the # in address=/#/10.0.0.1 is part of the value
+func ParseLine(raw string) Line {
+	line := Line{Raw: raw}
+	text := strings.TrimSpace(stripComment(raw))
+	if text == "" {
+		line.Kind = KindBlank
+		if strings.HasPrefix(strings.TrimSpace(raw), "#") {
+			line.Kind = KindComment
+		}
+		return line
+	}
+
+	line.Kind = KindOption
+	key, value, _ := strings.Cut(text, "=")
+	line.Key = strings.TrimSpace(key)
+	line.Value = strings.TrimSpace(value)
+
+	return line
+}
+
+// stripComment removes a comment from the line
+func stripComment(raw string) string {
+	inQuotes := false
+	white := true
+	for i, c := range raw {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '#' && white && !inQuotes:
+			return raw[:i]
+		}
+		white = c == ' ' || c == '\t'
+	}
+
+	return raw
+}
+
+// Parse parses the lines of a single config, named name in the positions. Includes are kept as options, but not
+// followed
+func Parse(r io.Reader, name string) (*Config, error) {
+	config := &Config{Files: []string{name}}
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		line := ParseLine(scanner.Text())
+		line.Position = Position{File: name, Line: number}
+		config.Lines = append(config.Lines, line)
+	}
+
+	return config, scanner.Err()
+}
+
+// ParseString parses the lines of a single config held in a string, as Parse
+func ParseString(data, name string) *Config {
+	// Reading from a string can't fail
+	config, _ := Parse(strings.NewReader(data), name)
+	return config
+}
@@ -0,0 +1,116 @@
+package dnsconf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		kind  LineKind
+		key   string
+		value string
+	}{
+		{name: "Blank", raw: "   ", kind: KindBlank},
+		{name: "Comment", raw: "  # Managed by DNSMasq API", kind: KindComment},
+		{name: "Option", raw: "address=/a.lan/10.0.0.1", kind: KindOption, key: "address", value: "/a.lan/10.0.0.1"},
+		{name: "Flag", raw: "domain-needed", kind: KindOption, key: "domain-needed"},
+		{name: "Spaces", raw: "  cname = www.lan,a.lan  ", kind: KindOption, key: "cname", value: "www.lan,a.lan"},
+		{name: "TrailingComment", raw: "address=/a.lan/10.0.0.1 # office", kind: KindOption, key: "address",
+			value: "/a.lan/10.0.0.1"},
+		{name: "HashInValue", raw: "address=/#/10.0.0.1", kind: KindOption, key: "address", value: "/#/10.0.0.1"},
+		{name: "HashInQuotes", raw: `txt-record=a.lan,"x # y"`, kind: KindOption, key: "txt-record",
+			value: `a.lan,"x # y"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := ParseLine(tt.raw)
+			assert.Equal(t, tt.kind, line.Kind)
+			assert.Equal(t, tt.key, line.Key)
+			assert.Equal(t, tt.value, line.Value)
+			assert.Equal(t, tt.raw, line.Raw)
+		})
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		domains   []string
+		ip        string
+		blackhole bool
+		null      bool
+		all       bool
+		wantErr   bool
+	}{
+		{name: "Single", value: "/a.lan/10.0.0.1", domains: []string{"a.lan"}, ip: "10.0.0.1"},
+		{name: "MultiDomain", value: "/a.com/b.com/fd00::1", domains: []string{"a.com", "b.com"}, ip: "fd00::1"},
+		{name: "Blackhole", value: "/ads.lan/", domains: []string{"ads.lan"}, blackhole: true},
+		{name: "Null", value: "/ads.lan/#", domains: []string{"ads.lan"}, ip: "#", null: true},
+		{name: "AllDomains", value: "/#/10.0.0.1", domains: []string{"#"}, ip: "10.0.0.1", all: true},
+		{name: "NoDomain", value: "10.0.0.1", wantErr: true},
+		{name: "BadIP", value: "/a.lan/10.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, err := ParseAddress(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.domains, address.Domains)
+			assert.Equal(t, tt.ip, address.IP)
+			assert.Equal(t, tt.blackhole, address.Blackhole())
+			assert.Equal(t, tt.null, address.Null())
+			assert.Equal(t, tt.all, address.AllDomains())
+		})
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "dnsmasq.d")
+	require.NoError(t, os.Mkdir(confDir, 0755))
+	main := filepath.Join(dir, "dnsmasq.conf")
+	files := map[string]string{
+		main: "domain-needed\nconf-file=" + filepath.Join(dir, "extra.conf") + "\nconf-dir=" + confDir +
+			",*.conf\nconf-file=" + filepath.Join(dir, "missing.conf") + "\nconf-file=" + main + "\n",
+		filepath.Join(dir, "extra.conf"):       "# extra\naddress=/a.lan/10.0.0.1\n",
+		filepath.Join(confDir, "b.conf"):       "cname=www.lan,a.lan\n",
+		filepath.Join(confDir, "a.conf"):       "host-record=nas.lan,10.0.0.5\n",
+		filepath.Join(confDir, "c.conf.bak"):   "address=/old.lan/10.0.0.9\n",
+		filepath.Join(confDir, ".hidden.conf"): "address=/hidden.lan/10.0.0.9\n",
+	}
+	for path, data := range files {
+		require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	}
+
+	config, err := ParseFile(main)
+	require.NoError(t, err)
+	assert.Equal(t, []string{main, filepath.Join(dir, "extra.conf"), filepath.Join(confDir, "a.conf"),
+		filepath.Join(confDir, "b.conf")}, config.Files)
+
+	var keys []string
+	for _, option := range config.Options() {
+		keys = append(keys, option.Key)
+	}
+	assert.Equal(t, []string{"domain-needed", "conf-file", "address", "conf-dir", "host-record", "cname",
+		"conf-file", "conf-file"}, keys)
+	assert.Equal(t, Position{File: filepath.Join(confDir, "b.conf"), Line: 1}, config.Options()[5].Position)
+
+	require.Len(t, config.Issues, 2)
+	assert.Equal(t, 4, config.Issues[0].Line)
+	assert.Equal(t, 5, config.Issues[1].Line)
+	assert.Contains(t, config.Issues[1].Reason, "already included")
+
+	_, err = ParseFile(filepath.Join(dir, "missing.conf"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,119 @@
+package dnsconf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ParseFile parses a config file and every file it includes with conf-file and conf-dir, in the order dnsmasq reads
+// them. The include options are kept in the lines, followed by the lines of the files they include. Includes that
+// can't be read, or that were already read, are reported as issues. Relative paths are relative to the working
+// directory, as they are for dnsmasq
+func ParseFile(path string) (*Config, error) {
+	p := &includeParser{config: &Config{}, seen: make(map[string]bool)}
+	if err := p.parseFile(path); err != nil {
+		return nil, err
+	}
+
+	return p.config, nil
+}
+
+// includeParser Collects the lines of a config and its includes
+type includeParser struct {
+	config *Config
+	seen   map[string]bool
+}
+
+// parseFile appends the lines of a file, following its includes
+func (p *includeParser) parseFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	parsed, err := Parse(file, path)
+	if err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		p.seen[abs] = true
+	}
+	p.config.Files = append(p.config.Files, path)
+
+	for _, line := range parsed.Lines {
+		p.config.Lines = append(p.config.Lines, line)
+		if line.Kind != KindOption {
+			continue
+		}
+		switch line.Key {
+		case OptionConfFile:
+			p.include(line, line.Value)
+		case OptionConfDir:
+			p.includeDir(line)
+		}
+	}
+
+	return nil
+}
+
+// include parses an included file, reporting it against the line including it if it can't be
+func (p *includeParser) include(line Line, path string) {
+	if path == "" {
+		p.config.Issues = append(p.config.Issues, IssueAt(line, "the default config file is not included"))
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil && p.seen[abs] {
+		p.config.Issues = append(p.config.Issues, IssueAt(line, path+" is already included"))
+		return
+	}
+	if err := p.parseFile(path); err != nil {
+		p.config.Issues = append(p.config.Issues, IssueAt(line, err.Error()))
+	}
+}
+
+// includeDir parses the files in a conf-dir=<dir>[,<extension>...] directory in alphabetical order. Extensions
+// starting with * are the only ones included, and any others are excluded. Like dnsmasq, hidden files, editor backups
+// ending in ~ and files starting and ending with # are skipped
+func (p *includeParser) includeDir(line Line) {
+	fields := strings.Split(line.Value, ",")
+	dir := strings.TrimSpace(fields[0])
+	var only, except []string
+	for _, field := range fields[1:] {
+		field = strings.TrimSpace(field)
+		if ext, found := strings.CutPrefix(field, "*"); found {
+			only = append(only, ext)
+		} else if field != "" {
+			except = append(except, field)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		p.config.Issues = append(p.config.Issues, IssueAt(line, err.Error()))
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") ||
+			(strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#")) {
+			continue
+		}
+		if len(only) > 0 && !hasAnySuffix(name, only) || hasAnySuffix(name, except) {
+			continue
+		}
+		p.include(line, filepath.Join(dir, name))
+	}
+}
+
+// hasAnySuffix reports whether name ends with any of the suffixes
+func hasAnySuffix(name string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,130 @@
+// Package tlsreload serves a TLS certificate and client CA pool that are reloaded from disk when their files change,
+// so certificates can be renewed without restarting the server.
+package tlsreload
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkInterval is how often the files are checked for changes, at most once per handshake
+const checkInterval = time.Second
+
+// Store Holds the current certificate and client CA pool
+type Store struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+	// OnError is called when changed files fail to load. The previous certificate and CAs stay in use
+	OnError func(err error)
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	modTimes  map[string]time.Time
+	config    *tls.Config
+}
+
+// New Creates a Store from the certificate, key, and optional client CA files
+func New(certFile, keyFile, caFile string, clientAuth tls.ClientAuthType) (*Store, error) {
+	s := &Store{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		caFile:     caFile,
+		clientAuth: clientAuth,
+		OnError:    func(err error) {},
+		modTimes:   make(map[string]time.Time),
+	}
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// TLSConfig returns a server tls.Config that uses the latest certificate and client CAs on each handshake
+func (s *Store) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.current(), nil
+		},
+	}
+}
+
+// current returns the config for a handshake, reloading it first if the files changed
+func (s *Store) current() *tls.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastCheck) >= checkInterval {
+		s.lastCheck = time.Now()
+		if _, err := s.reloadLocked(); err != nil {
+			s.OnError(err)
+		}
+	}
+
+	return s.config
+}
+
+// reload loads the files if any of them changed since they were last loaded, reporting whether they were
+func (s *Store) reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reloadLocked()
+}
+
+func (s *Store) reloadLocked() (bool, error) {
+	files := []string{s.certFile, s.keyFile}
+	if s.caFile != "" {
+		files = append(files, s.caFile)
+	}
+
+	changed := s.config == nil
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modTimes[file] = info.ModTime()
+		if !info.ModTime().Equal(s.modTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return false, err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.NoClientCert,
+	}
+	if s.caFile != "" {
+		data, err := os.ReadFile(s.caFile)
+		if err != nil {
+			return false, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return false, fmt.Errorf("no certificates found in %s", s.caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = s.clientAuth
+	}
+
+	s.config = config
+	s.modTimes = modTimes
+
+	return true, nil
+}
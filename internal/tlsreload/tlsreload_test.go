@@ -0,0 +1,175 @@
+package tlsreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCert A generated certificate and key
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert generates a certificate for the common name, signed by parent or self signed if parent is nil
+func newTestCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// writeFile writes a file with a modification time in the future, so a rewrite is always seen as a change
+func writeFile(t *testing.T, path string, data []byte, age time.Duration) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	modTime := time.Now().Add(age)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+// handshake connects to a TLS server using the store's config, returning the server certificate it presented
+func handshake(t *testing.T, store *Store, clientCert *testCert, roots *x509.CertPool) (*x509.Certificate, error) {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", store.TLSConfig())
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.(*tls.Conn).Handshake()
+		_ = conn.Close()
+	}()
+
+	config := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+	if clientCert != nil {
+		pair, err := tls.X509KeyPair(clientCert.certPEM, clientCert.keyPEM)
+		require.NoError(t, err)
+		config.Certificates = []tls.Certificate{pair}
+	}
+	conn, err := tls.Dial("tcp", listener.Addr().String(), config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// With TLS 1.3 a rejected client certificate only shows up on the first read. Otherwise the server just hangs up
+	if _, err = conn.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestStore_ClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "Test CA", nil)
+	server := newTestCert(t, "127.0.0.1", ca)
+	client := newTestCert(t, "deploy", ca)
+	stranger := newTestCert(t, "deploy", newTestCert(t, "Other CA", nil))
+
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	writeFile(t, certFile, server.certPEM, 0)
+	writeFile(t, keyFile, server.keyPEM, 0)
+	writeFile(t, caFile, ca.certPEM, 0)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	store, err := New(certFile, keyFile, caFile, tls.RequireAndVerifyClientCert)
+	require.NoError(t, err)
+
+	_, err = handshake(t, store, client, roots)
+	assert.NoError(t, err)
+	_, err = handshake(t, store, nil, roots)
+	assert.Error(t, err)
+	_, err = handshake(t, store, stranger, roots)
+	assert.Error(t, err)
+}
+
+func TestStore_Reload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "Test CA", nil)
+	first := newTestCert(t, "127.0.0.1", ca)
+	second := newTestCert(t, "127.0.0.1", ca)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeFile(t, certFile, first.certPEM, 0)
+	writeFile(t, keyFile, first.keyPEM, 0)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	var reloadErr error
+	store, err := New(certFile, keyFile, "", tls.NoClientCert)
+	require.NoError(t, err)
+	store.OnError = func(err error) { reloadErr = err }
+
+	presented, err := handshake(t, store, nil, roots)
+	require.NoError(t, err)
+	assert.Equal(t, first.cert.SerialNumber, presented.SerialNumber)
+
+	// A renewed certificate is picked up without restarting
+	writeFile(t, certFile, second.certPEM, time.Minute)
+	writeFile(t, keyFile, second.keyPEM, time.Minute)
+	store.lastCheck = time.Time{}
+	presented, err = handshake(t, store, nil, roots)
+	require.NoError(t, err)
+	assert.Equal(t, second.cert.SerialNumber, presented.SerialNumber)
+
+	// A broken certificate is reported and the last good one kept
+	writeFile(t, certFile, []byte("garbage"), 2*time.Minute)
+	store.lastCheck = time.Time{}
+	presented, err = handshake(t, store, nil, roots)
+	require.NoError(t, err)
+	assert.Equal(t, second.cert.SerialNumber, presented.SerialNumber)
+	assert.Error(t, reloadErr)
+}
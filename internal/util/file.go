@@ -0,0 +1,138 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BackupPath returns the path of the nth backup of a file. Backups are hidden dot files in the same directory
+// so that dnsmasq's conf-dir, which skips dot files, does not load them.
+func BackupPath(path string, n int) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%d", filepath.Base(path), n))
+}
+
+// WriteFileAtomic writes data to a temp file in the same directory as path, fsyncs it and renames it over path,
+// so readers only ever see the old or the new file. If backups is greater than zero, the file being replaced is
+// kept as backup 1 and older backups are rotated, keeping at most backups copies.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode, backups int) error {
+	staged, err := StageFile(path, data, perm)
+	if err != nil {
+		return err
+	}
+
+	return CommitFile(staged, path, backups)
+}
+
+// StageFile writes data to a hidden temp file in the same directory as path and fsyncs it, returning the temp
+// file's path. The staged file can be inspected before it is moved into place with CommitFile.
+func StageFile(path string, data []byte, perm os.FileMode) (staged string, err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	// Clean up the temp file if we fail to stage it
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return "", err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return "", err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// CommitFile renames a file staged by StageFile over path, rotating backups of the file being replaced.
+// The staged file is removed if it cannot be committed.
+func CommitFile(staged, path string, backups int) (err error) {
+	defer func() {
+		if err != nil {
+			_ = os.Remove(staged)
+		}
+	}()
+
+	if backups > 0 {
+		if err = rotateBackups(path, backups); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Rename(staged, path); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// rotateBackups shifts the existing backups of path up by one, dropping the oldest, and saves path as backup 1
+func rotateBackups(path string, backups int) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// Nothing to back up yet
+		return nil
+	}
+
+	for i := backups - 1; i >= 1; i-- {
+		err := os.Rename(BackupPath(path, i), BackupPath(path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return CopyFile(path, BackupPath(path, 1))
+}
+
+// CopyFile copies the contents and mode of src to dst, replacing dst
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// syncDir fsyncs a directory so a rename within it is durable. Not all platforms support this, so it is best effort
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	_ = d.Sync()
+
+	return nil
+}
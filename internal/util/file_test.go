@@ -0,0 +1,42 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.conf")
+
+	for _, content := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		require.NoError(t, WriteFileAtomic(path, []byte(content), 0644, 2))
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "four\n", string(data))
+
+	data, err = os.ReadFile(BackupPath(path, 1))
+	require.NoError(t, err)
+	assert.Equal(t, "three\n", string(data))
+
+	data, err = os.ReadFile(BackupPath(path, 2))
+	require.NoError(t, err)
+	assert.Equal(t, "two\n", string(data))
+
+	assert.NoFileExists(t, BackupPath(path, 3))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// Only the file and its hidden backups are left behind, no temp files
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+// Audited operations, besides the bulk operations, which are recorded under their own names
+const (
+	AuditOpSet    = "set"
+	AuditOpAppend = "append"
+	AuditOpDelete = "delete"
+	// AuditOpRemove removes a single IP from a hostname
+	AuditOpRemove = "remove"
+	// AuditOpPatch adds and removes IPs of a hostname at once
+	AuditOpPatch  = "patch"
+	AuditOpRenew  = "renew"
+	AuditOpExpire = "expire"
+	// AuditOpLoad is a change read from a config file edited outside the API
+	AuditOpLoad = "load"
+	// AuditOpRollback undoes a change whose config dnsmasq rejected
+	AuditOpRollback = "rollback"
+	AuditOpRevert   = "revert"
+	AuditOpRestore  = "restore"
+)
+
+// AuditEntry A single change to the records of a hostname. Before and After hold every record of the hostname
+type AuditEntry struct {
+	ID        uint64      `json:"id"`
+	Timestamp time.Time   `json:"timestamp"`
+	Actor     string      `json:"actor,omitempty"`
+	SourceIP  string      `json:"source_ip,omitempty"`
+	Operation string      `json:"operation"`
+	Hostname  string      `json:"hostname"`
+	Before    []DNSRecord `json:"before"`
+	After     []DNSRecord `json:"after"`
+}
+
+// AuditQuery Selects a page of audit entries. Zero fields match everything
+type AuditQuery struct {
+	From     time.Time
+	To       time.Time
+	Hostname string
+	// After is the ID of the last entry of the previous page
+	After uint64
+	Limit int
+}
+
+// Matches reports whether the entry is selected by the query, ignoring the paging
+func (q AuditQuery) Matches(e AuditEntry) bool {
+	return (q.From.IsZero() || !e.Timestamp.Before(q.From)) &&
+		(q.To.IsZero() || e.Timestamp.Before(q.To)) &&
+		(q.Hostname == "" || e.Hostname == q.Hostname)
+}
+
+// AuditPage A page of audit entries, oldest first. Next is the After for the following page, or zero on the last page
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Next    uint64       `json:"next,omitempty"`
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Scope A permission granted to an API identity
+type Scope string
+
+const (
+	// ScopeRead allows reading records
+	ScopeRead Scope = "read"
+	// ScopeWrite allows changing records, and implies ScopeRead
+	ScopeWrite Scope = "write"
+	// ScopeAdmin allows everything
+	ScopeAdmin Scope = "admin"
+)
+
+// ParseScope parses a scope name
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(strings.ToLower(strings.TrimSpace(s))); scope {
+	case ScopeRead, ScopeWrite, ScopeAdmin:
+		return scope, nil
+	}
+
+	return "", fmt.Errorf("unknown scope '%s'", s)
+}
+
+// Identity Who is making an API request, and what they may do
+type Identity struct {
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+	// Hostnames restricts the identity to hostnames matching these patterns (see path.Match). Empty allows all
+	Hostnames []string `json:"hostnames,omitempty"`
+}
+
+// HasScope reports whether the identity was granted the scope, directly or through a broader scope
+func (id *Identity) HasScope(scope Scope) bool {
+	for _, granted := range id.Scopes {
+		switch {
+		case granted == scope, granted == ScopeAdmin:
+			return true
+		case granted == ScopeWrite && scope == ScopeRead:
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsHostname reports whether the identity may manage records for the hostname
+func (id *Identity) AllowsHostname(hostname string) bool {
+	if len(id.Hostnames) == 0 {
+		return true
+	}
+
+	hostname = strings.ToLower(hostname)
+	for _, pattern := range id.Hostnames {
+		if matched, _ := path.Match(strings.ToLower(pattern), hostname); matched {
+			return true
+		}
+	}
+
+	return false
+}
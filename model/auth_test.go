@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentity_HasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []Scope
+		scope  Scope
+		want   bool
+	}{
+		{name: "None", scopes: nil, scope: ScopeRead, want: false},
+		{name: "ReadRead", scopes: []Scope{ScopeRead}, scope: ScopeRead, want: true},
+		{name: "ReadWrite", scopes: []Scope{ScopeRead}, scope: ScopeWrite, want: false},
+		{name: "WriteRead", scopes: []Scope{ScopeWrite}, scope: ScopeRead, want: true},
+		{name: "WriteAdmin", scopes: []Scope{ScopeWrite}, scope: ScopeAdmin, want: false},
+		{name: "AdminWrite", scopes: []Scope{ScopeAdmin}, scope: ScopeWrite, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := &Identity{Name: "test", Scopes: tt.scopes}
+			assert.Equal(t, tt.want, id.HasScope(tt.scope))
+		})
+	}
+}
+
+func TestIdentity_AllowsHostname(t *testing.T) {
+	id := &Identity{Name: "test"}
+	assert.True(t, id.AllowsHostname("anything.lan"))
+
+	id.Hostnames = []string{"*.dev.lan", "build"}
+	assert.True(t, id.AllowsHostname("web.dev.lan"))
+	assert.True(t, id.AllowsHostname("WEB.DEV.LAN"))
+	assert.True(t, id.AllowsHostname("build"))
+	assert.False(t, id.AllowsHostname("dev.lan"))
+	assert.False(t, id.AllowsHostname("web.prod.lan"))
+}
+
+func TestParseScope(t *testing.T) {
+	scope, err := ParseScope(" Write ")
+	assert.NoError(t, err)
+	assert.Equal(t, ScopeWrite, scope)
+
+	_, err = ParseScope("root")
+	assert.Error(t, err)
+}
@@ -8,17 +8,123 @@ type AppConfig struct {
 }
 
 type Config struct {
-	DnsmasqConfig     string         `mapstructure:"dnsmasq_config"`
-	DB                DatabaseConfig `mapstructure:"db"`
-	Logging           LoggingConfig  `mapstructure:"logging"`
-	Port              int            `mapstructure:"port"`
-	SkipDNSMasqReload bool           `mapstructure:"skip_dnsmasq_reload"`
-	SSL               SSLConfig      `mapstructure:"ssl"`
+	Audit             AuditConfig      `mapstructure:"audit"`
+	Auth              AuthConfig       `mapstructure:"auth"`
+	DnsmasqConfig     string           `mapstructure:"dnsmasq_config"`
+	DnsmasqBackups    int              `mapstructure:"dnsmasq_backups"`
+	DHCPConfig        string           `mapstructure:"dhcp_config"`
+	DHCPLeasesFile    string           `mapstructure:"dhcp_leases_file"`
+	DB                DatabaseConfig   `mapstructure:"db"`
+	Debounce          DebounceConfig   `mapstructure:"debounce"`
+	Expiry            ExpiryConfig     `mapstructure:"expiry"`
+	History           HistoryConfig    `mapstructure:"history"`
+	Logging           LoggingConfig    `mapstructure:"logging"`
+	Output            OutputConfig     `mapstructure:"output"`
+	Port              int              `mapstructure:"port"`
+	Preflight         PreflightConfig  `mapstructure:"preflight"`
+	Reload            ReloadConfig     `mapstructure:"reload"`
+	SkipDNSMasqReload bool             `mapstructure:"skip_dnsmasq_reload"`
+	SSL               SSLConfig        `mapstructure:"ssl"`
+	Sync              SyncConfig       `mapstructure:"sync"`
+	Validation        ValidationConfig `mapstructure:"validation"`
+}
+
+type AuditConfig struct {
+	// ExportFile is a JSONL file every audit entry is also appended to, for shipping to a SIEM
+	ExportFile string `mapstructure:"export_file"`
+}
+
+type AuthConfig struct {
+	Enabled bool               `mapstructure:"enabled"`
+	Tokens  []TokenConfig      `mapstructure:"tokens"`
+	Clients []ClientCertConfig `mapstructure:"clients"`
+}
+
+type TokenConfig struct {
+	Name string `mapstructure:"name"`
+	// Hash is the hashed token, as printed by the token command
+	Hash      string   `mapstructure:"hash"`
+	Scopes    []string `mapstructure:"scopes"`
+	Hostnames []string `mapstructure:"hostnames"`
+}
+
+// ClientCertConfig Maps client certificates to an identity. A certificate matches if its subject common name matches
+// CommonName, or any of its DNS, email or URI SANs matches SAN
+type ClientCertConfig struct {
+	Name       string   `mapstructure:"name"`
+	CommonName string   `mapstructure:"common_name"`
+	SAN        string   `mapstructure:"san"`
+	Scopes     []string `mapstructure:"scopes"`
+	Hostnames  []string `mapstructure:"hostnames"`
 }
 
 type DatabaseConfig struct {
-	FilePath   string `mapstructure:"file_path"`
-	BucketName string `mapstructure:"bucket_name"`
+	FilePath        string `mapstructure:"file_path"`
+	BucketName      string `mapstructure:"bucket_name"`
+	DHCPBucketName  string `mapstructure:"dhcp_bucket_name"`
+	AuditBucketName string `mapstructure:"audit_bucket_name"`
+}
+
+type DebounceConfig struct {
+	Window   time.Duration `mapstructure:"window"`
+	MaxDelay time.Duration `mapstructure:"max_delay"`
+}
+
+// ExpiryConfig Settings for removing expired records
+type ExpiryConfig struct {
+	// ReapInterval is how often expired records are looked for. Zero disables the reaper
+	ReapInterval time.Duration `mapstructure:"reap_interval"`
+}
+
+// HistoryConfig Settings for the revision history kept for each hostname
+type HistoryConfig struct {
+	// MaxRevisions is how many revisions are kept per hostname. Zero keeps every revision
+	MaxRevisions int `mapstructure:"max_revisions"`
+}
+
+type PreflightConfig struct {
+	// Enabled checks every new config before it replaces the live one. Unset enables it if the command can be found
+	Enabled *bool    `mapstructure:"enabled"`
+	Command []string `mapstructure:"command"`
+}
+
+type ReloadConfig struct {
+	Strategy string   `mapstructure:"strategy"`
+	Unit     string   `mapstructure:"unit"`
+	PIDFile  string   `mapstructure:"pid_file"`
+	Command  []string `mapstructure:"command"`
+	Sudo     bool     `mapstructure:"sudo"`
+}
+
+type OutputConfig struct {
+	Mode      string `mapstructure:"mode"`
+	HostsFile string `mapstructure:"hosts_file"`
+	HostsDir  bool   `mapstructure:"hosts_dir"`
+	// Merge only manages a marked block inside the dnsmasq config and hosts file, keeping the lines around it
+	Merge  bool         `mapstructure:"merge"`
+	Reload ReloadConfig `mapstructure:"reload"`
+}
+
+// SyncConfig Settings for reconciling the managed files with the database when they are changed outside the API
+type SyncConfig struct {
+	// Policy decides which side wins, see SyncPolicies. Defaults to file-wins
+	Policy string `mapstructure:"policy"`
+	// Watch reconciles as soon as the files change, rather than on startup and the next write
+	Watch bool `mapstructure:"watch"`
+	// WatchDelay is how long the files must be left alone before a change is reconciled
+	WatchDelay time.Duration `mapstructure:"watch_delay"`
+}
+
+// ValidationConfig Policy for the records the API accepts. Empty lists allow everything
+type ValidationConfig struct {
+	// AllowedZones are the domains hostnames must be in, or equal to
+	AllowedZones []string `mapstructure:"allowed_zones"`
+	// AllowedCIDRs are the networks IPs must be in
+	AllowedCIDRs []string `mapstructure:"allowed_cidrs"`
+	// ForbiddenNames are hostname patterns (see path.Match) that may never be set
+	ForbiddenNames []string `mapstructure:"forbidden_names"`
+	// AllowShadowing accepts IP records that hide part of a wildcard, or a wildcard that is partly hidden
+	AllowShadowing bool `mapstructure:"allow_shadowing"`
 }
 
 type LoggingConfig struct {
@@ -28,9 +134,13 @@ type LoggingConfig struct {
 }
 
 type SSLConfig struct {
-	Enabled  bool   `mapstructure:"enabled"`
-	CertFile string `mapstructure:"cert_file"`
-	KeyFile  string `mapstructure:"key_file"`
+	Enabled      bool   `mapstructure:"enabled"`
+	CertFile     string `mapstructure:"cert_file"`
+	KeyFile      string `mapstructure:"key_file"`
+	ClientCAFile string `mapstructure:"client_ca_file"`
+	// ClientAuth is require (the default) to reject clients without a certificate, or verify to only check
+	// certificates that are presented
+	ClientAuth string `mapstructure:"client_auth"`
 }
 
 type BuildInfo struct {
@@ -4,6 +4,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -26,6 +27,7 @@ ssl:
   cert_file: "/path/to/cert"
   key_file: "/path/to/key"
 dnsmasq_config: "/path/to/dnsmasq.conf"
+dhcp_config: "/path/to/dhcp.conf"
 skip_dnsmasq_reload: true
 db:
   file_path: "/path/to/db"
@@ -37,6 +39,7 @@ logging:
 `,
 			want: Config{
 				DnsmasqConfig: "/path/to/dnsmasq.conf",
+				DHCPConfig:    "/path/to/dhcp.conf",
 				DB: DatabaseConfig{
 					FilePath:   "/path/to/db",
 					BucketName: "mybucket",
@@ -92,6 +95,45 @@ skip_dnsmasq_reload: true`,
 				SSL:               SSLConfig{Enabled: false},
 			},
 		},
+		{
+			name: "ReloadStrategy",
+			args: `---
+reload:
+  strategy: sighup
+  pid_file: /run/dnsmasq.pid
+  sudo: true
+  command: ["/usr/local/bin/reload-dns", "--now"]`,
+			want: Config{
+				Reload: ReloadConfig{
+					Strategy: "sighup",
+					PIDFile:  "/run/dnsmasq.pid",
+					Sudo:     true,
+					Command:  []string{"/usr/local/bin/reload-dns", "--now"},
+				},
+			},
+		},
+		{
+			name: "Debounce",
+			args: `---
+debounce:
+  window: 500ms
+  max_delay: 5s`,
+			want: Config{
+				Debounce: DebounceConfig{
+					Window:   500 * time.Millisecond,
+					MaxDelay: 5 * time.Second,
+				},
+			},
+		},
+		{
+			name: "Expiry",
+			args: `---
+expiry:
+  reap_interval: 30s`,
+			want: Config{
+				Expiry: ExpiryConfig{ReapInterval: 30 * time.Second},
+			},
+		},
 		{
 			name: "Invalid Boolean Value",
 			args: `---
@@ -139,7 +181,11 @@ port: fish
 			assert.Equal(t, tt.want.SSL.CertFile, config.SSL.CertFile)
 			assert.Equal(t, tt.want.SSL.KeyFile, config.SSL.KeyFile)
 			assert.Equal(t, tt.want.DnsmasqConfig, config.DnsmasqConfig)
+			assert.Equal(t, tt.want.DHCPConfig, config.DHCPConfig)
 			assert.Equal(t, tt.want.SkipDNSMasqReload, config.SkipDNSMasqReload)
+			assert.Equal(t, tt.want.Reload, config.Reload)
+			assert.Equal(t, tt.want.Debounce, config.Debounce)
+			assert.Equal(t, tt.want.Expiry, config.Expiry)
 			assert.Equal(t, tt.want.DB.FilePath, config.DB.FilePath)
 			assert.Equal(t, tt.want.DB.BucketName, config.DB.BucketName)
 			assert.Equal(t, tt.want.Logging.Level, config.Logging.Level)
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"net"
+	"time"
+)
+
+// DHCPHost A static DHCP reservation, rendered as a dhcp-host directive
+type DHCPHost struct {
+	MAC       string `json:"mac"`
+	IP        string `json:"ip"`
+	Hostname  string `json:"hostname,omitempty"`
+	LeaseTime string `json:"lease_time,omitempty"`
+}
+
+type SetDHCPHostRequest struct {
+	IP        string `json:"ip"`
+	Hostname  string `json:"hostname"`
+	LeaseTime string `json:"lease_time"`
+}
+
+// DHCPLease A live lease read from the dnsmasq lease file
+type DHCPLease struct {
+	// Expiry is nil for infinite leases
+	Expiry *time.Time `json:"expiry"`
+	// MAC is only set for DHCPv4 leases
+	MAC string `json:"mac,omitempty"`
+	// IAID is only set for DHCPv6 leases
+	IAID     string `json:"iaid,omitempty"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// DHCPLeaseFilter Limits which leases are returned. Empty fields match all leases
+type DHCPLeaseFilter struct {
+	MAC      string
+	Hostname string
+	Subnet   *net.IPNet
+}
+
+type PromoteDHCPLeaseRequest struct {
+	Hostname  string `json:"hostname"`
+	LeaseTime string `json:"lease_time"`
+}
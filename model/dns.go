@@ -1,10 +1,340 @@
 package model
 
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// RecordType identifies which dnsmasq directive a DNSRecord is rendered as
+type RecordType string
+
+const (
+	// RecordTypeAddress address=/<hostname>/<ip>, a wildcard answering for the hostname and every name under it
+	RecordTypeAddress RecordType = "address"
+	// RecordTypeHost host-record=<hostname>,<ip>, answering for exactly the hostname
+	RecordTypeHost RecordType = "host"
+	// RecordTypeCNAME cname=<hostname>,<target>
+	RecordTypeCNAME RecordType = "cname"
+	// RecordTypeTXT txt-record=<hostname>,"<text>"
+	RecordTypeTXT RecordType = "txt"
+	// RecordTypeSRV srv-host=<hostname>,<target>,<port>,<priority>,<weight>
+	RecordTypeSRV RecordType = "srv"
+	// RecordTypeMX mx-host=<hostname>,<target>,<priority>
+	RecordTypeMX RecordType = "mx"
+	// RecordTypePTR ptr-record=<hostname>,<target>
+	RecordTypePTR RecordType = "ptr"
+)
+
+// RecordTypes All supported record types, in the order they are rendered
+var RecordTypes = []RecordType{
+	RecordTypeAddress,
+	RecordTypeHost,
+	RecordTypeCNAME,
+	RecordTypeTXT,
+	RecordTypeSRV,
+	RecordTypeMX,
+	RecordTypePTR,
+}
+
+// ParseRecordType converts a string into a RecordType. An empty string is an address record
+func ParseRecordType(s string) (RecordType, error) {
+	if s == "" {
+		return RecordTypeAddress, nil
+	}
+	rt := RecordType(strings.ToLower(s))
+	for _, t := range RecordTypes {
+		if rt == t {
+			return rt, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown record type '%s'", s)
+}
+
+// RequestRecordType returns the type of the records created by a request from its type and wildcard flag. Wildcards
+// are address records, and only address records can be wildcards. Without either, the records are the default type
+func RequestRecordType(recordType RecordType, wildcard bool, defaultType RecordType) (RecordType, error) {
+	if recordType == "" {
+		if wildcard {
+			return RecordTypeAddress, nil
+		}
+		return defaultType, nil
+	}
+
+	rt, err := ParseRecordType(string(recordType))
+	if err != nil {
+		return "", err
+	}
+	if wildcard && rt != RecordTypeAddress {
+		return "", fmt.Errorf("only address records can be wildcards, not %s records", rt)
+	}
+
+	return rt, nil
+}
+
 type DNSRecord struct {
-	Hostname string `json:"hostname"`
-	IP       string `json:"ip"`
+	Hostname string     `json:"hostname" yaml:"hostname"`
+	Type     RecordType `json:"type" yaml:"type"`
+	IP       string     `json:"ip,omitempty" yaml:"ip,omitempty"`
+	Target   string     `json:"target,omitempty" yaml:"target,omitempty"`
+	Text     string     `json:"text,omitempty" yaml:"text,omitempty"`
+	Port     uint16     `json:"port,omitempty" yaml:"port,omitempty"`
+	Priority uint16     `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Weight   uint16     `json:"weight,omitempty" yaml:"weight,omitempty"`
+	// TTL in seconds, only rendered for host and cname records, which are the only ones dnsmasq takes a TTL for
+	TTL uint32 `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	// Wildcard is always set from the type when the record is encoded, see IsWildcard
+	Wildcard bool `json:"wildcard,omitempty" yaml:"wildcard,omitempty"`
+
+	RecordMetadata `yaml:",inline"`
+}
+
+// MarshalJSON encodes the record with Wildcard set from its type
+func (r DNSRecord) MarshalJSON() ([]byte, error) {
+	type plainRecord DNSRecord
+	plain := plainRecord(r)
+	plain.Wildcard = r.IsWildcard()
+
+	return json.Marshal(plain)
+}
+
+// MarshalYAML encodes the record with Wildcard set from its type, as MarshalJSON does
+func (r DNSRecord) MarshalYAML() (interface{}, error) {
+	type plainRecord DNSRecord
+	plain := plainRecord(r)
+	plain.Wildcard = r.IsWildcard()
+
+	return plain, nil
+}
+
+// IsWildcard reports whether the record answers for every name under its hostname as well as the hostname itself,
+// which is how dnsmasq treats address records
+func (r DNSRecord) IsWildcard() bool {
+	return r.RecordType() == RecordTypeAddress
+}
+
+// RecordMetadata Optional information about who owns a record and why it exists
+type RecordMetadata struct {
+	Owner       string     `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Description string     `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags        []string   `json:"tags,omitempty" yaml:"tags,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty" yaml:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty" yaml:"updated_at,omitempty"`
+	// ExpiresAt is when the record is removed by the reaper. Records without it never expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	// TTLSeconds is how long the record lives after it is set or renewed. Not to be confused with the DNS TTL
+	TTLSeconds uint32 `json:"ttl_seconds,omitempty" yaml:"ttl_seconds,omitempty"`
+}
+
+// IsZero reports whether no metadata is set
+func (m RecordMetadata) IsZero() bool {
+	return m.Owner == "" && m.Description == "" && len(m.Tags) == 0 && m.CreatedAt == nil && m.UpdatedAt == nil &&
+		m.ExpiresAt == nil && m.TTLSeconds == 0
+}
+
+// Expired reports whether the record has an expiry that has passed at the given time
+func (m RecordMetadata) Expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !m.ExpiresAt.After(now)
+}
+
+// HasTag reports whether the metadata has the tag
+func (m RecordMetadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithDefaults fills in the owner, description, tags, expiry and TTL of records that do not set their own
+func WithDefaults(records []DNSRecord, meta RecordMetadata, ttl uint32) []DNSRecord {
+	var filled []DNSRecord
+	for _, record := range records {
+		if record.Owner == "" {
+			record.Owner = meta.Owner
+		}
+		if record.Description == "" {
+			record.Description = meta.Description
+		}
+		if len(record.Tags) == 0 {
+			record.Tags = meta.Tags
+		}
+		if record.ExpiresAt == nil && record.TTLSeconds == 0 {
+			record.ExpiresAt = meta.ExpiresAt
+			record.TTLSeconds = meta.TTLSeconds
+		}
+		if record.TTL == 0 {
+			record.TTL = ttl
+		}
+		filled = append(filled, record)
+	}
+
+	return filled
+}
+
+// DNSRecordFilter Selects records by type, owner and tag. Empty fields match everything
+type DNSRecordFilter struct {
+	Type  RecordType
+	Owner string
+	Tag   string
+}
+
+// Matches reports whether the record is selected by the filter
+func (f DNSRecordFilter) Matches(r DNSRecord) bool {
+	return (f.Type == "" || r.RecordType() == f.Type) &&
+		(f.Owner == "" || r.Owner == f.Owner) &&
+		(f.Tag == "" || r.HasTag(f.Tag))
+}
+
+// RecordType returns the type of the record, treating records stored before types existed as address records
+func (r DNSRecord) RecordType() RecordType {
+	if r.Type == "" {
+		return RecordTypeAddress
+	}
+
+	return r.Type
+}
+
+// Value returns the type specific value of the record, used to detect duplicates
+func (r DNSRecord) Value() string {
+	switch r.RecordType() {
+	case RecordTypeAddress, RecordTypeHost:
+		return r.IP
+	case RecordTypeTXT:
+		return r.Text
+	case RecordTypeSRV:
+		return fmt.Sprintf("%s:%d:%d:%d", r.Target, r.Port, r.Priority, r.Weight)
+	case RecordTypeMX:
+		return fmt.Sprintf("%s:%d", r.Target, r.Priority)
+	default:
+		return r.Target
+	}
+}
+
+// Validate checks that the fields required by the record's type are set
+func (r DNSRecord) Validate() error {
+	if r.Hostname == "" {
+		return fmt.Errorf("hostname is required")
+	}
+
+	switch r.RecordType() {
+	case RecordTypeAddress, RecordTypeHost:
+		if net.ParseIP(r.IP) == nil {
+			return fmt.Errorf("invalid ip '%s' for %s record", r.IP, r.RecordType())
+		}
+	case RecordTypeCNAME, RecordTypePTR:
+		if r.Target == "" {
+			return fmt.Errorf("target is required for %s record", r.RecordType())
+		}
+	case RecordTypeTXT:
+		if r.Text == "" {
+			return fmt.Errorf("text is required for txt record")
+		}
+		if strings.ContainsAny(r.Text, "\"\n") {
+			return fmt.Errorf("text for txt record may not contain quotes or newlines")
+		}
+	case RecordTypeSRV:
+		if r.Target == "" {
+			return fmt.Errorf("target is required for srv record")
+		}
+		if r.Port == 0 {
+			return fmt.Errorf("port is required for srv record")
+		}
+	case RecordTypeMX:
+		if r.Target == "" {
+			return fmt.Errorf("target is required for mx record")
+		}
+	default:
+		return fmt.Errorf("unknown record type '%s'", r.Type)
+	}
+
+	return nil
 }
 
 type SetDNSRecordRequest struct {
-	IPs []string `json:"ips"`
+	Type RecordType `json:"type"`
+	// Wildcard makes address records, which also answer for every name under the hostname
+	Wildcard bool        `json:"wildcard"`
+	IPs      []string    `json:"ips"`
+	Records  []DNSRecord `json:"records"`
+	// TTL and the metadata apply to every record that does not set its own
+	TTL uint32 `json:"ttl,omitempty"`
+	RecordMetadata
+}
+
+// PatchDNSRecordRequest Adds and removes IPs of a hostname in one change. The removals are made first, so an IP in
+// both lists is kept. The type only applies to the added IPs, which are removed from records of any type
+type PatchDNSRecordRequest struct {
+	Type     RecordType `json:"type"`
+	Wildcard bool       `json:"wildcard"`
+	Add      []string   `json:"add"`
+	Remove   []string   `json:"remove"`
+	// TTL and the metadata apply to the added records
+	TTL uint32 `json:"ttl,omitempty"`
+	RecordMetadata
+}
+
+// RenewDNSRecordRequest Extends the expiry of a hostname's records, either to ExpiresAt or TTLSeconds from now.
+// Without either, each record is extended by its own TTLSeconds
+type RenewDNSRecordRequest struct {
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds uint32     `json:"ttl_seconds,omitempty"`
+}
+
+// Bulk operations
+const (
+	BulkOpUpsert = "upsert"
+	BulkOpAppend = "append"
+	BulkOpDelete = "delete"
+	BulkOpRemove = "remove"
+)
+
+// DNSBulkOperation A single operation in a bulk request. Upsert replaces the records of the type for the hostname,
+// append adds to them, and delete removes them, or every record for the hostname if no type is given. Remove takes
+// the IPs out of the hostname's records of the type, or of every type if none is given
+type DNSBulkOperation struct {
+	Op       string      `json:"op"`
+	Hostname string      `json:"hostname"`
+	Type     RecordType  `json:"type,omitempty"`
+	Wildcard bool        `json:"wildcard,omitempty"`
+	IPs      []string    `json:"ips,omitempty"`
+	Records  []DNSRecord `json:"records,omitempty"`
+	TTL      uint32      `json:"ttl,omitempty"`
+	RecordMetadata
+}
+
+type DNSBulkRequest struct {
+	Operations []DNSBulkOperation `json:"operations"`
+}
+
+// DNSBulkResult The outcome of a single operation in a bulk request
+type DNSBulkResult struct {
+	Index    int          `json:"index"`
+	Op       string       `json:"op"`
+	Hostname string       `json:"hostname"`
+	Records  []DNSRecord  `json:"records,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError A validation failure for a single field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+// Resolution The records that would answer a query for a name. Shadowed lists the other wildcards that match the
+// name but are not used
+type Resolution struct {
+	Name     string      `json:"name"`
+	Hostname string      `json:"hostname"`
+	Wildcard bool        `json:"wildcard"`
+	Records  []DNSRecord `json:"records"`
+	Shadowed []string    `json:"shadowed,omitempty"`
 }
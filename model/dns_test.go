@@ -0,0 +1,65 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRequestRecordType(t *testing.T) {
+	tests := []struct {
+		name       string
+		recordType RecordType
+		wildcard   bool
+		want       RecordType
+		wantErr    bool
+	}{
+		{name: "Default", want: RecordTypeHost},
+		{name: "Wildcard", wildcard: true, want: RecordTypeAddress},
+		{name: "Address", recordType: RecordTypeAddress, want: RecordTypeAddress},
+		{name: "AddressWildcard", recordType: RecordTypeAddress, wildcard: true, want: RecordTypeAddress},
+		{name: "CNAME", recordType: "CNAME", want: RecordTypeCNAME},
+		{name: "HostWildcard", recordType: RecordTypeHost, wildcard: true, wantErr: true},
+		{name: "Unknown", recordType: "aaaa", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RequestRecordType(tt.recordType, tt.wildcard, RecordTypeHost)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDNSRecord_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal([]DNSRecord{
+		{Hostname: "lan", Type: RecordTypeAddress, IP: "10.0.0.254"},
+		{Hostname: "nas.lan", Type: RecordTypeHost, IP: "10.0.0.5", Wildcard: true},
+		{Hostname: "old.lan", IP: "10.0.0.6"},
+	})
+	require.NoError(t, err)
+
+	var records []map[string]any
+	require.NoError(t, json.Unmarshal(data, &records))
+	assert.Equal(t, true, records[0]["wildcard"])
+	assert.NotContains(t, records[1], "wildcard")
+	assert.Equal(t, true, records[2]["wildcard"])
+}
+
+func TestDNSRecord_MarshalYAML(t *testing.T) {
+	data, err := yaml.Marshal([]DNSRecord{
+		{Hostname: "lan", Type: RecordTypeAddress, IP: "10.0.0.254"},
+		{Hostname: "nas.lan", Type: RecordTypeHost, IP: "10.0.0.5", Wildcard: true,
+			RecordMetadata: RecordMetadata{Owner: "ops", Tags: []string{"storage"}}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "- hostname: lan\n  type: address\n  ip: 10.0.0.254\n  wildcard: true\n"+
+		"- hostname: nas.lan\n  type: host\n  ip: 10.0.0.5\n  owner: ops\n  tags:\n    - storage\n", string(data))
+}
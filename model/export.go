@@ -0,0 +1,21 @@
+package model
+
+// Export formats. Records exported in any of them but yaml can be imported again
+const (
+	ExportFormatHosts   = ImportFormatHosts
+	ExportFormatDNSMasq = ImportFormatDNSMasq
+	ExportFormatZone    = ImportFormatZone
+	ExportFormatCSV     = ImportFormatCSV
+	ExportFormatYAML    = "yaml"
+	ExportFormatJSON    = ImportFormatJSON
+)
+
+// ExportFormats All supported export formats
+var ExportFormats = []string{
+	ExportFormatHosts,
+	ExportFormatDNSMasq,
+	ExportFormatZone,
+	ExportFormatCSV,
+	ExportFormatYAML,
+	ExportFormatJSON,
+}
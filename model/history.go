@@ -0,0 +1,70 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RevisionETag formats a hostname revision as a strong ETag
+func RevisionETag(rev uint64) string {
+	return fmt.Sprintf("%q", fmt.Sprint(rev))
+}
+
+// Precondition The If-Match and If-None-Match conditions a change to a hostname must meet, as lists of ETags or "*".
+// Empty lists are always met
+type Precondition struct {
+	IfMatch     []string
+	IfNoneMatch []string
+}
+
+// Met reports whether the precondition holds for a hostname with the given ETag, empty if the hostname has no records.
+// If-Match uses the strong comparison, so a weak ETag never matches, while If-None-Match uses the weak comparison
+func (p Precondition) Met(etag string) bool {
+	if len(p.IfMatch) > 0 && (etag == "" || !matchETag(p.IfMatch, etag, false)) {
+		return false
+	}
+	if len(p.IfNoneMatch) > 0 && etag != "" && matchETag(p.IfNoneMatch, etag, true) {
+		return false
+	}
+
+	return true
+}
+
+// matchETag reports whether etag is in the list, or the list is "*". With weak set, weak ETags match their strong form
+func matchETag(etags []string, etag string, weak bool) bool {
+	for _, candidate := range etags {
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HostRevision A version of the records of a hostname. Revisions count up from 1 with every change to the hostname,
+// and a revision without records is the hostname being deleted
+type HostRevision struct {
+	Revision  uint64      `json:"revision"`
+	Timestamp time.Time   `json:"timestamp"`
+	Actor     string      `json:"actor,omitempty"`
+	Operation string      `json:"operation"`
+	Records   []DNSRecord `json:"records"`
+}
+
+// Snapshot A copy of every DNS record at a point in time. Records is left out of snapshot listings
+type Snapshot struct {
+	ID          uint64                 `json:"id"`
+	CreatedAt   time.Time              `json:"created_at"`
+	Actor       string                 `json:"actor,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Hostnames   int                    `json:"hostnames"`
+	Records     map[string][]DNSRecord `json:"records,omitempty"`
+}
+
+type CreateSnapshotRequest struct {
+	Description string `json:"description"`
+}
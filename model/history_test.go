@@ -0,0 +1,34 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrecondition_Met(t *testing.T) {
+	tests := []struct {
+		name         string
+		precondition Precondition
+		etag         string
+		want         bool
+	}{
+		{name: "None", precondition: Precondition{}, etag: `"3"`, want: true},
+		{name: "IfMatch", precondition: Precondition{IfMatch: []string{`"3"`}}, etag: `"3"`, want: true},
+		{name: "IfMatchWeak", precondition: Precondition{IfMatch: []string{`W/"3"`}}, etag: `"3"`, want: false},
+		{name: "IfMatchList", precondition: Precondition{IfMatch: []string{`"2"`, `"3"`}}, etag: `"3"`, want: true},
+		{name: "IfMatchStale", precondition: Precondition{IfMatch: []string{`"2"`}}, etag: `"3"`, want: false},
+		{name: "IfMatchAny", precondition: Precondition{IfMatch: []string{"*"}}, etag: `"3"`, want: true},
+		{name: "IfMatchMissing", precondition: Precondition{IfMatch: []string{"*"}}, etag: "", want: false},
+		{name: "IfNoneMatchAny", precondition: Precondition{IfNoneMatch: []string{"*"}}, etag: `"3"`, want: false},
+		{name: "IfNoneMatchCreate", precondition: Precondition{IfNoneMatch: []string{"*"}}, etag: "", want: true},
+		{name: "IfNoneMatch", precondition: Precondition{IfNoneMatch: []string{`"3"`}}, etag: `"3"`, want: false},
+		{name: "IfNoneMatchWeak", precondition: Precondition{IfNoneMatch: []string{`W/"3"`}}, etag: `"3"`, want: false},
+		{name: "IfNoneMatchChanged", precondition: Precondition{IfNoneMatch: []string{`"2"`}}, etag: `"3"`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.precondition.Met(tt.etag))
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Import formats
+const (
+	// ImportFormatHosts a hosts file, <ip> <hostname>... per line
+	ImportFormatHosts = "hosts"
+	// ImportFormatCSV a CSV file with a header row naming the DNSRecord fields in each column
+	ImportFormatCSV = "csv"
+	// ImportFormatJSON a JSON list of DNSRecords, as returned by GET /dns
+	ImportFormatJSON = "json"
+	// ImportFormatZone an RFC 1035 zone file
+	ImportFormatZone = "zone"
+	// ImportFormatDNSMasq a dnsmasq config
+	ImportFormatDNSMasq = "dnsmasq"
+)
+
+// ImportFormats All supported import formats
+var ImportFormats = []string{
+	ImportFormatHosts,
+	ImportFormatCSV,
+	ImportFormatJSON,
+	ImportFormatZone,
+	ImportFormatDNSMasq,
+}
+
+// Import modes
+const (
+	// ImportModeMerge adds the imported records for each hostname and type that has none yet. Hostnames that already
+	// have different records of the type are conflicts, and are left as they are
+	ImportModeMerge = "merge"
+	// ImportModeReplace makes the imported records the only ones, deleting every record missing from the import
+	ImportModeReplace = "replace"
+)
+
+// ParseImportMode converts a string into an import mode. An empty string is a merge
+func ParseImportMode(s string) (string, error) {
+	switch mode := strings.ToLower(s); mode {
+	case "":
+		return ImportModeMerge, nil
+	case ImportModeMerge, ImportModeReplace:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown import mode '%s'", s)
+}
+
+// ImportOptions How imported records are applied
+type ImportOptions struct {
+	Mode string `json:"mode"`
+	// DryRun works out the changes the import would make, then leaves the records as they were
+	DryRun bool `json:"dry_run"`
+}
+
+// ImportIssue Part of the imported data that was skipped, and why
+type ImportIssue struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Text   string `json:"text,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (i ImportIssue) String() string {
+	switch {
+	case i.File != "" && i.Line > 0:
+		return fmt.Sprintf("%s:%d", i.File, i.Line)
+	case i.Line > 0:
+		return fmt.Sprintf("line %d", i.Line)
+	case i.File != "":
+		return i.File
+	}
+
+	return i.Text
+}
+
+// ImportChange A change an import makes to the records of one type for a hostname, and the bulk operation making it
+type ImportChange struct {
+	Op       string      `json:"op"`
+	Hostname string      `json:"hostname"`
+	Type     RecordType  `json:"type"`
+	Before   []DNSRecord `json:"before,omitempty"`
+	After    []DNSRecord `json:"after,omitempty"`
+}
+
+// ImportConflict Imported records that were left out of a merge, because the hostname already has different records
+// of the type, or of the other kind of IP record
+type ImportConflict struct {
+	Hostname string      `json:"hostname"`
+	Type     RecordType  `json:"type"`
+	Existing []DNSRecord `json:"existing"`
+	Imported []DNSRecord `json:"imported"`
+}
+
+// ImportResult What an import changed, or would change for a dry run
+type ImportResult struct {
+	Mode   string `json:"mode"`
+	DryRun bool   `json:"dry_run"`
+	// Records is how many records were read from the imported data
+	Records int `json:"records"`
+	// Unchanged is how many hostname and type pairs already had the imported records
+	Unchanged int              `json:"unchanged"`
+	Changes   []ImportChange   `json:"changes"`
+	Conflicts []ImportConflict `json:"conflicts"`
+	Skipped   []ImportIssue    `json:"skipped"`
+	// Results is the outcome of each bulk operation when any of them failed, in which case nothing was changed
+	Results []DNSBulkResult `json:"results,omitempty"`
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sync policies, deciding which side wins when the managed files were changed outside the API
+const (
+	// SyncPolicyFileWins loads the changes made to the files into the database. A file older than the database, like
+	// a restored backup, is stale rather than changed, so it is rewritten instead
+	SyncPolicyFileWins = "file-wins"
+	// SyncPolicyDBWins rewrites the files from the database, dropping the changes made to them
+	SyncPolicyDBWins = "db-wins"
+	// SyncPolicyFail leaves both sides as they are, and refuses to write the files until the drift is reconciled
+	SyncPolicyFail = "fail"
+)
+
+// SyncPolicies All supported sync policies
+var SyncPolicies = []string{SyncPolicyFileWins, SyncPolicyDBWins, SyncPolicyFail}
+
+// ParseSyncPolicy parses a sync policy. Empty is SyncPolicyFileWins
+func ParseSyncPolicy(s string) (string, error) {
+	if s == "" {
+		return SyncPolicyFileWins, nil
+	}
+	policy := strings.ToLower(s)
+	for _, p := range SyncPolicies {
+		if policy == p {
+			return policy, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown sync policy '%s', expected one of %s", s, strings.Join(SyncPolicies, ", "))
+}
+
+// Sources of a difference between the database and the managed files
+const (
+	// SyncSourceFile the file was changed outside the API
+	SyncSourceFile = "file"
+	// SyncSourceDB the database was changed, and the change is still to be written
+	SyncSourceDB = "db"
+	// SyncSourceBoth both were changed
+	SyncSourceBoth = "both"
+)
+
+// SyncDifference The records of a hostname and type that differ between the database and the managed files
+type SyncDifference struct {
+	Hostname string      `json:"hostname"`
+	Type     RecordType  `json:"type"`
+	Source   string      `json:"source"`
+	DB       []DNSRecord `json:"db"`
+	File     []DNSRecord `json:"file"`
+}
+
+// SyncStatus How the database compares to the managed files
+type SyncStatus struct {
+	Policy string `json:"policy"`
+	InSync bool   `json:"in_sync"`
+	// Drifted is whether the files were changed outside the API since it last wrote them
+	Drifted bool `json:"drifted"`
+	// Stale is whether the config is from an older generation than the database
+	Stale          bool             `json:"stale"`
+	DBGeneration   uint64           `json:"db_generation"`
+	FileGeneration uint64           `json:"file_generation"`
+	Differences    []SyncDifference `json:"differences"`
+	// Resolution is the side a reconcile kept, SyncSourceFile or SyncSourceDB, if there was drift to resolve
+	Resolution string `json:"resolution,omitempty"`
+}
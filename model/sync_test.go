@@ -0,0 +1,31 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyncPolicy(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr string
+	}{
+		{input: "", want: SyncPolicyFileWins},
+		{input: "db-wins", want: SyncPolicyDBWins},
+		{input: "FAIL", want: SyncPolicyFail},
+		{input: "newest-wins", wantErr: "unknown sync policy 'newest-wins', expected one of file-wins, db-wins, fail"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			policy, err := ParseSyncPolicy(tt.input)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, policy)
+		})
+	}
+}
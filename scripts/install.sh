@@ -78,7 +78,9 @@ if [ -z "${SKIP_USER_SETUP}" ]; then
       tee /etc/sudoers.d/dnsmasqapi  > /dev/null <<EOF
 # Allow DMA_USER to restart dnsmasq.service without a password
 ${DMA_USER} ALL=(ALL) NOPASSWD: /bin/systemctl restart dnsmasq.service
+${DMA_USER} ALL=(ALL) NOPASSWD: /bin/systemctl reload dnsmasq.service
 ${DMA_USER} ALL=(ALL) NOPASSWD: /bin/systemctl status dnsmasq.service
+${DMA_USER} ALL=(ALL) NOPASSWD: /bin/kill -1 *
 EOF
     fi
   fi
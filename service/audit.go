@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/cclose/dnsmasq-api/constant/key"
+	"github.com/cclose/dnsmasq-api/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultAuditBucketName = "audit"
+	defaultAuditLimit      = 100
+	maxAuditLimit          = 1000
+
+	// auditActorReaper is the actor recorded for records removed by the reaper
+	auditActorReaper = "reaper"
+	// auditActorConfig is the actor recorded for changes read from the config files
+	auditActorConfig = "config"
+)
+
+// systemContext creates a context for changes the service makes by itself, recorded under the given actor
+func systemContext(actor string) context.Context {
+	return context.WithValue(context.Background(), key.ContextIdentity, &model.Identity{Name: actor})
+}
+
+// actorFromContext returns the name of the identity in ctx, or an empty string if there is none
+func actorFromContext(ctx context.Context) string {
+	if identity, ok := ctx.Value(key.ContextIdentity).(*model.Identity); ok {
+		return identity.Name
+	}
+
+	return ""
+}
+
+// sequenceKey encodes a bucket sequence number as a key that sorts in sequence order
+func sequenceKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+
+	return k
+}
+
+// hostRecordsTx returns every record stored for the hostname within a transaction
+func (ds *DNSMasqService) hostRecordsTx(tx *bolt.Tx, hostname string) ([]model.DNSRecord, error) {
+	bucket, err := ds.dnsBucketTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	data := bucket.Get([]byte(hostname))
+	if data == nil {
+		return nil, nil
+	}
+
+	return decodeRecords(data)
+}
+
+// recordChangeTx records a change to the hostname in the audit log and the hostname's revision history, within the
+// transaction making the change so they are only kept if the change is. The actor and source IP are taken from ctx,
+// and the records after the change are read from the transaction. Changes that leave the hostname without records
+// before and after are skipped
+func (ds *DNSMasqService) recordChangeTx(ctx context.Context, tx *bolt.Tx, op, hostname string,
+	before []model.DNSRecord) error {
+	after, err := ds.hostRecordsTx(tx, hostname)
+	if err != nil {
+		return err
+	}
+	if len(before) == 0 && len(after) == 0 {
+		return nil
+	}
+
+	entry := model.AuditEntry{
+		Timestamp: time.Now().UTC(),
+		Actor:     actorFromContext(ctx),
+		Operation: op,
+		Hostname:  hostname,
+		Before:    before,
+		After:     after,
+	}
+	entry.SourceIP, _ = ctx.Value(key.ContextSourceIP).(string)
+
+	if err = ds.recordRevisionTx(tx, entry); err != nil {
+		return err
+	}
+
+	return ds.recordAuditTx(tx, entry)
+}
+
+// recordAuditTx appends the entry to the audit log within a transaction, and to the export file once it commits
+func (ds *DNSMasqService) recordAuditTx(tx *bolt.Tx, entry model.AuditEntry) error {
+	bucket := tx.Bucket(ds.auditBucket)
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if ds.auditExportFile != "" {
+		tx.OnCommit(func() { ds.exportAudit(data) })
+	}
+
+	return bucket.Put(sequenceKey(id), data)
+}
+
+// exportAudit appends an encoded audit entry to the export file as a line of JSON
+func (ds *DNSMasqService) exportAudit(data []byte) {
+	ds.auditMu.Lock()
+	defer ds.auditMu.Unlock()
+
+	file, err := os.OpenFile(ds.auditExportFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, dbFileMode)
+	if err != nil {
+		ds.log.Errorf("Failed to open audit export file %s: %v", ds.auditExportFile, err)
+		return
+	}
+	defer file.Close()
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		ds.log.Errorf("Failed to write to audit export file %s: %v", ds.auditExportFile, err)
+	}
+}
+
+// GetAuditLog retrieves a page of the audit entries matching the query, oldest first
+func (ds *DNSMasqService) GetAuditLog(query model.AuditQuery) (model.AuditPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	limit = min(limit, maxAuditLimit)
+
+	page := model.AuditPage{Entries: []model.AuditEntry{}}
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(ds.auditBucket).Cursor()
+		for k, v := c.Seek(sequenceKey(query.After + 1)); k != nil; k, v = c.Next() {
+			var entry model.AuditEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if !query.Matches(entry) {
+				continue
+			}
+			// Another match means there is a next page
+			if len(page.Entries) == limit {
+				page.Next = page.Entries[limit-1].ID
+				break
+			}
+			page.Entries = append(page.Entries, entry)
+		}
+		return nil
+	})
+
+	return page, err
+}
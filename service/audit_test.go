@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cclose/dnsmasq-api/constant/key"
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSMasqService_Audit(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\n")
+	ds.auditExportFile = filepath.Join(t.TempDir(), "audit.jsonl")
+	ctx := context.WithValue(systemContext("deploy"), key.ContextSourceIP, "192.0.2.10")
+
+	start := time.Now().UTC()
+	_, err := ds.SetIPByHost(ctx, "b.lan", []string{"10.0.0.2"}, false)
+	require.NoError(t, err)
+	_, err = ds.SetIPByHost(ctx, "b.lan", []string{"10.0.0.3"}, true)
+	require.NoError(t, err)
+	require.NoError(t, ds.DeleteByHost(ctx, "a.lan"))
+	// Deleting a hostname that has no records changes nothing
+	require.NoError(t, ds.DeleteByHost(ctx, "missing.lan"))
+	_, err = ds.ApplyBulk(context.Background(), []model.DNSBulkOperation{
+		{Op: model.BulkOpUpsert, Hostname: "c.lan", IPs: []string{"10.0.0.4"}},
+	})
+	require.NoError(t, err)
+	// Failed bulk operations leave no entries
+	_, err = ds.ApplyBulk(ctx, []model.DNSBulkOperation{
+		{Op: model.BulkOpUpsert, Hostname: "d.lan", IPs: []string{"10.0.0.5"}},
+		{Op: model.BulkOpUpsert, Hostname: "e.lan", IPs: []string{"bogus"}},
+	})
+	assert.EqualError(t, err, ErrorBulkFailed)
+
+	// Loading the records from the config when the service started comes first
+	page, err := ds.GetAuditLog(model.AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 5)
+	assert.Zero(t, page.Next)
+	ops := []string{}
+	for _, entry := range page.Entries {
+		ops = append(ops, entry.Operation)
+	}
+	assert.Equal(t, []string{model.AuditOpLoad, model.AuditOpSet, model.AuditOpAppend, model.AuditOpDelete,
+		model.BulkOpUpsert}, ops)
+	assert.Equal(t, auditActorConfig, page.Entries[0].Actor)
+
+	appended := page.Entries[2]
+	assert.Equal(t, "deploy", appended.Actor)
+	assert.Equal(t, "192.0.2.10", appended.SourceIP)
+	assert.Equal(t, "b.lan", appended.Hostname)
+	assert.Len(t, appended.Before, 1)
+	assert.Len(t, appended.After, 2)
+	assert.False(t, appended.Timestamp.Before(start.Truncate(time.Second)))
+	assert.Empty(t, page.Entries[3].After)
+	assert.Empty(t, page.Entries[4].Actor)
+
+	// Filters and paging
+	page, err = ds.GetAuditLog(model.AuditQuery{Hostname: "b.lan", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, model.AuditOpSet, page.Entries[0].Operation)
+	page, err = ds.GetAuditLog(model.AuditQuery{Hostname: "b.lan", Limit: 1, After: page.Next})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, model.AuditOpAppend, page.Entries[0].Operation)
+	assert.Zero(t, page.Next)
+	page, err = ds.GetAuditLog(model.AuditQuery{From: start.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Empty(t, page.Entries)
+
+	// Every committed entry is exported as a line of JSON
+	data, err := os.ReadFile(ds.auditExportFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 4)
+	var exported model.AuditEntry
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &exported))
+	assert.Equal(t, appended.ID, exported.ID)
+}
+
+func TestDNSMasqService_AuditReaper(t *testing.T) {
+	ds := newTestDNSMasqService(t, "")
+	past := time.Now().Add(-time.Minute)
+	_, err := ds.SetRecordsByHost(context.Background(), "ci.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "10.0.1.1", RecordMetadata: model.RecordMetadata{ExpiresAt: &past}}}, false)
+	require.NoError(t, err)
+
+	_, err = ds.ReapExpired()
+	require.NoError(t, err)
+	page, err := ds.GetAuditLog(model.AuditQuery{Hostname: "ci.lan"})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, model.AuditOpExpire, page.Entries[1].Operation)
+	assert.Equal(t, auditActorReaper, page.Entries[1].Actor)
+}
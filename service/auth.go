@@ -0,0 +1,181 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/model"
+)
+
+const (
+	tokenHashPrefix = "sha256:"
+	tokenBytes      = 32
+
+	ErrorInvalidToken = "invalid API token"
+)
+
+type IAuthService interface {
+	Authenticate(token string) (*model.Identity, error)
+	AuthenticateCertificate(cert *x509.Certificate) (*model.Identity, error)
+}
+
+// AuthService Authenticates API tokens against the hashed tokens in config, and maps verified client certificates
+// to the configured client identities
+type AuthService struct {
+	tokens  []authToken
+	clients []clientCert
+}
+
+// authToken A configured token, by the hash of its secret
+type authToken struct {
+	hash     []byte
+	identity model.Identity
+}
+
+// clientCert A configured client certificate identity, by the patterns its certificate must match
+type clientCert struct {
+	commonName string
+	san        string
+	identity   model.Identity
+}
+
+// NewAuthService Creates a new AuthService from the configured tokens and client certificates
+func NewAuthService(config model.AuthConfig) (IAuthService, error) {
+	as := &AuthService{}
+	for i, token := range config.Tokens {
+		if token.Name == "" {
+			return nil, fmt.Errorf("auth.tokens[%d] needs a name", i)
+		}
+		hash, err := parseTokenHash(token.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("auth token '%s': %v", token.Name, err)
+		}
+		identity, err := newIdentity(token.Name, token.Scopes, token.Hostnames)
+		if err != nil {
+			return nil, fmt.Errorf("auth token '%s': %v", token.Name, err)
+		}
+
+		as.tokens = append(as.tokens, authToken{hash: hash, identity: identity})
+	}
+
+	for i, client := range config.Clients {
+		if client.Name == "" {
+			return nil, fmt.Errorf("auth.clients[%d] needs a name", i)
+		}
+		if client.CommonName == "" && client.SAN == "" {
+			return nil, fmt.Errorf("auth client '%s' needs a common_name or san to match", client.Name)
+		}
+		identity, err := newIdentity(client.Name, client.Scopes, client.Hostnames)
+		if err != nil {
+			return nil, fmt.Errorf("auth client '%s': %v", client.Name, err)
+		}
+
+		as.clients = append(as.clients, clientCert{
+			commonName: strings.ToLower(client.CommonName),
+			san:        strings.ToLower(client.SAN),
+			identity:   identity,
+		})
+	}
+
+	return as, nil
+}
+
+// newIdentity creates an identity, parsing its scopes
+func newIdentity(name string, scopes []string, hostnames []string) (model.Identity, error) {
+	identity := model.Identity{Name: name, Hostnames: hostnames}
+	for _, s := range scopes {
+		scope, err := model.ParseScope(s)
+		if err != nil {
+			return identity, err
+		}
+		identity.Scopes = append(identity.Scopes, scope)
+	}
+
+	return identity, nil
+}
+
+// parseTokenHash decodes a sha256:<hex> token hash
+func parseTokenHash(s string) ([]byte, error) {
+	hexHash, found := strings.CutPrefix(s, tokenHashPrefix)
+	if !found {
+		return nil, fmt.Errorf("hash must start with '%s'", tokenHashPrefix)
+	}
+	hash, err := hex.DecodeString(hexHash)
+	if err != nil || len(hash) != sha256.Size {
+		return nil, fmt.Errorf("hash is not a hex encoded sha256 sum")
+	}
+
+	return hash, nil
+}
+
+// HashToken Hashes a token for the auth config
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// GenerateToken Creates a new random API token
+func GenerateToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Authenticate returns the identity of the token. Every configured token is compared in constant time
+func (as *AuthService) Authenticate(token string) (*model.Identity, error) {
+	sum := sha256.Sum256([]byte(token))
+	var found *model.Identity
+	for i := range as.tokens {
+		if subtle.ConstantTimeCompare(sum[:], as.tokens[i].hash) == 1 {
+			found = &as.tokens[i].identity
+		}
+	}
+	if token == "" || found == nil {
+		return nil, fmt.Errorf("%s", ErrorInvalidToken)
+	}
+
+	// Hand out a copy so callers cannot change the configured identity
+	identity := *found
+	return &identity, nil
+}
+
+const ErrorUnknownCertificate = "client certificate is not mapped to an identity"
+
+// AuthenticateCertificate returns the identity of the first configured client whose patterns match the certificate.
+// The certificate must already have been verified against the client CA
+func (as *AuthService) AuthenticateCertificate(cert *x509.Certificate) (*model.Identity, error) {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	for _, client := range as.clients {
+		matched := client.commonName != "" && matchPattern(client.commonName, cert.Subject.CommonName)
+		for _, san := range sans {
+			matched = matched || (client.san != "" && matchPattern(client.san, san))
+		}
+		if matched {
+			identity := client.identity
+			return &identity, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s", ErrorUnknownCertificate)
+}
+
+// matchPattern matches a lower case pattern against a value, ignoring case
+func matchPattern(pattern, value string) bool {
+	matched, _ := path.Match(pattern, strings.ToLower(value))
+	return matched
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuthService(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   model.TokenConfig
+		wantErr bool
+	}{
+		{
+			name:  "Valid",
+			token: model.TokenConfig{Name: "deploy", Hash: HashToken("secret"), Scopes: []string{"write"}},
+		},
+		{
+			name:    "NoName",
+			token:   model.TokenConfig{Hash: HashToken("secret")},
+			wantErr: true,
+		},
+		{
+			name:    "PlainToken",
+			token:   model.TokenConfig{Name: "deploy", Hash: "secret"},
+			wantErr: true,
+		},
+		{
+			name:    "ShortHash",
+			token:   model.TokenConfig{Name: "deploy", Hash: "sha256:abcd"},
+			wantErr: true,
+		},
+		{
+			name:    "UnknownScope",
+			token:   model.TokenConfig{Name: "deploy", Hash: HashToken("secret"), Scopes: []string{"root"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAuthService(model.AuthConfig{Enabled: true, Tokens: []model.TokenConfig{tt.token}})
+			assert.Equal(t, tt.wantErr, err != nil, err)
+		})
+	}
+}
+
+func TestAuthService_Authenticate(t *testing.T) {
+	token, err := GenerateToken()
+	require.NoError(t, err)
+	as, err := NewAuthService(model.AuthConfig{Enabled: true, Tokens: []model.TokenConfig{
+		{Name: "reader", Hash: HashToken("read-only"), Scopes: []string{"read"}},
+		{Name: "deploy", Hash: HashToken(token), Scopes: []string{"write"}, Hostnames: []string{"*.dev.lan"}},
+	}})
+	require.NoError(t, err)
+
+	identity, err := as.Authenticate(token)
+	require.NoError(t, err)
+	assert.Equal(t, &model.Identity{
+		Name: "deploy", Scopes: []model.Scope{model.ScopeWrite}, Hostnames: []string{"*.dev.lan"},
+	}, identity)
+
+	_, err = as.Authenticate("wrong")
+	assert.EqualError(t, err, ErrorInvalidToken)
+	_, err = as.Authenticate("")
+	assert.EqualError(t, err, ErrorInvalidToken)
+}
+
+func TestAuthService_AuthenticateCertificate(t *testing.T) {
+	as, err := NewAuthService(model.AuthConfig{Clients: []model.ClientCertConfig{
+		{Name: "ci", CommonName: "ci-*", Scopes: []string{"write"}},
+		{Name: "spire", SAN: "spiffe://corp/dns-*", Scopes: []string{"admin"}},
+	}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "CommonName",
+			cert: &x509.Certificate{Subject: pkix.Name{CommonName: "CI-runner-3"}},
+			want: "ci",
+		},
+		{
+			name: "URISAN",
+			cert: &x509.Certificate{URIs: []*url.URL{{Scheme: "spiffe", Host: "corp", Path: "/dns-sync"}}},
+			want: "spire",
+		},
+		{
+			name:    "Unmapped",
+			cert:    &x509.Certificate{Subject: pkix.Name{CommonName: "laptop"}, DNSNames: []string{"laptop.lan"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := as.AuthenticateCertificate(tt.cert)
+			if tt.wantErr {
+				assert.EqualError(t, err, ErrorUnknownCertificate)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, identity.Name)
+		})
+	}
+
+	_, err = NewAuthService(model.AuthConfig{Clients: []model.ClientCertConfig{{Name: "any"}}})
+	assert.Error(t, err)
+}
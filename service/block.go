@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Markers of the block the API manages inside a shared file in merge mode. Everything outside the block is left as
+// it is
+const (
+	managedBlockBegin = "# BEGIN managed by dnsmasq-api"
+	managedBlockEnd   = "# END managed by dnsmasq-api"
+)
+
+// managedBlock A file split around its managed block. content is what is between the markers
+type managedBlock struct {
+	before  string
+	content string
+	after   string
+	found   bool
+}
+
+// findManagedBlock splits a file around its managed block. A file without one is all before the block. Markers
+// without their pair, and more than one block, are errors, as there is no telling which lines the API owns
+func findManagedBlock(data string) (managedBlock, error) {
+	var block managedBlock
+	begin, end := -1, -1
+	offset := 0
+	for i, line := range strings.SplitAfter(data, "\n") {
+		switch strings.TrimSpace(line) {
+		case managedBlockBegin:
+			if end >= 0 {
+				return block, fmt.Errorf("line %d: more than one managed block", i+1)
+			} else if begin >= 0 {
+				return block, fmt.Errorf("line %d: %s inside the managed block", i+1, managedBlockBegin)
+			}
+			block.before = data[:offset]
+			begin = offset + len(line)
+		case managedBlockEnd:
+			if end >= 0 {
+				return block, fmt.Errorf("line %d: more than one managed block", i+1)
+			} else if begin < 0 {
+				return block, fmt.Errorf("line %d: %s without %s", i+1, managedBlockEnd, managedBlockBegin)
+			}
+			block.content = data[begin:offset]
+			block.after = data[offset+len(line):]
+			end = offset
+		}
+		offset += len(line)
+	}
+
+	switch {
+	case begin < 0:
+		return managedBlock{before: data}, nil
+	case end < 0:
+		return block, fmt.Errorf("%s without %s", managedBlockBegin, managedBlockEnd)
+	}
+	block.found = true
+
+	return block, nil
+}
+
+// mergeManagedBlock replaces the content of the managed block in data, leaving the rest as it is. A file without a
+// managed block gets one at the end
+func mergeManagedBlock(data, content string) (string, error) {
+	block, err := findManagedBlock(data)
+	if err != nil {
+		return "", err
+	}
+	if !block.found && block.before != "" {
+		if !strings.HasSuffix(block.before, "\n") {
+			block.before += "\n"
+		}
+		block.before += "\n"
+	}
+
+	return block.before + managedBlockBegin + "\n" + content + managedBlockEnd + "\n" + block.after, nil
+}
+
+// checkManagedBlock checks the managed block of a file can be found in merge mode
+func (ds *DNSMasqService) checkManagedBlock(path string, data []byte) error {
+	if !ds.merge {
+		return nil
+	}
+	if _, err := findManagedBlock(string(data)); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// managedContent the part of a file the API manages. That is the whole file, or in merge mode, the content of its
+// managed block, which is empty if the block can't be found
+func (ds *DNSMasqService) managedContent(data []byte) string {
+	if !ds.merge {
+		return string(data)
+	}
+	block, _ := findManagedBlock(string(data))
+
+	return block.content
+}
+
+// mergeFile the data to write to a file, given its previous data and the rendered records. That is the rendered
+// records, or in merge mode, the previous data with the rendered records, less their header, in its managed block
+func (ds *DNSMasqService) mergeFile(path string, previous []byte, rendered string) (string, error) {
+	if !ds.merge {
+		return rendered, nil
+	}
+	data, err := mergeManagedBlock(string(previous), strings.TrimPrefix(rendered, dnsConfigHeader))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	return data, nil
+}
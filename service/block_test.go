@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mergeManagedBlock(t *testing.T) {
+	const block = managedBlockBegin + "\naddress=/lan/10.0.0.254\n" + managedBlockEnd + "\n"
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "Empty",
+			data: "",
+			want: block,
+		},
+		{
+			name: "NoBlock",
+			data: "# hand written\nserver=1.1.1.1",
+			want: "# hand written\nserver=1.1.1.1\n\n" + block,
+		},
+		{
+			name: "ReplacesBlock",
+			data: "server=1.1.1.1\n" + managedBlockBegin + "\naddress=/old/10.0.0.1\n" + managedBlockEnd +
+				"\n# after\ndomain=lan\n",
+			want: "server=1.1.1.1\n" + block + "# after\ndomain=lan\n",
+		},
+		{
+			name: "IndentedMarkers",
+			data: "  " + managedBlockBegin + "\n" + managedBlockEnd + "  ",
+			want: block,
+		},
+		{
+			name:    "NoEnd",
+			data:    "server=1.1.1.1\n" + managedBlockBegin + "\naddress=/old/10.0.0.1\n",
+			wantErr: managedBlockBegin + " without " + managedBlockEnd,
+		},
+		{
+			name:    "NoBegin",
+			data:    "server=1.1.1.1\n" + managedBlockEnd + "\n",
+			wantErr: "line 2: " + managedBlockEnd + " without " + managedBlockBegin,
+		},
+		{
+			name:    "Nested",
+			data:    managedBlockBegin + "\n" + managedBlockBegin + "\n" + managedBlockEnd + "\n",
+			wantErr: "line 2: " + managedBlockBegin + " inside the managed block",
+		},
+		{
+			name:    "TwoBlocks",
+			data:    block + block,
+			wantErr: "line 4: more than one managed block",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeManagedBlock(tt.data, "address=/lan/10.0.0.254\n")
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			// Merging again changes nothing
+			again, err := mergeManagedBlock(got, "address=/lan/10.0.0.254\n")
+			require.NoError(t, err)
+			assert.Equal(t, got, again)
+		})
+	}
+}
+
+func TestDNSMasqService_MergeMode(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "dnsmasq.conf")
+	hostsPath := filepath.Join(dir, "hosts")
+	const handWritten = "# Upstream servers\nserver=1.1.1.1\naddress=/hand.lan/10.0.0.9\n"
+	require.NoError(t, os.WriteFile(configPath, []byte(handWritten), dnsFileMode))
+	require.NoError(t, os.WriteFile(hostsPath, []byte("127.0.0.1\tlocalhost\n"), dnsFileMode))
+
+	config := model.Config{
+		DnsmasqConfig:     configPath,
+		SkipDNSMasqReload: true,
+		Output:            model.OutputConfig{Mode: OutputModeHosts, HostsFile: hostsPath, Merge: true},
+	}
+	svc, err := NewDNSMasqService(config, WithDBFilePath(filepath.Join(dir, "dns.db")), WithLogger(testLogger()))
+	require.NoError(t, err)
+	ds := svc.(*DNSMasqService)
+	t.Cleanup(func() { _ = ds.db.Close() })
+
+	// The lines outside the block are not the API's
+	records, err := ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	_, err = ds.SetIPByHost(context.Background(), "lan", []string{"10.0.0.254"}, false)
+	require.NoError(t, err)
+	_, err = ds.SetRecordsByHost(context.Background(), "nas.lan", model.RecordTypeHost,
+		[]model.DNSRecord{{IP: "10.0.0.5"}}, false)
+	require.NoError(t, err)
+	require.NoError(t, ds.UpdateDNSMasq())
+
+	conf, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, handWritten+"\n"+managedBlockBegin+"\n# Generation: 1\naddn-hosts="+hostsPath+
+		"\naddress=/lan/10.0.0.254\n"+managedBlockEnd+"\n", withoutMetadata(string(conf)))
+	hosts, err := os.ReadFile(hostsPath)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1\tlocalhost\n\n"+managedBlockBegin+"\n10.0.0.5\tnas.lan\n"+managedBlockEnd+"\n",
+		withoutMetadata(string(hosts)))
+
+	// Hand edits outside the block survive the next write, and are still not read into the database
+	edited := "server=9.9.9.9\n" + string(conf)
+	require.NoError(t, os.WriteFile(configPath, []byte(edited), dnsFileMode))
+	require.NoError(t, ds.BuildDatabase())
+	records, err = ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	require.NoError(t, ds.DeleteByHost(context.Background(), "nas.lan"))
+	require.NoError(t, ds.UpdateDNSMasq())
+	conf, err = os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(conf), "server=9.9.9.9\n"+handWritten)
+	hosts, err = os.ReadFile(hostsPath)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1\tlocalhost\n\n"+managedBlockBegin+"\n"+managedBlockEnd+"\n", string(hosts))
+
+	// A broken block is left alone
+	broken := handWritten + managedBlockBegin + "\n"
+	require.NoError(t, os.WriteFile(configPath, []byte(broken), dnsFileMode))
+	_, err = ds.readConfigFiles()
+	assert.EqualError(t, err, configPath+": "+managedBlockBegin+" without "+managedBlockEnd)
+	assert.Error(t, ds.WriteDNSMasq())
+	conf, err = os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, broken, string(conf))
+}
@@ -0,0 +1,473 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/cclose/dnsmasq-api/internal/util"
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultDHCPBucketName   = "dhcpHosts"
+	directiveDHCPHost       = "dhcp-host"
+	ErrorNoDHCPHost         = "no reservation found for mac"
+	ErrorDHCPIPConflict     = "ip address is already reserved for another mac"
+	MetricDHCPHostCount     = "dnsmasq_dhcp_hosts_total"
+	dhcpHostnameInvalidChar = ", \t\r\n#="
+)
+
+// leaseTimeRegex matches dnsmasq lease times, such as 3600, 45m, 12h, or infinite
+var leaseTimeRegex = regexp.MustCompile(`^(infinite|\d+[smhdw]?)$`)
+
+type IDHCPService interface {
+	BuildDatabase() error
+	UpdateDHCP() error
+	WriteDHCPConfig() error
+
+	GetAllHosts() ([]model.DHCPHost, error)
+	GetHostByMAC(mac string) (model.DHCPHost, error)
+	SetHost(host model.DHCPHost) (model.DHCPHost, error)
+	DeleteHostByMAC(mac string) error
+
+	GetLeases(filter model.DHCPLeaseFilter) ([]model.DHCPLease, error)
+	PromoteLease(mac string, req model.PromoteDHCPLeaseRequest) (model.DHCPHost, error)
+}
+
+type DHCPService struct {
+	db  *bolt.DB
+	dns IDNSMasqService
+
+	dhcpBucket []byte
+	dhcpConfig string
+	backups    int
+	leasesFile string
+	preflight  preflight
+	// updateMu serializes UpdateDHCP, so a rollback never restores over another update
+	updateMu sync.Mutex
+
+	log *logrus.Logger
+}
+
+// DHCPServiceOption Option functions for customizing DHCPService from Constructor
+type DHCPServiceOption func(*DHCPService)
+
+// NewDHCPService Creates a new DHCPService sharing the bolt DB, and reloading dnsmasq through the DNSMasqService
+func NewDHCPService(config model.Config, db *bolt.DB, dns IDNSMasqService, opts ...DHCPServiceOption) (IDHCPService, error) {
+	dhs := &DHCPService{
+		db:  db,
+		dns: dns,
+
+		dhcpBucket: []byte(defaultDHCPBucketName),
+		dhcpConfig: config.DHCPConfig,
+		backups:    config.DnsmasqBackups,
+		preflight:  newPreflight(config.Preflight),
+		leasesFile: defaultDHCPLeasesFile,
+	}
+	if config.DB.DHCPBucketName != "" {
+		dhs.dhcpBucket = []byte(config.DB.DHCPBucketName)
+	}
+	if config.DHCPLeasesFile != "" {
+		dhs.leasesFile = config.DHCPLeasesFile
+	}
+
+	// Apply any options
+	for _, opt := range opts {
+		opt(dhs)
+	}
+
+	if dhs.log == nil {
+		dhs.log = logrus.New()
+	}
+
+	// Make sure our DHCP Bucket exists
+	err := dhs.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dhs.dhcpBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = dhs.BuildDatabase(); err != nil {
+		return nil, err
+	}
+
+	return dhs, nil
+}
+
+// Option Functions
+
+// WithDHCPBucket Sets the name of the DB Bucket to store DHCP reservations
+func WithDHCPBucket(bucket string) DHCPServiceOption {
+	return func(dhs *DHCPService) {
+		dhs.dhcpBucket = []byte(bucket)
+	}
+}
+
+// WithDHCPLogger Sets the logger for the service to use
+func WithDHCPLogger(logger *logrus.Logger) DHCPServiceOption {
+	return func(dhs *DHCPService) {
+		dhs.log = logger
+	}
+}
+
+// normalizeMAC parses a MAC address, returning it in lowercase colon separated form
+func normalizeMAC(mac string) (string, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("invalid mac address '%s'", mac)
+	}
+
+	return hw.String(), nil
+}
+
+// validateDHCPHost validates a reservation, normalizing its MAC and IP
+func validateDHCPHost(host model.DHCPHost) (model.DHCPHost, error) {
+	var err error
+	if host.MAC, err = normalizeMAC(host.MAC); err != nil {
+		return host, err
+	}
+
+	ip := net.ParseIP(strings.Trim(host.IP, "[]"))
+	if ip == nil {
+		return host, fmt.Errorf("invalid ip address '%s'", host.IP)
+	}
+	host.IP = ip.String()
+
+	if strings.ContainsAny(host.Hostname, dhcpHostnameInvalidChar) {
+		return host, fmt.Errorf("invalid hostname '%s'", host.Hostname)
+	}
+	if host.LeaseTime != "" && !leaseTimeRegex.MatchString(host.LeaseTime) {
+		return host, fmt.Errorf("invalid lease time '%s'", host.LeaseTime)
+	}
+	// The last field must read back the same way, see isLeaseTime
+	if host.LeaseTime == "" && isLeaseTime(host.Hostname, false) {
+		return host, fmt.Errorf("hostname '%s' would be read as a lease time", host.Hostname)
+	}
+	if host.Hostname == "" && host.LeaseTime != "" && !isLeaseTime(host.LeaseTime, false) {
+		return host, fmt.Errorf("lease time '%s' needs a unit when there is no hostname", host.LeaseTime)
+	}
+
+	return host, nil
+}
+
+// GetAllHosts retrieves all DHCP reservations from the database.
+func (dhs *DHCPService) GetAllHosts() ([]model.DHCPHost, error) {
+	var hosts []model.DHCPHost
+
+	err := dhs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dhs.dhcpBucket)
+		if bucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var host model.DHCPHost
+			if err := json.Unmarshal(v, &host); err != nil {
+				return err
+			}
+			hosts = append(hosts, host)
+			return nil
+		})
+	})
+
+	return hosts, err
+}
+
+// GetHostByMAC retrieves the DHCP reservation for the given MAC address.
+func (dhs *DHCPService) GetHostByMAC(mac string) (model.DHCPHost, error) {
+	var host model.DHCPHost
+	mac, err := normalizeMAC(mac)
+	if err != nil {
+		return host, err
+	}
+
+	err = dhs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dhs.dhcpBucket)
+		if bucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		data := bucket.Get([]byte(mac))
+		if data == nil {
+			return fmt.Errorf("%s", ErrorNoDHCPHost)
+		}
+
+		return json.Unmarshal(data, &host)
+	})
+
+	return host, err
+}
+
+// SetHost creates or replaces the DHCP reservation for the host's MAC address.
+// Returns an error if the IP address is already reserved for a different MAC address.
+func (dhs *DHCPService) SetHost(host model.DHCPHost) (model.DHCPHost, error) {
+	host, err := validateDHCPHost(host)
+	if err != nil {
+		return host, err
+	}
+
+	err = dhs.db.Update(func(tx *bolt.Tx) error {
+		return dhs.putHostTx(tx, host)
+	})
+
+	return host, err
+}
+
+// putHostTx stores a validated reservation within a transaction, returning an error if its IP address is already
+// reserved for a different MAC address
+func (dhs *DHCPService) putHostTx(tx *bolt.Tx, host model.DHCPHost) error {
+	bucket := tx.Bucket(dhs.dhcpBucket)
+	if bucket == nil {
+		return fmt.Errorf("bucket not found")
+	}
+
+	// Check no other MAC holds this IP
+	err := bucket.ForEach(func(k, v []byte) error {
+		var existing model.DHCPHost
+		if err := json.Unmarshal(v, &existing); err != nil {
+			return err
+		}
+		if existing.IP == host.IP && existing.MAC != host.MAC {
+			return fmt.Errorf("%s", ErrorDHCPIPConflict)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(host)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(host.MAC), data)
+}
+
+// DeleteHostByMAC deletes the DHCP reservation for the given MAC address.
+func (dhs *DHCPService) DeleteHostByMAC(mac string) error {
+	mac, err := normalizeMAC(mac)
+	if err != nil {
+		return err
+	}
+
+	return dhs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dhs.dhcpBucket)
+		if bucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		return bucket.Delete([]byte(mac))
+	})
+}
+
+// renderDHCPHost renders a reservation as a dhcp-host directive
+func renderDHCPHost(host model.DHCPHost) string {
+	fields := []string{host.MAC, host.IP}
+	if ip := net.ParseIP(host.IP); ip != nil && ip.To4() == nil {
+		// dnsmasq requires IPv6 addresses in dhcp-host to be bracketed
+		fields[1] = "[" + host.IP + "]"
+	}
+	if host.Hostname != "" {
+		fields = append(fields, host.Hostname)
+	}
+	if host.LeaseTime != "" {
+		fields = append(fields, host.LeaseTime)
+	}
+
+	return directiveDHCPHost + "=" + strings.Join(fields, ",")
+}
+
+// parseDHCPHost parses a dhcp-host directive. The bool is false if the line is not a dhcp-host
+// directive keyed on a single MAC address with an IP, which are the only forms this API manages.
+func parseDHCPHost(line string) (model.DHCPHost, bool) {
+	var host model.DHCPHost
+	directive, value, found := strings.Cut(strings.TrimSpace(line), "=")
+	if !found || directive != directiveDHCPHost {
+		return host, false
+	}
+
+	fields := splitFields(value)
+	for i, field := range fields {
+		if mac, err := normalizeMAC(field); err == nil && host.MAC == "" {
+			host.MAC = mac
+		} else if ip := net.ParseIP(strings.Trim(field, "[]")); ip != nil {
+			host.IP = ip.String()
+		} else if i == len(fields)-1 && isLeaseTime(field, host.Hostname != "") {
+			host.LeaseTime = field
+		} else {
+			host.Hostname = field
+		}
+	}
+
+	return host, host.MAC != "" && host.IP != ""
+}
+
+// isLeaseTime reports whether the last field of a dhcp-host is its lease time. A bare number is only taken as one
+// after a hostname, so a numeric hostname is not mistaken for a lease time
+func isLeaseTime(field string, afterHostname bool) bool {
+	if !leaseTimeRegex.MatchString(field) {
+		return false
+	}
+	_, err := strconv.Atoi(field)
+	return err != nil || afterHostname
+}
+
+// parseDHCPConfig parses the reservations out of a DHCP config, skipping invalid reservations
+func (dhs *DHCPService) parseDHCPConfig(data string) []model.DHCPHost {
+	var hosts []model.DHCPHost
+	for i, line := range strings.Split(data, "\n") {
+		host, ok := parseDHCPHost(line)
+		if !ok {
+			continue
+		}
+		host, err := validateDHCPHost(host)
+		if err != nil {
+			dhs.log.Warnf("Skipping invalid dhcp-host on line %d of %s: %v", i+1, dhs.dhcpConfig, err)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+
+	return hosts
+}
+
+// loadHosts replaces all the reservations in the database with the given reservations. The bucket is wiped and
+// reloaded in a single transaction, so a rejected reservation leaves the database as it was
+func (dhs *DHCPService) loadHosts(hosts []model.DHCPHost) error {
+	err := dhs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dhs.dhcpBucket)
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		// The bucket can't be changed while iterating it, so collect the keys first
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err = b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for _, host := range hosts {
+			if host, err = validateDHCPHost(host); err != nil {
+				return fmt.Errorf("failed to load dhcp-host for %s: %v", host.MAC, err)
+			}
+			if err = dhs.putHostTx(tx, host); err != nil {
+				return fmt.Errorf("failed to load dhcp-host for %s: %v", host.MAC, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	metrics.GetOrCreateCounter(MetricDHCPHostCount).Set(uint64(len(hosts)))
+
+	return nil
+}
+
+// BuildDatabase reads the DHCP config file, if it exists, and syncs the database.
+func (dhs *DHCPService) BuildDatabase() error {
+	data, err := os.ReadFile(dhs.dhcpConfig)
+	if err != nil {
+		if os.IsNotExist(err) {
+			dhs.log.Infof("DHCP config %s does not exist yet, it will be created on the first change", dhs.dhcpConfig)
+			return nil
+		}
+		return err
+	}
+
+	return dhs.loadHosts(dhs.parseDHCPConfig(string(data)))
+}
+
+// UpdateDHCP Syncs the DB to the DHCP config file and reloads dnsmasq through the DNSMasqService's scheduled updates.
+// The reload is always done, as the DNS files the DNSMasqService would check for changes are not touched.
+// If the new config is rejected, or dnsmasq fails to reload, the previous config and reservations are restored.
+func (dhs *DHCPService) UpdateDHCP() error {
+	dhs.updateMu.Lock()
+	defer dhs.updateMu.Unlock()
+
+	previous, readErr := os.ReadFile(dhs.dhcpConfig)
+	if os.IsNotExist(readErr) {
+		previous, readErr = []byte(dnsConfigHeader), nil
+	}
+
+	err := dhs.WriteDHCPConfig()
+	committed := err == nil
+	if committed {
+		err = <-dhs.dns.ScheduleReload()
+	}
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || readErr != nil {
+		return err
+	}
+
+	if committed {
+		if err := util.WriteFileAtomic(dhs.dhcpConfig, previous, dnsFileMode, 0); err != nil {
+			dhs.log.Errorf("Failed to restore previous DHCP config: %v", err)
+			return configErr
+		}
+	}
+	if err := dhs.loadHosts(dhs.parseDHCPConfig(string(previous))); err != nil {
+		dhs.log.Errorf("Failed to restore DHCP reservations: %v", err)
+		return configErr
+	}
+	if configErr.Stage == ConfigStageReload {
+		if err := <-dhs.dns.ScheduleReload(); err != nil {
+			dhs.log.Errorf("Failed to reload dnsmasq with the restored DHCP config: %v", err)
+		}
+	}
+	configErr.RolledBack = true
+
+	return configErr
+}
+
+// WriteDHCPConfig Writes the database out to the DHCP config file, after checking it with the preflight validator
+func (dhs *DHCPService) WriteDHCPConfig() error {
+	hosts, err := dhs.GetAllHosts()
+	if err != nil {
+		return err
+	}
+
+	dhcpConfigData := dnsConfigHeader
+	for _, host := range hosts {
+		dhcpConfigData += renderDHCPHost(host) + "\n"
+	}
+
+	staged, err := util.StageFile(dhs.dhcpConfig, []byte(dhcpConfigData), dnsFileMode)
+	if err != nil {
+		return err
+	}
+	if err = dhs.preflight.Check(staged); err != nil {
+		_ = os.Remove(staged)
+		metrics.GetOrCreateCounter(MetricPreflightFailures).Inc()
+		return err
+	}
+	if err = util.CommitFile(staged, dhs.dhcpConfig, dhs.backups); err != nil {
+		return err
+	}
+	metrics.GetOrCreateCounter(MetricDHCPHostCount).Set(uint64(len(hosts)))
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cclose/dnsmasq-api/model"
+)
+
+const (
+	defaultDHCPLeasesFile = "/var/lib/misc/dnsmasq.leases"
+	ErrorNoDHCPLease      = "no lease found for mac"
+)
+
+// parseLeases parses a dnsmasq lease file. Each DHCPv4 lease line has the form
+//
+//	<expiry> <mac> <ip> <hostname> <client-id>
+//
+// The first "duid <server-duid>" line marks the start of DHCPv6 leases, where the mac is replaced by the IAID.
+// A hostname or client-id of "*" means it is unknown. Malformed lines are skipped.
+func parseLeases(r io.Reader) ([]model.DHCPLease, error) {
+	var leases []model.DHCPLease
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 4 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[2])
+		if ip == nil {
+			continue
+		}
+		lease := model.DHCPLease{IP: ip.String()}
+
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if expiry != 0 {
+			expiryTime := time.Unix(expiry, 0).UTC()
+			lease.Expiry = &expiryTime
+		}
+
+		if ip.To4() != nil {
+			mac, err := net.ParseMAC(fields[1])
+			if err != nil {
+				continue
+			}
+			lease.MAC = mac.String()
+		} else {
+			lease.IAID = fields[1]
+		}
+
+		if fields[3] != "*" {
+			lease.Hostname = fields[3]
+		}
+		if len(fields) > 4 && fields[4] != "*" {
+			lease.ClientID = fields[4]
+		}
+
+		leases = append(leases, lease)
+	}
+
+	return leases, scanner.Err()
+}
+
+// leaseMatches reports whether the lease passes the filter
+func leaseMatches(lease model.DHCPLease, filter model.DHCPLeaseFilter) bool {
+	if filter.MAC != "" && lease.MAC != filter.MAC {
+		return false
+	}
+	if filter.Hostname != "" && !strings.EqualFold(lease.Hostname, filter.Hostname) {
+		return false
+	}
+	if filter.Subnet != nil && !filter.Subnet.Contains(net.ParseIP(lease.IP)) {
+		return false
+	}
+
+	return true
+}
+
+// GetLeases reads the live leases from the dnsmasq lease file, returning those matching the filter
+func (dhs *DHCPService) GetLeases(filter model.DHCPLeaseFilter) ([]model.DHCPLease, error) {
+	if filter.MAC != "" {
+		mac, err := normalizeMAC(filter.MAC)
+		if err != nil {
+			return nil, err
+		}
+		filter.MAC = mac
+	}
+
+	f, err := os.Open(dhs.leasesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dhcp lease file: %v", err)
+	}
+	defer f.Close()
+
+	leases, err := parseLeases(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []model.DHCPLease
+	for _, lease := range leases {
+		if leaseMatches(lease, filter) {
+			matched = append(matched, lease)
+		}
+	}
+
+	return matched, nil
+}
+
+// PromoteLease creates a static reservation from the live DHCPv4 lease held by the MAC address.
+// The lease's hostname is kept unless the request overrides it.
+func (dhs *DHCPService) PromoteLease(mac string, req model.PromoteDHCPLeaseRequest) (model.DHCPHost, error) {
+	leases, err := dhs.GetLeases(model.DHCPLeaseFilter{MAC: mac})
+	if err != nil {
+		return model.DHCPHost{}, err
+	}
+	if len(leases) == 0 {
+		return model.DHCPHost{}, fmt.Errorf("%s", ErrorNoDHCPLease)
+	}
+
+	lease := leases[0]
+	host := model.DHCPHost{
+		MAC:       lease.MAC,
+		IP:        lease.IP,
+		Hostname:  lease.Hostname,
+		LeaseTime: req.LeaseTime,
+	}
+	if req.Hostname != "" {
+		host.Hostname = req.Hostname
+	}
+
+	return dhs.SetHost(host)
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLeases(t *testing.T) {
+	leaseFile := `1718000000 aa:bb:cc:dd:ee:01 192.168.1.10 printer 01:aa:bb:cc:dd:ee:01
+0 aa:bb:cc:dd:ee:02 192.168.1.11 * *
+garbage line
+1718000000 not-a-mac 192.168.1.12 broken *
+duid 00:01:00:01:2c:1f:aa:bb:cc:dd:ee:ff
+1718000500 12345678 fd00::10 laptop 00:01:00:01:2c:1f:11:22:33:44:55:66
+`
+	expiry := time.Unix(1718000000, 0).UTC()
+	expiry6 := time.Unix(1718000500, 0).UTC()
+	want := []model.DHCPLease{
+		{Expiry: &expiry, MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.1.10", Hostname: "printer", ClientID: "01:aa:bb:cc:dd:ee:01"},
+		{MAC: "aa:bb:cc:dd:ee:02", IP: "192.168.1.11"},
+		{Expiry: &expiry6, IAID: "12345678", IP: "fd00::10", Hostname: "laptop", ClientID: "00:01:00:01:2c:1f:11:22:33:44:55:66"},
+	}
+
+	got, err := parseLeases(strings.NewReader(leaseFile))
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestLeaseMatches(t *testing.T) {
+	lease := model.DHCPLease{MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.1.10", Hostname: "Printer"}
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/24")
+	_, otherSubnet, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name   string
+		filter model.DHCPLeaseFilter
+		want   bool
+	}{
+		{name: "Empty", want: true},
+		{name: "MAC", filter: model.DHCPLeaseFilter{MAC: "aa:bb:cc:dd:ee:01"}, want: true},
+		{name: "OtherMAC", filter: model.DHCPLeaseFilter{MAC: "aa:bb:cc:dd:ee:02"}},
+		{name: "HostnameCaseInsensitive", filter: model.DHCPLeaseFilter{Hostname: "printer"}, want: true},
+		{name: "Subnet", filter: model.DHCPLeaseFilter{Subnet: subnet}, want: true},
+		{name: "OtherSubnet", filter: model.DHCPLeaseFilter{Subnet: otherSubnet}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, leaseMatches(lease, tt.filter))
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestParseDHCPHost(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   model.DHCPHost
+		wantOk bool
+	}{
+		{
+			name:   "Full",
+			line:   "dhcp-host=AA:BB:CC:DD:EE:FF,192.168.1.10,printer,12h",
+			want:   model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "192.168.1.10", Hostname: "printer", LeaseTime: "12h"},
+			wantOk: true,
+		},
+		{
+			name:   "IPOnly",
+			line:   "dhcp-host=aa:bb:cc:dd:ee:ff,192.168.1.10",
+			want:   model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "192.168.1.10"},
+			wantOk: true,
+		},
+		{
+			name:   "IPv6",
+			line:   "dhcp-host=aa:bb:cc:dd:ee:ff,[fd00::10],nas,infinite",
+			want:   model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "fd00::10", Hostname: "nas", LeaseTime: "infinite"},
+			wantOk: true,
+		},
+		{
+			name:   "NumericHostname",
+			line:   "dhcp-host=aa:bb:cc:dd:ee:ff,10.0.0.5,1234",
+			want:   model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.5", Hostname: "1234"},
+			wantOk: true,
+		},
+		{
+			name:   "NumericHostnameAndLeaseTime",
+			line:   "dhcp-host=aa:bb:cc:dd:ee:ff,10.0.0.5,1234,3600",
+			want:   model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.5", Hostname: "1234", LeaseTime: "3600"},
+			wantOk: true,
+		},
+		{
+			name:   "LeaseTimeOnly",
+			line:   "dhcp-host=aa:bb:cc:dd:ee:ff,10.0.0.5,45m",
+			want:   model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.5", LeaseTime: "45m"},
+			wantOk: true,
+		},
+		{
+			name: "HostnameOnly",
+			line: "dhcp-host=aa:bb:cc:dd:ee:ff,printer",
+			want: model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", Hostname: "printer"},
+		},
+		{
+			name: "OtherDirective",
+			line: "address=/example.com/10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseDHCPHost(tt.line)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+			if ok {
+				reparsed, _ := parseDHCPHost(renderDHCPHost(got))
+				assert.Equal(t, got, reparsed)
+			}
+		})
+	}
+}
+
+func TestValidateDHCPHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    model.DHCPHost
+		wantErr bool
+	}{
+		{name: "Valid", host: model.DHCPHost{MAC: "aa-bb-cc-dd-ee-ff", IP: "10.0.0.1", Hostname: "nas", LeaseTime: "3600"}},
+		{name: "BadMAC", host: model.DHCPHost{MAC: "aa:bb:cc", IP: "10.0.0.1"}, wantErr: true},
+		{name: "BadIP", host: model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.999"}, wantErr: true},
+		{name: "BadHostname", host: model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.1", Hostname: "a,b"}, wantErr: true},
+		{name: "BadLeaseTime", host: model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.1", LeaseTime: "forever"}, wantErr: true},
+		{name: "NumericHostname", host: model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.1", Hostname: "1234"}},
+		{name: "LeaseTimeHostname", host: model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.1", Hostname: "12h"}, wantErr: true},
+		{name: "BareLeaseTimeWithoutHostname", host: model.DHCPHost{MAC: "aa:bb:cc:dd:ee:ff", IP: "10.0.0.1", LeaseTime: "3600"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateDHCPHost(tt.host)
+			assert.Equal(t, tt.wantErr, err != nil, "validateDHCPHost() error = %v", err)
+		})
+	}
+}
+
+func TestDHCPService_SetHost(t *testing.T) {
+	dir := t.TempDir()
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), dbFileMode, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	config := model.Config{DHCPConfig: filepath.Join(dir, "dhcp.conf")}
+	dhs, err := NewDHCPService(config, db, nil, WithDHCPLogger(testLogger()))
+	require.NoError(t, err)
+
+	_, err = dhs.SetHost(model.DHCPHost{MAC: "aa:bb:cc:dd:ee:01", IP: "10.0.0.1", Hostname: "one"})
+	require.NoError(t, err)
+
+	// Another MAC may not take the same IP
+	_, err = dhs.SetHost(model.DHCPHost{MAC: "aa:bb:cc:dd:ee:02", IP: "10.0.0.1"})
+	assert.EqualError(t, err, ErrorDHCPIPConflict)
+
+	// The same MAC may be updated in place
+	_, err = dhs.SetHost(model.DHCPHost{MAC: "AA:BB:CC:DD:EE:01", IP: "10.0.0.1", Hostname: "uno"})
+	require.NoError(t, err)
+
+	host, err := dhs.GetHostByMAC("aa-bb-cc-dd-ee-01")
+	require.NoError(t, err)
+	assert.Equal(t, "uno", host.Hostname)
+
+	require.NoError(t, dhs.WriteDHCPConfig())
+	data, err := os.ReadFile(config.DHCPConfig)
+	require.NoError(t, err)
+	assert.Equal(t, dnsConfigHeader+"dhcp-host=aa:bb:cc:dd:ee:01,10.0.0.1,uno\n", string(data))
+
+	require.NoError(t, dhs.DeleteHostByMAC("aa:bb:cc:dd:ee:01"))
+	_, err = dhs.GetHostByMAC("aa:bb:cc:dd:ee:01")
+	assert.EqualError(t, err, ErrorNoDHCPHost)
+}
+
+func TestDHCPService_UpdateDHCP(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "api.conf")
+	require.NoError(t, os.WriteFile(configPath, []byte("address=/a.lan/10.0.0.1\n"), dnsFileMode))
+
+	reloader := &countingReloader{}
+	config := model.Config{DnsmasqConfig: configPath, DHCPConfig: filepath.Join(dir, "dhcp.conf")}
+	dns, err := NewDNSMasqService(config, WithDBFilePath(filepath.Join(dir, "dns.db")), WithLogger(testLogger()),
+		WithReloader(reloader))
+	require.NoError(t, err)
+	ds := dns.(*DNSMasqService)
+	t.Cleanup(func() { _ = ds.db.Close() })
+	dhs, err := NewDHCPService(config, ds.db, dns, WithDHCPLogger(testLogger()))
+	require.NoError(t, err)
+
+	// A reservation leaves the DNS files alone, but still has dnsmasq reload
+	_, err = dhs.SetHost(model.DHCPHost{MAC: "aa:bb:cc:dd:ee:01", IP: "10.0.0.1", Hostname: "one"})
+	require.NoError(t, err)
+	require.NoError(t, dhs.UpdateDHCP())
+	assert.Equal(t, 1, reloader.calls)
+	data, err := os.ReadFile(config.DHCPConfig)
+	require.NoError(t, err)
+	assert.Equal(t, dnsConfigHeader+"dhcp-host=aa:bb:cc:dd:ee:01,10.0.0.1,one\n", string(data))
+}
+
+func TestDHCPService_loadHosts(t *testing.T) {
+	dir := t.TempDir()
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), dbFileMode, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	config := model.Config{DHCPConfig: filepath.Join(dir, "dhcp.conf")}
+	svc, err := NewDHCPService(config, db, nil, WithDHCPLogger(testLogger()))
+	require.NoError(t, err)
+	dhs := svc.(*DHCPService)
+	existing := model.DHCPHost{MAC: "aa:bb:cc:dd:ee:01", IP: "10.0.0.1", Hostname: "one"}
+	_, err = dhs.SetHost(existing)
+	require.NoError(t, err)
+
+	// A reservation conflicting with another leaves the database as it was
+	err = dhs.loadHosts([]model.DHCPHost{
+		{MAC: "aa:bb:cc:dd:ee:02", IP: "10.0.0.2"},
+		{MAC: "aa:bb:cc:dd:ee:03", IP: "10.0.0.2"},
+	})
+	assert.EqualError(t, err, "failed to load dhcp-host for aa:bb:cc:dd:ee:03: "+ErrorDHCPIPConflict)
+	hosts, err := dhs.GetAllHosts()
+	require.NoError(t, err)
+	assert.Equal(t, []model.DHCPHost{existing}, hosts)
+
+	require.NoError(t, dhs.loadHosts([]model.DHCPHost{{MAC: "AA:BB:CC:DD:EE:02", IP: "10.0.0.2"}}))
+	hosts, err = dhs.GetAllHosts()
+	require.NoError(t, err)
+	assert.Equal(t, []model.DHCPHost{{MAC: "aa:bb:cc:dd:ee:02", IP: "10.0.0.2"}}, hosts)
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/internal/dnsconf"
+	"github.com/cclose/dnsmasq-api/model"
+)
+
+// dnsmasq directive keys for each record type
+const (
+	directiveAddress = "address"
+	directiveHost    = "host-record"
+	directiveCNAME   = "cname"
+	directiveTXT     = "txt-record"
+	directiveSRV     = "srv-host"
+	directiveMX      = "mx-host"
+	directivePTR     = "ptr-record"
+
+	// metadataCommentPrefix starts the comment holding the metadata of the records on the next line
+	metadataCommentPrefix = "# meta: "
+)
+
+// renderRecord renders a DNSRecord as a dnsmasq config directive
+func renderRecord(r model.DNSRecord) string {
+	switch r.RecordType() {
+	case model.RecordTypeHost:
+		return fmt.Sprintf("%s=%s,%s%s", directiveHost, r.Hostname, r.IP, renderTTL(r.TTL))
+	case model.RecordTypeCNAME:
+		return fmt.Sprintf("%s=%s,%s%s", directiveCNAME, r.Hostname, r.Target, renderTTL(r.TTL))
+	case model.RecordTypeTXT:
+		return fmt.Sprintf("%s=%s,\"%s\"", directiveTXT, r.Hostname, r.Text)
+	case model.RecordTypeSRV:
+		return fmt.Sprintf("%s=%s,%s,%d,%d,%d", directiveSRV, r.Hostname, r.Target, r.Port, r.Priority, r.Weight)
+	case model.RecordTypeMX:
+		return fmt.Sprintf("%s=%s,%s,%d", directiveMX, r.Hostname, r.Target, r.Priority)
+	case model.RecordTypePTR:
+		return fmt.Sprintf("%s=%s,%s", directivePTR, r.Hostname, r.Target)
+	default:
+		return fmt.Sprintf("%s=/%s/%s", directiveAddress, r.Hostname, r.IP)
+	}
+}
+
+// writeRecords writes a line for each record, rendered by render, with the record's metadata comment on the line
+// before it
+func writeRecords(b *strings.Builder, records []model.DNSRecord, render func(model.DNSRecord) string) {
+	for _, record := range records {
+		if meta := renderMetadata(record); meta != "" {
+			b.WriteString(meta)
+			b.WriteByte('\n')
+		}
+		b.WriteString(render(record))
+		b.WriteByte('\n')
+	}
+}
+
+// renderTTL renders the optional TTL field of a directive
+func renderTTL(ttl uint32) string {
+	if ttl == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(",%d", ttl)
+}
+
+// renderMetadata renders the metadata of a record as a comment for the line before its directive.
+// Returns an empty string if the record has no metadata
+func renderMetadata(r model.DNSRecord) string {
+	if r.RecordMetadata.IsZero() {
+		return ""
+	}
+	data, err := json.Marshal(r.RecordMetadata)
+	if err != nil {
+		return ""
+	}
+
+	return metadataCommentPrefix + string(data)
+}
+
+// parseMetadata parses a metadata comment. The bool is false if the line is not a metadata comment
+func parseMetadata(line string) (model.RecordMetadata, bool) {
+	var meta model.RecordMetadata
+	data, found := strings.CutPrefix(strings.TrimSpace(line), metadataCommentPrefix)
+	if !found {
+		return meta, false
+	}
+
+	return meta, json.Unmarshal([]byte(data), &meta) == nil
+}
+
+// parseDirective parses a single line of a dnsmasq config into DNSRecords.
+// The bool is false if the line is not a directive for a supported record type.
+func parseDirective(line string) ([]model.DNSRecord, bool) {
+	parsed := dnsconf.ParseLine(line)
+	if parsed.Kind != dnsconf.KindOption {
+		return nil, false
+	}
+	records, err := parseOption(parsed.Key, parsed.Value)
+
+	return records, err == nil
+}
+
+// parseOption parses a dnsmasq option into DNSRecords. The error says why an option that is not a directive for a
+// supported record type can't be
+func parseOption(key, value string) ([]model.DNSRecord, error) {
+	switch key {
+	case directiveAddress:
+		// address=/<domain>[/<domain>...]/[<ip>]
+		address, err := dnsconf.ParseAddress(value)
+		switch {
+		case err != nil:
+			return nil, err
+		case address.AllDomains():
+			return nil, fmt.Errorf("addresses for every domain are not supported")
+		case address.Blackhole():
+			return nil, fmt.Errorf("blackhole addresses are not supported")
+		case address.Null():
+			return nil, fmt.Errorf("null addresses are not supported")
+		}
+		var records []model.DNSRecord
+		for _, domain := range address.Domains {
+			records = append(records, model.DNSRecord{Hostname: domain, Type: model.RecordTypeAddress, IP: address.IP})
+		}
+		return records, nil
+
+	case directiveHost:
+		// host-record=<name>[,<name>...],[<IPv4>],[<IPv6>][,<TTL>]
+		var names, ips []string
+		var ttl uint32
+		for _, field := range splitFields(value) {
+			if net.ParseIP(field) != nil {
+				ips = append(ips, field)
+			} else if v, err := strconv.ParseUint(field, 10, 32); err == nil {
+				ttl = uint32(v)
+			} else {
+				names = append(names, field)
+			}
+		}
+		var records []model.DNSRecord
+		for _, name := range names {
+			for _, ip := range ips {
+				records = append(records, model.DNSRecord{Hostname: name, Type: model.RecordTypeHost, IP: ip, TTL: ttl})
+			}
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("host-record needs a name and an IP")
+		}
+		return records, nil
+
+	case directiveCNAME:
+		// cname=<cname>,[<cname>,]<target>[,<TTL>]
+		fields := splitFields(value)
+		var ttl uint32
+		if len(fields) > 2 {
+			if v, err := strconv.ParseUint(fields[len(fields)-1], 10, 32); err == nil {
+				ttl = uint32(v)
+				fields = fields[:len(fields)-1]
+			}
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("cname needs an alias and a target")
+		}
+		target := fields[len(fields)-1]
+		var records []model.DNSRecord
+		for _, alias := range fields[:len(fields)-1] {
+			records = append(records, model.DNSRecord{Hostname: alias, Type: model.RecordTypeCNAME, Target: target, TTL: ttl})
+		}
+		return records, nil
+
+	case directiveTXT:
+		// txt-record=<name>[[,<text>],<text>]
+		name, text, _ := strings.Cut(value, ",")
+		return []model.DNSRecord{{
+			Hostname: strings.TrimSpace(name), Type: model.RecordTypeTXT, Text: joinTXTStrings(text),
+		}}, nil
+
+	case directiveSRV:
+		// srv-host=<_service>.<_prot>.[<domain>],[<target>[,<port>[,<priority>[,<weight>]]]]
+		fields := splitFields(value)
+		record := model.DNSRecord{Hostname: fields[0], Type: model.RecordTypeSRV}
+		if len(fields) > 1 {
+			record.Target = fields[1]
+		}
+		record.Port = parseUint16Field(fields, 2)
+		record.Priority = parseUint16Field(fields, 3)
+		record.Weight = parseUint16Field(fields, 4)
+		return []model.DNSRecord{record}, nil
+
+	case directiveMX:
+		// mx-host=<mx name>[[,<hostname>],<preference>]
+		fields := splitFields(value)
+		record := model.DNSRecord{Hostname: fields[0], Type: model.RecordTypeMX}
+		if len(fields) > 1 {
+			record.Target = fields[1]
+		}
+		record.Priority = parseUint16Field(fields, 2)
+		return []model.DNSRecord{record}, nil
+
+	case directivePTR:
+		// ptr-record=<name>[,<target>]
+		fields := splitFields(value)
+		record := model.DNSRecord{Hostname: fields[0], Type: model.RecordTypePTR}
+		if len(fields) > 1 {
+			record.Target = fields[1]
+		}
+		return []model.DNSRecord{record}, nil
+	}
+
+	return nil, fmt.Errorf("option '%s' is not a supported record directive", key)
+}
+
+// recordsFromConfig converts the options of a parsed config into DNSRecords, along with the metadata comments above
+// them. Every option that is not a valid directive for a supported record type is returned as an issue
+func recordsFromConfig(config *dnsconf.Config) ([]model.DNSRecord, []dnsconf.Issue) {
+	var records []model.DNSRecord
+	var issues []dnsconf.Issue
+	var meta model.RecordMetadata
+	for _, line := range config.Lines {
+		// A metadata comment belongs to the directive on the next line
+		if lineMeta, ok := parseMetadata(line.Raw); ok {
+			meta = lineMeta
+			continue
+		}
+		if line.Kind != dnsconf.KindOption {
+			meta = model.RecordMetadata{}
+			continue
+		}
+
+		lineRecords, err := parseOption(line.Key, line.Value)
+		if err != nil {
+			issues = append(issues, dnsconf.IssueAt(line, err.Error()))
+		}
+		for _, record := range lineRecords {
+			record.RecordMetadata = meta
+			if err := checkRecordSyntax(record); err != nil {
+				issues = append(issues, dnsconf.IssueAt(line, err.Error()))
+				continue
+			}
+			records = append(records, record)
+		}
+		meta = model.RecordMetadata{}
+	}
+
+	return records, issues
+}
+
+// splitFields splits a comma separated directive value, trimming whitespace from each field
+func splitFields(value string) []string {
+	fields := strings.Split(value, ",")
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+	}
+
+	return fields
+}
+
+// joinTXTStrings joins the comma separated strings of a txt-record into one, as the zone parser does for the strings
+// of a TXT record. Commas inside the quotes of a string are part of it
+func joinTXTStrings(text string) string {
+	var b, segment strings.Builder
+	quoted := false
+	flush := func() {
+		b.WriteString(strings.Trim(strings.TrimSpace(segment.String()), "\""))
+		segment.Reset()
+	}
+	for _, c := range text {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			flush()
+			continue
+		}
+		segment.WriteRune(c)
+	}
+	flush()
+
+	return b.String()
+}
+
+// parseUint16Field parses the field at index i as a uint16, returning 0 if it is missing or invalid
+func parseUint16Field(fields []string, i int) uint16 {
+	if i >= len(fields) {
+		return 0
+	}
+	v, err := strconv.ParseUint(fields[i], 10, 16)
+	if err != nil {
+		return 0
+	}
+
+	return uint16(v)
+}
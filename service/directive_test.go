@@ -0,0 +1,195 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cclose/dnsmasq-api/internal/dnsconf"
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDirective(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   []model.DNSRecord
+		wantOk bool
+	}{
+		{
+			name:   "Address",
+			line:   "address=/example.com/10.1.9.1",
+			want:   []model.DNSRecord{{Hostname: "example.com", Type: model.RecordTypeAddress, IP: "10.1.9.1"}},
+			wantOk: true,
+		},
+		{
+			name: "HostRecordMultipleNames",
+			line: "host-record=laptop,laptop.lan,192.168.0.1,1234::100,300",
+			want: []model.DNSRecord{
+				{Hostname: "laptop", Type: model.RecordTypeHost, IP: "192.168.0.1", TTL: 300},
+				{Hostname: "laptop", Type: model.RecordTypeHost, IP: "1234::100", TTL: 300},
+				{Hostname: "laptop.lan", Type: model.RecordTypeHost, IP: "192.168.0.1", TTL: 300},
+				{Hostname: "laptop.lan", Type: model.RecordTypeHost, IP: "1234::100", TTL: 300},
+			},
+			wantOk: true,
+		},
+		{
+			name: "CNAMEWithTTL",
+			line: "cname=www.example.com,web.example.com,app.example.com,600",
+			want: []model.DNSRecord{
+				{Hostname: "www.example.com", Type: model.RecordTypeCNAME, Target: "app.example.com", TTL: 600},
+				{Hostname: "web.example.com", Type: model.RecordTypeCNAME, Target: "app.example.com", TTL: 600},
+			},
+			wantOk: true,
+		},
+		{
+			name: "TXT",
+			line: `txt-record=_acme-challenge.example.com,"abc,def"`,
+			want: []model.DNSRecord{
+				{Hostname: "_acme-challenge.example.com", Type: model.RecordTypeTXT, Text: "abc,def"},
+			},
+			wantOk: true,
+		},
+		{
+			name: "TXTMultipleStrings",
+			line: `txt-record=example.com,"v=spf1 ","a,mx", -all`,
+			want: []model.DNSRecord{
+				{Hostname: "example.com", Type: model.RecordTypeTXT, Text: "v=spf1 a,mx-all"},
+			},
+			wantOk: true,
+		},
+		{
+			name: "SRV",
+			line: "srv-host=_ldap._tcp.example.com,ldap.example.com,389,10,100",
+			want: []model.DNSRecord{{
+				Hostname: "_ldap._tcp.example.com", Type: model.RecordTypeSRV,
+				Target: "ldap.example.com", Port: 389, Priority: 10, Weight: 100,
+			}},
+			wantOk: true,
+		},
+		{
+			name: "MX",
+			line: "mx-host=example.com,mail.example.com,50",
+			want: []model.DNSRecord{{
+				Hostname: "example.com", Type: model.RecordTypeMX, Target: "mail.example.com", Priority: 50,
+			}},
+			wantOk: true,
+		},
+		{
+			name: "PTR",
+			line: "ptr-record=1.9.1.10.in-addr.arpa,example.com",
+			want: []model.DNSRecord{{
+				Hostname: "1.9.1.10.in-addr.arpa", Type: model.RecordTypePTR, Target: "example.com",
+			}},
+			wantOk: true,
+		},
+		{
+			name: "Comment",
+			line: "# Managed by DNSMasq API",
+		},
+		{
+			name: "UnsupportedDirective",
+			line: "server=8.8.8.8",
+		},
+		{
+			name: "MultiDomainAddress",
+			line: "address=/a.com/b.com/1.2.3.4",
+			want: []model.DNSRecord{
+				{Hostname: "a.com", Type: model.RecordTypeAddress, IP: "1.2.3.4"},
+				{Hostname: "b.com", Type: model.RecordTypeAddress, IP: "1.2.3.4"},
+			},
+			wantOk: true,
+		},
+		{
+			name:   "TrailingComment",
+			line:   "address=/example.com/10.1.9.1 # the office",
+			want:   []model.DNSRecord{{Hostname: "example.com", Type: model.RecordTypeAddress, IP: "10.1.9.1"}},
+			wantOk: true,
+		},
+		{
+			name: "BlackholeAddress",
+			line: "address=/ads.example.com/",
+		},
+		{
+			name: "NullAddress",
+			line: "address=/ads.example.com/#",
+		},
+		{
+			name: "AllDomainsAddress",
+			line: "address=/#/10.1.9.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseDirective(tt.line)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRenderRecord_RoundTrip(t *testing.T) {
+	records := []model.DNSRecord{
+		{Hostname: "example.com", Type: model.RecordTypeAddress, IP: "10.1.9.1"},
+		{Hostname: "host.lan", Type: model.RecordTypeHost, IP: "fd00::1", TTL: 60},
+		{Hostname: "www.example.com", Type: model.RecordTypeCNAME, Target: "example.com", TTL: 300},
+		{Hostname: "example.com", Type: model.RecordTypeTXT, Text: "v=spf1 -all"},
+		{Hostname: "_sip._udp.example.com", Type: model.RecordTypeSRV, Target: "sip.example.com", Port: 5060, Priority: 1, Weight: 2},
+		{Hostname: "example.com", Type: model.RecordTypeMX, Target: "mail.example.com", Priority: 10},
+		{Hostname: "1.9.1.10.in-addr.arpa", Type: model.RecordTypePTR, Target: "example.com"},
+	}
+
+	for _, record := range records {
+		t.Run(string(record.Type), func(t *testing.T) {
+			got, ok := parseDirective(renderRecord(record))
+			assert.True(t, ok)
+			assert.Equal(t, []model.DNSRecord{record}, got)
+		})
+	}
+}
+
+func TestRenderMetadata_RoundTrip(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	record := model.DNSRecord{Hostname: "build-17.lab", Type: model.RecordTypeAddress, IP: "10.0.0.17",
+		RecordMetadata: model.RecordMetadata{
+			Owner: "ci", Description: "build box\n# address=/evil/1.2.3.4", Tags: []string{"ephemeral"},
+			CreatedAt: &created, UpdatedAt: &created,
+		},
+	}
+
+	line := renderMetadata(record)
+	assert.NotContains(t, line, "\n", "metadata must stay on one line")
+	meta, ok := parseMetadata(line)
+	assert.True(t, ok)
+	assert.Equal(t, record.RecordMetadata, meta)
+
+	assert.Empty(t, renderMetadata(model.DNSRecord{Hostname: "a.lan", IP: "10.0.0.1"}))
+	_, ok = parseMetadata("# Managed by DNSMasq API")
+	assert.False(t, ok)
+}
+
+func TestRecordsFromConfig(t *testing.T) {
+	config := dnsconf.ParseString(`# Managed by DNSMasq API
+# meta: {"owner":"ops"}
+address=/a.lan/b.lan/10.0.0.1
+address=/ads.lan/
+log-queries
+host-record=bad_name!,10.0.0.2
+cname=www.lan,a.lan
+`, "api.conf")
+
+	records, issues := recordsFromConfig(config)
+	require.Len(t, records, 3)
+	assert.Equal(t, "ops", records[0].Owner)
+	assert.Equal(t, "ops", records[1].Owner)
+	assert.Empty(t, records[2].Owner)
+
+	require.Len(t, issues, 3)
+	assert.Equal(t, dnsconf.Position{File: "api.conf", Line: 4}, issues[0].Position)
+	assert.Equal(t, "blackhole addresses are not supported", issues[0].Reason)
+	assert.Equal(t, 5, issues[1].Line)
+	assert.Equal(t, "log-queries", issues[1].Text)
+	assert.Equal(t, 6, issues[2].Line)
+}
@@ -1,39 +1,89 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/VictoriaMetrics/metrics"
+	"github.com/cclose/dnsmasq-api/internal/dnsconf"
+	"github.com/cclose/dnsmasq-api/internal/util"
 	"github.com/cclose/dnsmasq-api/model"
 	"github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
+	"net"
 	"os"
-	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	dbFileMode          os.FileMode = 0600
-	dnsFileMode         os.FileMode = 0644
-	dnsConfigHeader                 = "# Managed by DNSMasq API\n"
-	defaultDBFilePath               = "dns.db"
-	defaultDBBucketName             = "dnsRecords"
-
-	MetricDNSCount   = "dnsmasq_hostname_total"
-	MetricIPCount    = "dnsmasq_ip_total"
-	MetricDNSReloads = "dnsmasq_reloads_total"
+	dbFileMode                os.FileMode = 0600
+	dnsFileMode               os.FileMode = 0644
+	dnsConfigHeader                       = "# Managed by DNSMasq API\n"
+	dnsConfigGenerationPrefix             = "# Generation: "
+	defaultDBFilePath                     = "dns.db"
+	defaultDBBucketName                   = "dnsRecords"
+	dbMetaBucketName                      = "meta"
+	dbMetaGenerationSuffix                = ".generation"
+	// dbOpenTimeout is how long to wait for another process, like a running server, to release the DB file
+	dbOpenTimeout = 5 * time.Second
+
+	MetricDNSCount    = "dnsmasq_hostname_total"
+	MetricIPCount     = "dnsmasq_ip_total"
+	MetricRecordCount = "dnsmasq_records_total"
+	MetricDNSReloads  = "dnsmasq_reloads_total"
+	MetricDNSDrift    = "dnsmasq_config_drift_total"
+	MetricGeneration  = "dnsmasq_config_generation"
 )
 
 type IDNSMasqService interface {
 	BuildDatabase() error
 	ReloadDNSMasq() error
 	UpdateDNSMasq() error
+	ScheduleUpdate() <-chan error
+	ScheduleReload() <-chan error
+	FlushUpdates() error
 	WriteDNSMasq() error
 
 	GetAllIPs() ([]model.DNSRecord, error)
 	GetIPByHost(host string) ([]model.DNSRecord, error)
-	SetIPByHost(hostname string, ips []string, appendIP bool) ([]model.DNSRecord, error)
-	DeleteByHost(host string) error
+	SetIPByHost(ctx context.Context, hostname string, ips []string, appendIP bool) ([]model.DNSRecord, error)
+	DeleteByHost(ctx context.Context, host string) error
+
+	GetRecords(recordType model.RecordType) ([]model.DNSRecord, error)
+	FindRecords(filter model.DNSRecordFilter) ([]model.DNSRecord, error)
+	GetRecordsByHost(host string, recordType model.RecordType) ([]model.DNSRecord, error)
+	GetRecordsByHostWithRevision(host string, recordType model.RecordType) ([]model.DNSRecord, uint64, error)
+	SetRecordsByHost(ctx context.Context, hostname string, recordType model.RecordType, records []model.DNSRecord, appendRecords bool) ([]model.DNSRecord, error)
+	ReplaceRecordsByHost(ctx context.Context, hostname string, recordType model.RecordType, records []model.DNSRecord) ([]model.DNSRecord, error)
+	AppendRecordsByHost(ctx context.Context, hostname string, recordType model.RecordType, records []model.DNSRecord) ([]model.DNSRecord, error)
+	RemoveIPByHost(ctx context.Context, hostname string, recordType model.RecordType, ip string) ([]model.DNSRecord, error)
+	RemoveIPsByHost(ctx context.Context, hostname string, recordType model.RecordType, ips []string) ([]model.DNSRecord, error)
+	PatchRecordsByHost(ctx context.Context, hostname string, recordType model.RecordType, add []model.DNSRecord, remove []string) ([]model.DNSRecord, error)
+	DeleteRecordsByHost(ctx context.Context, host string, recordType model.RecordType) error
+	ApplyBulk(ctx context.Context, ops []model.DNSBulkOperation) ([]model.DNSBulkResult, error)
+	ImportRecords(ctx context.Context, records []model.DNSRecord, opts model.ImportOptions) (model.ImportResult, error)
+	RenewRecordsByHost(ctx context.Context, hostname string, req model.RenewDNSRecordRequest) ([]model.DNSRecord, error)
+	GetAuditLog(query model.AuditQuery) (model.AuditPage, error)
+
+	GetHostHistory(hostname string) ([]model.HostRevision, error)
+	GetHostRevision(hostname string) (uint64, error)
+	RevertHost(ctx context.Context, hostname string, rev uint64) ([]model.DNSRecord, error)
+	CreateSnapshot(ctx context.Context, description string) (model.Snapshot, error)
+	ListSnapshots() ([]model.Snapshot, error)
+	RestoreSnapshot(ctx context.Context, id uint64) (int, error)
+	ReapExpired() (int, error)
+	StartReaper(interval time.Duration) (stop func())
+	Resolve(name string) (model.Resolution, error)
+	DefaultRecordType(hostname string) (model.RecordType, error)
+
+	SyncStatus() (model.SyncStatus, error)
+	Reconcile(policy string) (model.SyncStatus, error)
+	StartSyncWatcher(delay time.Duration) (stop func(), err error)
 }
 
 type DNSMasqService struct {
@@ -42,8 +92,33 @@ type DNSMasqService struct {
 	dnsBucket  []byte
 	dbFilePath string
 
-	dnsMasqConfig     string
-	skipDNSMasqReload bool
+	auditBucket     []byte
+	auditExportFile string
+	// auditMu serializes writes to the audit export file
+	auditMu sync.Mutex
+
+	historyBucket  []byte
+	snapshotBucket []byte
+	maxRevisions   int
+
+	dnsMasqConfig  string
+	dnsMasqBackups int
+	reloader       Reloader
+	preflight      preflight
+	validator      validator
+
+	outputMode    string
+	hostsFile     string
+	hostsDir      bool
+	hostsReloader Reloader
+	// merge only manages the marked block of each file
+	merge bool
+	// syncPolicy decides which side wins when the files were changed outside the API
+	syncPolicy string
+
+	// updateMu serializes config updates
+	updateMu  sync.Mutex
+	scheduler *updateScheduler
 
 	log *logrus.Logger
 }
@@ -57,8 +132,32 @@ func NewDNSMasqService(config model.Config, opts ...DNSMasqServiceOption) (IDNSM
 		dbFilePath: defaultDBFilePath,
 		dnsBucket:  []byte(defaultDBBucketName),
 
-		dnsMasqConfig:     config.DnsmasqConfig,
-		skipDNSMasqReload: config.SkipDNSMasqReload,
+		auditBucket:     []byte(defaultAuditBucketName),
+		auditExportFile: config.Audit.ExportFile,
+
+		historyBucket:  []byte(defaultHistoryBucketName),
+		snapshotBucket: []byte(defaultSnapshotBucketName),
+		maxRevisions:   config.History.MaxRevisions,
+
+		dnsMasqConfig:  config.DnsmasqConfig,
+		dnsMasqBackups: config.DnsmasqBackups,
+		preflight:      newPreflight(config.Preflight),
+
+		outputMode: strings.ToLower(config.Output.Mode),
+		hostsFile:  config.Output.HostsFile,
+		hostsDir:   config.Output.HostsDir,
+		merge:      config.Output.Merge,
+	}
+	switch ds.outputMode {
+	case "":
+		ds.outputMode = OutputModeAddress
+	case OutputModeAddress:
+	case OutputModeHosts:
+		if ds.hostsFile == "" {
+			return nil, fmt.Errorf("output.hosts_file is required for the %s output mode", OutputModeHosts)
+		}
+	default:
+		return nil, fmt.Errorf("unknown output mode '%s'", config.Output.Mode)
 	}
 
 	// Apply any options
@@ -70,6 +169,30 @@ func NewDNSMasqService(config model.Config, opts ...DNSMasqServiceOption) (IDNSM
 		ds.log = logrus.New()
 	}
 
+	if ds.reloader == nil {
+		reloader, err := NewReloader(config.Reload, config.SkipDNSMasqReload)
+		if err != nil {
+			return nil, err
+		}
+		ds.reloader = reloader
+	}
+	if ds.hostsReloader == nil {
+		reloader, err := newHostsReloader(config)
+		if err != nil {
+			return nil, err
+		}
+		ds.hostsReloader = reloader
+	}
+
+	var err error
+	if ds.validator, err = newValidator(config.Validation); err != nil {
+		return nil, err
+	}
+	if ds.syncPolicy, err = model.ParseSyncPolicy(config.Sync.Policy); err != nil {
+		return nil, err
+	}
+	ds.scheduler = newUpdateScheduler(ds.updateDNSMasq, config.Debounce.Window, config.Debounce.MaxDelay, ds.log)
+
 	if err := ds.openDB(ds.dbFilePath); err != nil {
 		return nil, err
 	}
@@ -90,6 +213,13 @@ func WithDNSBucket(bucket string) DNSMasqServiceOption {
 	}
 }
 
+// WithAuditBucket Sets the name of the DB Bucket to store the audit log
+func WithAuditBucket(bucket string) DNSMasqServiceOption {
+	return func(ds *DNSMasqService) {
+		ds.auditBucket = []byte(bucket)
+	}
+}
+
 // WithLogger Sets the logger for the service to use
 func WithLogger(logger *logrus.Logger) DNSMasqServiceOption {
 	return func(ds *DNSMasqService) {
@@ -104,12 +234,37 @@ func WithDBFilePath(filePath string) DNSMasqServiceOption {
 	}
 }
 
+// WithReloader Sets how the service reloads DNSMasq, instead of the reload strategy from config
+func WithReloader(reloader Reloader) DNSMasqServiceOption {
+	return func(ds *DNSMasqService) {
+		ds.reloader = reloader
+	}
+}
+
+// WithHostsReloader Sets how the service has DNSMasq reread the hosts file, instead of the output reload strategy
+// from config
+func WithHostsReloader(reloader Reloader) DNSMasqServiceOption {
+	return func(ds *DNSMasqService) {
+		ds.hostsReloader = reloader
+	}
+}
+
+// WithDB Sets an already opened bolt DB for the service to use, so it can be shared with other services
+func WithDB(db *bolt.DB) DNSMasqServiceOption {
+	return func(ds *DNSMasqService) {
+		ds.db = db
+	}
+}
+
 // WithConfig Creates DNSMasqServiceOptions from a DatabaseConfig
 func WithConfig(dbConfig model.DatabaseConfig) DNSMasqServiceOption {
 	options := []DNSMasqServiceOption{}
 	if dbConfig.BucketName != "" && dbConfig.BucketName != defaultDBBucketName {
 		options = append(options, WithDNSBucket(dbConfig.BucketName))
 	}
+	if dbConfig.AuditBucketName != "" && dbConfig.AuditBucketName != defaultAuditBucketName {
+		options = append(options, WithAuditBucket(dbConfig.AuditBucketName))
+	}
 	if dbConfig.FilePath != "" && dbConfig.FilePath != defaultDBFilePath {
 		options = append(options, WithDBFilePath(dbConfig.FilePath))
 	}
@@ -121,14 +276,41 @@ func WithConfig(dbConfig model.DatabaseConfig) DNSMasqServiceOption {
 	}
 }
 
+// OpenDB Opens the bolt DB described by the DatabaseConfig so that it can be shared between services
+func OpenDB(dbConfig model.DatabaseConfig) (*bolt.DB, error) {
+	return openDBFile(dbConfig.FilePath, false)
+}
+
+// openDBFile opens a bolt DB file, waiting for another process holding it open to release it
+func openDBFile(dbPath string, readOnly bool) (*bolt.DB, error) {
+	if dbPath == "" {
+		dbPath = defaultDBFilePath
+	}
+
+	db, err := bolt.Open(dbPath, dbFileMode, &bolt.Options{Timeout: dbOpenTimeout, ReadOnly: readOnly})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("db file %s is locked by another process", dbPath)
+	}
+
+	return db, err
+}
+
 func (ds *DNSMasqService) openDB(dbPath string) (err error) {
-	ds.db, err = bolt.Open(dbPath, dbFileMode, nil)
-	if err != nil {
-		return
+	// The DB may have been provided WithDB
+	if ds.db == nil {
+		ds.db, err = openDBFile(dbPath, false)
+		if err != nil {
+			return
+		}
 	}
 
-	// Make sure our DNS Bucket exists
+	// Make sure our DNS, Audit, History, Snapshot and Meta Buckets exist
 	err = ds.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{[]byte(dbMetaBucketName), ds.auditBucket, ds.historyBucket, ds.snapshotBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
 		_, err := tx.CreateBucketIfNotExists(ds.dnsBucket)
 		return err
 	})
@@ -136,8 +318,48 @@ func (ds *DNSMasqService) openDB(dbPath string) (err error) {
 	return
 }
 
+// decodeRecords unmarshalls the records stored for a host
+func decodeRecords(data []byte) ([]model.DNSRecord, error) {
+	var records []model.DNSRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	// Records stored before types were introduced are address records
+	for i := range records {
+		records[i].Type = records[i].RecordType()
+	}
+
+	return records, nil
+}
+
+// filterRecords returns the records matching the given type. An empty type matches all records
+func filterRecords(records []model.DNSRecord, recordType model.RecordType) []model.DNSRecord {
+	if recordType == "" {
+		return records
+	}
+
+	var filtered []model.DNSRecord
+	for _, record := range records {
+		if record.RecordType() == recordType {
+			filtered = append(filtered, record)
+		}
+	}
+
+	return filtered
+}
+
 // GetAllIPs retrieves all DNS records from the database.
 func (ds *DNSMasqService) GetAllIPs() ([]model.DNSRecord, error) {
+	return ds.GetRecords("")
+}
+
+// GetRecords retrieves all DNS records of the given type from the database. An empty type returns all records.
+func (ds *DNSMasqService) GetRecords(recordType model.RecordType) ([]model.DNSRecord, error) {
+	return ds.FindRecords(model.DNSRecordFilter{Type: recordType})
+}
+
+// FindRecords retrieves all DNS records matching the filter from the database.
+func (ds *DNSMasqService) FindRecords(filter model.DNSRecordFilter) ([]model.DNSRecord, error) {
 	var records []model.DNSRecord
 
 	err := ds.db.View(func(tx *bolt.Tx) error {
@@ -147,12 +369,16 @@ func (ds *DNSMasqService) GetAllIPs() ([]model.DNSRecord, error) {
 		}
 
 		return bucket.ForEach(func(k, v []byte) error {
-			var hostRecords []model.DNSRecord
-			if err := json.Unmarshal(v, &hostRecords); err != nil {
+			hostRecords, err := decodeRecords(v)
+			if err != nil {
 				return err
 			}
-			// append all host records to records
-			records = append(records, hostRecords...)
+			// append all matching host records to records
+			for _, record := range hostRecords {
+				if filter.Matches(record) {
+					records = append(records, record)
+				}
+			}
 			return nil
 		})
 	})
@@ -160,11 +386,28 @@ func (ds *DNSMasqService) GetAllIPs() ([]model.DNSRecord, error) {
 	return records, err
 }
 
-const ErrorNoIPForHost = "no records found for host"
+const (
+	ErrorNoIPForHost = "no records found for host"
+	ErrorIPNotFound  = "ip not found for host"
+)
 
-// GetIPByHost retrieves all IP addresses for the given hostname.
+// GetIPByHost retrieves all records for the given hostname.
 func (ds *DNSMasqService) GetIPByHost(host string) ([]model.DNSRecord, error) {
+	return ds.GetRecordsByHost(host, "")
+}
+
+// GetRecordsByHost retrieves all records of the given type for the given hostname. An empty type returns all records.
+func (ds *DNSMasqService) GetRecordsByHost(host string, recordType model.RecordType) ([]model.DNSRecord, error) {
+	records, _, err := ds.GetRecordsByHostWithRevision(host, recordType)
+	return records, err
+}
+
+// GetRecordsByHostWithRevision retrieves all records of the given type for the given hostname, along with the
+// hostname's current revision. An empty type returns all records.
+func (ds *DNSMasqService) GetRecordsByHostWithRevision(host string,
+	recordType model.RecordType) ([]model.DNSRecord, uint64, error) {
 	var records []model.DNSRecord
+	var rev uint64
 
 	err := ds.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(ds.dnsBucket)
@@ -177,24 +420,32 @@ func (ds *DNSMasqService) GetIPByHost(host string) ([]model.DNSRecord, error) {
 			return fmt.Errorf("%s", ErrorNoIPForHost) // Return error if no records found
 		}
 
-		if err := json.Unmarshal(data, &records); err != nil {
+		hostRecords, err := decodeRecords(data)
+		if err != nil {
 			return err
 		}
 
+		records = filterRecords(hostRecords, recordType)
+		if len(records) == 0 {
+			return fmt.Errorf("%s", ErrorNoIPForHost)
+		}
+		rev = ds.hostRevisionTx(tx, host)
+
 		return nil
 	})
 
-	return records, err
+	return records, rev, err
 }
 
-// removeDuplicates removes duplicate DNS records based on IP.
+// removeDuplicates removes duplicate DNS records based on type and value.
 func removeDuplicates(records []model.DNSRecord) []model.DNSRecord {
 	seen := make(map[string]bool)
 	var uniqueRecords []model.DNSRecord
 
 	for _, record := range records {
-		if !seen[record.IP] {
-			seen[record.IP] = true
+		key := string(record.RecordType()) + "/" + record.Value()
+		if !seen[key] {
+			seen[key] = true
 			uniqueRecords = append(uniqueRecords, record)
 		}
 	}
@@ -204,165 +455,953 @@ func removeDuplicates(records []model.DNSRecord) []model.DNSRecord {
 
 // SetIPByHost sets or appends an IP address for the given hostname.
 // If appendIP is true, it will add the IP to the existing list, otherwise it will replace it.
-func (ds *DNSMasqService) SetIPByHost(hostname string, ips []string, appendIP bool) ([]model.DNSRecord, error) {
+func (ds *DNSMasqService) SetIPByHost(ctx context.Context, hostname string, ips []string,
+	appendIP bool) ([]model.DNSRecord, error) {
 	var records []model.DNSRecord
-	err := ds.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(ds.dnsBucket)
-		if bucket == nil {
-			return fmt.Errorf("bucket not found")
-		}
+	for _, ip := range ips {
+		records = append(records, model.DNSRecord{
+			Hostname: hostname, Type: model.RecordTypeAddress, IP: ip,
+		})
+	}
 
-		for _, ip := range ips {
-			records = append(records, model.DNSRecord{
-				Hostname: hostname, IP: ip,
-			})
-		}
+	return ds.SetRecordsByHost(ctx, hostname, model.RecordTypeAddress, records, appendIP)
+}
+
+// SetRecordsByHost sets or appends records of the given type for the given hostname.
+// If appendRecords is true, the records are added to the existing records of that type, otherwise they replace them.
+func (ds *DNSMasqService) SetRecordsByHost(ctx context.Context, hostname string, recordType model.RecordType,
+	records []model.DNSRecord, appendRecords bool) ([]model.DNSRecord, error) {
+	if appendRecords {
+		return ds.AppendRecordsByHost(ctx, hostname, recordType, records)
+	}
+
+	return ds.ReplaceRecordsByHost(ctx, hostname, recordType, records)
+}
+
+// ReplaceRecordsByHost replaces the records of the given type for the given hostname, returning the records of that
+// type now stored. Records of other types for the hostname are left untouched. The change is audited under the actor
+// in ctx, and only made if the hostname meets the precondition in ctx.
+func (ds *DNSMasqService) ReplaceRecordsByHost(ctx context.Context, hostname string, recordType model.RecordType,
+	records []model.DNSRecord) ([]model.DNSRecord, error) {
+	return ds.updateRecordsByHost(ctx, model.AuditOpSet, hostname, recordType, records, false)
+}
+
+// AppendRecordsByHost adds records of the given type to those already stored for the given hostname, returning the
+// records of that type now stored. An appended record with the same value as a stored one replaces it. The change is
+// audited under the actor in ctx, and only made if the hostname meets the precondition in ctx.
+func (ds *DNSMasqService) AppendRecordsByHost(ctx context.Context, hostname string, recordType model.RecordType,
+	records []model.DNSRecord) ([]model.DNSRecord, error) {
+	return ds.updateRecordsByHost(ctx, model.AuditOpAppend, hostname, recordType, records, true)
+}
 
-		if appendIP {
-			currRecords, err := ds.GetIPByHost(hostname)
-			if err != nil && err.Error() != ErrorNoIPForHost {
+// updateRecordsByHost validates the records, then sets or appends them in a single transaction
+func (ds *DNSMasqService) updateRecordsByHost(ctx context.Context, op, hostname string, recordType model.RecordType,
+	records []model.DNSRecord, appendRecords bool) ([]model.DNSRecord, error) {
+	newRecords := newHostRecords(hostname, recordType, records)
+	if err := ds.validator.Check(newRecords); err != nil {
+		return nil, err
+	}
+
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		return ds.changeHostTx(ctx, tx, op, hostname, func() (err error) {
+			if newRecords, err = ds.setRecordsTx(tx, hostname, recordType, newRecords, appendRecords); err != nil {
 				return err
 			}
-			records = append(currRecords, records...)
-		}
+			return ds.checkShadowingTx(tx, hostname)
+		})
+	})
 
-		records = removeDuplicates(records)
+	return newRecords, err
+}
 
-		newData, err := json.Marshal(records)
-		if err != nil {
+// RemoveIPByHost removes the records with the given IP from the given hostname, returning the hostname's remaining
+// records. An empty type removes the IP from records of every type, and the hostname is removed along with its last
+// record. The change is audited under the actor in ctx, and only made if the hostname meets the precondition in ctx.
+func (ds *DNSMasqService) RemoveIPByHost(ctx context.Context, hostname string, recordType model.RecordType,
+	ip string) ([]model.DNSRecord, error) {
+	return ds.RemoveIPsByHost(ctx, hostname, recordType, []string{ip})
+}
+
+// RemoveIPsByHost removes the records with any of the given IPs from the given hostname, as RemoveIPByHost. Either
+// every IP is found and removed or none are
+func (ds *DNSMasqService) RemoveIPsByHost(ctx context.Context, hostname string, recordType model.RecordType,
+	ips []string) ([]model.DNSRecord, error) {
+	var remaining []model.DNSRecord
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		return ds.changeHostTx(ctx, tx, model.AuditOpRemove, hostname, func() (err error) {
+			remaining, err = ds.removeIPsTx(tx, hostname, recordType, ips)
 			return err
-		}
+		})
+	})
+
+	return remaining, err
+}
 
-		return bucket.Put([]byte(hostname), newData)
+// PatchRecordsByHost removes the records with the IPs in remove from the given hostname, whatever their type, and
+// appends the records in add as the given type, returning all of the hostname's records. Removals are made first, so
+// an IP in both is kept, and the hostname is removed if no records are left. Both happen in one change, audited under
+// the actor in ctx and only made if the hostname meets the precondition in ctx.
+func (ds *DNSMasqService) PatchRecordsByHost(ctx context.Context, hostname string, recordType model.RecordType,
+	add []model.DNSRecord, remove []string) ([]model.DNSRecord, error) {
+	newRecords := newHostRecords(hostname, recordType, add)
+	if err := ds.validator.Check(newRecords); err != nil {
+		return nil, err
+	}
+
+	var records []model.DNSRecord
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		return ds.changeHostTx(ctx, tx, model.AuditOpPatch, hostname, func() (err error) {
+			if len(remove) > 0 {
+				if _, err = ds.removeIPsTx(tx, hostname, "", remove); err != nil {
+					return err
+				}
+			}
+			if len(newRecords) > 0 {
+				if _, err = ds.setRecordsTx(tx, hostname, recordType, newRecords, true); err != nil {
+					return err
+				}
+				if err = ds.checkShadowingTx(tx, hostname); err != nil {
+					return err
+				}
+			}
+			records, err = ds.hostRecordsTx(tx, hostname)
+			return err
+		})
 	})
 
 	return records, err
 }
 
-// DeleteByHost deletes all IP addresses for the given hostname.
-func (ds *DNSMasqService) DeleteByHost(host string) error {
-	return ds.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(ds.dnsBucket)
-		if bucket == nil {
-			return fmt.Errorf("bucket not found")
+// changeHostTx makes a change to the records of a hostname within a transaction. The precondition in ctx is checked
+// against the hostname before the change, and the change is audited under the actor in ctx as op. No change is made
+// while the fail sync policy leaves the managed files drifted
+func (ds *DNSMasqService) changeHostTx(ctx context.Context, tx *bolt.Tx, op, hostname string,
+	change func() error) error {
+	if err := ds.checkDriftTx(tx); err != nil {
+		return err
+	}
+	before, err := ds.hostRecordsTx(tx, hostname)
+	if err != nil {
+		return err
+	}
+	if err = ds.checkPreconditionTx(ctx, tx, hostname, len(before) > 0); err != nil {
+		return err
+	}
+	if err = change(); err != nil {
+		return err
+	}
+
+	return ds.recordChangeTx(ctx, tx, op, hostname, before)
+}
+
+// dnsBucketTx returns the DNS Bucket within a transaction
+func (ds *DNSMasqService) dnsBucketTx(tx *bolt.Tx) (*bolt.Bucket, error) {
+	bucket := tx.Bucket(ds.dnsBucket)
+	if bucket == nil {
+		return nil, fmt.Errorf("bucket not found")
+	}
+
+	return bucket, nil
+}
+
+// newHostRecords copies records from a request, setting their hostname and type. An empty type is an address record.
+// Timestamps are cleared, since they are set by the service when the records are stored
+func newHostRecords(hostname string, recordType model.RecordType, records []model.DNSRecord) []model.DNSRecord {
+	if recordType == "" {
+		recordType = model.RecordTypeAddress
+	}
+
+	var newRecords []model.DNSRecord
+	for _, record := range records {
+		record.Hostname = hostname
+		record.Type = recordType
+		record.CreatedAt = nil
+		record.UpdatedAt = nil
+		newRecords = append(newRecords, record)
+	}
+
+	return newRecords
+}
+
+// setRecordsTx sets or appends records of the given type for the given hostname within a transaction,
+// returning the records of that type now stored. The records are not checked against the validation policy.
+// Records without an updated timestamp are stamped now, keeping the created timestamp of the record they replace,
+// and records with a TTLSeconds but no expiry expire that long from now
+func (ds *DNSMasqService) setRecordsTx(tx *bolt.Tx, hostname string, recordType model.RecordType,
+	records []model.DNSRecord, appendRecords bool) ([]model.DNSRecord, error) {
+	if recordType == "" {
+		recordType = model.RecordTypeAddress
+	}
+
+	var newRecords []model.DNSRecord
+	for _, record := range records {
+		record.Hostname = hostname
+		record.Type = recordType
+		if err := record.Validate(); err != nil {
+			return nil, err
 		}
+		newRecords = append(newRecords, record)
+	}
 
-		return bucket.Delete([]byte(host))
+	bucket, err := ds.dnsBucketTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var hostRecords []model.DNSRecord
+	if data := bucket.Get([]byte(hostname)); data != nil {
+		if hostRecords, err = decodeRecords(data); err != nil {
+			return nil, err
+		}
+	}
+
+	existing := make(map[string]model.DNSRecord)
+	for _, record := range filterRecords(hostRecords, recordType) {
+		existing[record.Value()] = record
+	}
+	now := time.Now().UTC()
+	replaced := make(map[string]bool)
+	for i, record := range newRecords {
+		replaced[record.Value()] = true
+		if record.UpdatedAt != nil {
+			continue
+		}
+		newRecords[i].CreatedAt = &now
+		if old, ok := existing[record.Value()]; ok && old.CreatedAt != nil {
+			newRecords[i].CreatedAt = old.CreatedAt
+		}
+		newRecords[i].UpdatedAt = &now
+		if record.TTLSeconds > 0 && record.ExpiresAt == nil {
+			expiresAt := now.Add(time.Duration(record.TTLSeconds) * time.Second)
+			newRecords[i].ExpiresAt = &expiresAt
+		}
+	}
+
+	// Keep the records of other types and, when appending, the existing records of this type that are not replaced
+	var stored []model.DNSRecord
+	for _, record := range hostRecords {
+		if record.RecordType() != recordType || (appendRecords && !replaced[record.Value()]) {
+			stored = append(stored, record)
+		}
+	}
+	stored = removeDuplicates(append(stored, newRecords...))
+	newRecords = filterRecords(stored, recordType)
+
+	if len(stored) == 0 {
+		return newRecords, bucket.Delete([]byte(hostname))
+	}
+
+	newData, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRecords, bucket.Put([]byte(hostname), newData)
+}
+
+// DeleteByHost deletes all records for the given hostname.
+func (ds *DNSMasqService) DeleteByHost(ctx context.Context, host string) error {
+	return ds.DeleteRecordsByHost(ctx, host, "")
+}
+
+// DeleteRecordsByHost deletes all records of the given type for the given hostname. An empty type deletes all records.
+// The change is audited under the actor in ctx, and only made if the hostname meets the precondition in ctx.
+func (ds *DNSMasqService) DeleteRecordsByHost(ctx context.Context, host string, recordType model.RecordType) error {
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		return ds.changeHostTx(ctx, tx, model.AuditOpDelete, host, func() error {
+			return ds.deleteRecordsTx(tx, host, recordType)
+		})
 	})
 }
 
-// BuildDatabase reads the DNSMasq config file and syncs the in-memory database.
-func (ds *DNSMasqService) BuildDatabase() error {
-	dnsCount := 0
-	ipsCount := 0
+// sameIP reports whether two addresses are the same IP, whichever way they are written
+func sameIP(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+
+	return ipA != nil && ipA.Equal(ipB)
+}
 
-	data, err := os.ReadFile(ds.dnsMasqConfig)
+// removeIPsTx removes the records of the given type with any of the given IPs from the hostname within a
+// transaction, returning the hostname's remaining records. An empty type matches every type. Every IP must be found
+func (ds *DNSMasqService) removeIPsTx(tx *bolt.Tx, hostname string, recordType model.RecordType,
+	ips []string) ([]model.DNSRecord, error) {
+	hostRecords, err := ds.hostRecordsTx(tx, hostname)
 	if err != nil {
-		ds.log.Fatalf("Failed to read dnsmasq config file: %v", err)
+		return nil, err
+	}
+	if len(hostRecords) == 0 {
+		return nil, fmt.Errorf("%s", ErrorNoIPForHost)
 	}
-	lines := strings.Split(string(data), "\n")
-	entries := make(map[string][]string)
-	for _, line := range lines {
-		if strings.HasPrefix(line, "address=/") {
-			parts := strings.Split(line, "/")
-			if len(parts) == 3 {
-				var ips []string
-				var ok bool
-				hostname := parts[1]
-				ip := strings.TrimSpace(parts[2])
-				if ips, ok = entries[hostname]; ok {
-					ips = append(ips, ip)
-				} else {
-					ips = []string{ip}
+
+	found := make([]bool, len(ips))
+	var remaining []model.DNSRecord
+	for _, record := range hostRecords {
+		removed := false
+		if recordType == "" || record.RecordType() == recordType {
+			for i, ip := range ips {
+				if record.IP != "" && sameIP(record.IP, ip) {
+					found[i] = true
+					removed = true
 				}
-				entries[hostname] = ips
 			}
 		}
+		if !removed {
+			remaining = append(remaining, record)
+		}
+	}
+	for _, ok := range found {
+		if !ok {
+			return nil, fmt.Errorf("%s", ErrorIPNotFound)
+		}
 	}
 
-	err = ds.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(ds.dnsBucket)
-		if b == nil {
+	return remaining, ds.putHostRecordsTx(tx, hostname, remaining)
+}
+
+// deleteRecordsTx deletes the records of the given type for the given hostname within a transaction
+func (ds *DNSMasqService) deleteRecordsTx(tx *bolt.Tx, host string, recordType model.RecordType) error {
+	bucket, err := ds.dnsBucketTx(tx)
+	if err != nil {
+		return err
+	}
+
+	data := bucket.Get([]byte(host))
+	if recordType == "" || data == nil {
+		return bucket.Delete([]byte(host))
+	}
+
+	hostRecords, err := decodeRecords(data)
+	if err != nil {
+		return err
+	}
+	var remaining []model.DNSRecord
+	for _, record := range hostRecords {
+		if record.RecordType() != recordType {
+			remaining = append(remaining, record)
+		}
+	}
+	if len(remaining) == 0 {
+		return bucket.Delete([]byte(host))
+	}
+
+	newData, err := json.Marshal(remaining)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(host), newData)
+}
+
+const ErrorBulkFailed = "bulk operations failed, no changes were made"
+
+// ApplyBulk applies a list of operations in a single transaction. Either every operation is applied or, if any of
+// them fails, none are and ErrorBulkFailed is returned. The results report the outcome of each operation.
+// Each operation is audited under the actor in ctx.
+func (ds *DNSMasqService) ApplyBulk(ctx context.Context, ops []model.DNSBulkOperation) ([]model.DNSBulkResult, error) {
+	var results []model.DNSBulkResult
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		err := ds.checkDriftTx(tx)
+		if err != nil {
+			return err
+		}
+		results, err = ds.applyBulkTx(ctx, tx, ops)
+		return err
+	})
+
+	return results, err
+}
+
+// applyBulkTx applies a list of operations within a transaction. Every operation is attempted so that all the
+// failures are reported, but any failure fails the transaction
+func (ds *DNSMasqService) applyBulkTx(ctx context.Context, tx *bolt.Tx,
+	ops []model.DNSBulkOperation) ([]model.DNSBulkResult, error) {
+	results := make([]model.DNSBulkResult, len(ops))
+	failed := false
+	for i, op := range ops {
+		results[i] = model.DNSBulkResult{Index: i, Op: op.Op, Hostname: op.Hostname}
+		records, err := ds.applyOperationTx(ctx, tx, op)
+		if err != nil {
+			results[i].Error = err.Error()
+			var validationErr *ValidationError
+			if errors.As(err, &validationErr) {
+				results[i].Errors = validationErr.Errors
+			}
+			failed = true
+			continue
+		}
+		results[i].Records = records
+	}
+	if failed {
+		return results, fmt.Errorf("%s", ErrorBulkFailed)
+	}
+
+	return results, nil
+}
+
+// applyOperationTx applies and audits a single bulk operation within a transaction
+func (ds *DNSMasqService) applyOperationTx(ctx context.Context, tx *bolt.Tx,
+	op model.DNSBulkOperation) ([]model.DNSRecord, error) {
+	if op.Hostname == "" {
+		return nil, fmt.Errorf("hostname is required")
+	}
+	before, err := ds.hostRecordsTx(tx, op.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	recordType, err := model.RequestRecordType(op.Type, op.Wildcard, defaultRecordTypeOf(before))
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case model.BulkOpUpsert, model.BulkOpAppend:
+		// ips is shorthand for records that only carry an IP
+		records := op.Records
+		for _, ip := range op.IPs {
+			records = append(records, model.DNSRecord{IP: ip})
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("record list is required")
+		}
+		records = newHostRecords(op.Hostname, recordType, model.WithDefaults(records, op.RecordMetadata, op.TTL))
+		if err = ds.validator.Check(records); err != nil {
+			return nil, err
+		}
+		if records, err = ds.setRecordsTx(tx, op.Hostname, recordType, records, op.Op == model.BulkOpAppend); err != nil {
+			return nil, err
+		}
+		if err = ds.checkShadowingTx(tx, op.Hostname); err != nil {
+			return nil, err
+		}
+		return records, ds.recordChangeTx(ctx, tx, op.Op, op.Hostname, before)
+	case model.BulkOpDelete:
+		if op.Type == "" && !op.Wildcard {
+			recordType = ""
+		}
+		if err = ds.deleteRecordsTx(tx, op.Hostname, recordType); err != nil {
+			return nil, err
+		}
+		return nil, ds.recordChangeTx(ctx, tx, op.Op, op.Hostname, before)
+	case model.BulkOpRemove:
+		if len(op.IPs) == 0 {
+			return nil, fmt.Errorf("IP address list is required")
+		}
+		if op.Type == "" && !op.Wildcard {
+			recordType = ""
+		}
+		records, err := ds.removeIPsTx(tx, op.Hostname, recordType, op.IPs)
+		if err != nil {
+			return nil, err
+		}
+		return records, ds.recordChangeTx(ctx, tx, op.Op, op.Hostname, before)
+	}
+
+	return nil, fmt.Errorf("unknown operation '%s'", op.Op)
+}
+
+// parseDNSMasq parses the records out of a DNSMasq config, skipping invalid records
+func (ds *DNSMasqService) parseDNSMasq(data string) []model.DNSRecord {
+	records, issues := recordsFromConfig(dnsconf.ParseString(data, ds.dnsMasqConfig))
+	for _, issue := range issues {
+		// The directive loading the hosts file is written by the API itself
+		if key, _, _ := strings.Cut(issue.Text, "="); key == directiveAddnHosts || key == directiveHostsDir {
+			continue
+		}
+		ds.log.Warnf("Skipping unsupported line %s: %s (%s)", issue.Position, strings.TrimSpace(issue.Text), issue.Reason)
+	}
+
+	return records
+}
+
+// parseGeneration reads the generation number from a DNSMasq config header
+func parseGeneration(data string) (uint64, bool) {
+	for _, line := range strings.Split(data, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			break // the header is over
+		}
+		if genStr, found := strings.CutPrefix(line, dnsConfigGenerationPrefix); found {
+			gen, err := strconv.ParseUint(strings.TrimSpace(genStr), 10, 64)
+			return gen, err == nil
+		}
+	}
+
+	return 0, false
+}
+
+// diffRecords compares two sets of records by their rendered directives,
+// returning the directives only in want (added) and only in have (removed)
+func diffRecords(have, want []model.DNSRecord) (added, removed []string) {
+	counts := make(map[string]int)
+	for _, record := range have {
+		counts[renderRecord(record)] += 1
+	}
+	for _, record := range want {
+		line := renderRecord(record)
+		if counts[line] > 0 {
+			counts[line] -= 1
+		} else {
+			added = append(added, line)
+		}
+	}
+	for line, count := range counts {
+		for ; count > 0; count-- {
+			removed = append(removed, line)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
+
+// generationKey the Meta Bucket key holding the config generation for the DNS Bucket
+func (ds *DNSMasqService) generationKey() []byte {
+	return []byte(string(ds.dnsBucket) + dbMetaGenerationSuffix)
+}
+
+// getGeneration reads the generation of the last DNSMasq config written from the database. 0 if never written
+func (ds *DNSMasqService) getGeneration() (uint64, error) {
+	var gen uint64
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dbMetaBucketName))
+		if bucket == nil {
 			return fmt.Errorf("bucket not found")
 		}
 
-		err = b.ForEach(func(k, v []byte) error {
-			return b.Delete(k)
-		})
+		data := bucket.Get(ds.generationKey())
+		if data == nil {
+			return nil
+		}
 
+		var err error
+		gen, err = strconv.ParseUint(string(data), 10, 64)
 		return err
 	})
+
+	return gen, err
+}
+
+// setGeneration records the generation of the DNSMasq config in the database
+func (ds *DNSMasqService) setGeneration(gen uint64) error {
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dbMetaBucketName))
+		if bucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		return bucket.Put(ds.generationKey(), []byte(strconv.FormatUint(gen, 10)))
+	})
+	if err == nil {
+		metrics.GetOrCreateCounter(MetricGeneration).Set(gen)
+	}
+
+	return err
+}
+
+// BuildDatabase reads the DNSMasq config file, and the hosts file in hosts output mode, and syncs the database.
+// In merge mode only the managed block of each file is read.
+// A database that never wrote the config is loaded from it. Otherwise any changes made to the files outside the API
+// are resolved by the sync policy, and the files are rewritten if the database still differs from them.
+// Under the fail sync policy, drift is logged and left for POST /sync, and the service starts refusing changes.
+func (ds *DNSMasqService) BuildDatabase() error {
+	files, err := ds.readConfigFiles()
+	if err != nil {
+		ds.log.Fatalf("Failed to read dnsmasq config file: %v", err)
+	}
+	fileRecords := ds.parseConfigFiles(files)
+	fileGen, _ := parseGeneration(ds.managedContent(files.conf))
+
+	dbGen, err := ds.getGeneration()
 	if err != nil {
 		return err
 	}
+	if dbGen != 0 {
+		status, err := ds.resolveDrift(files, ds.syncPolicy, true)
+		if err != nil && err.Error() == ErrorConfigDrift {
+			// The service still starts, as reconciling the drift needs the API, but every change is refused until then
+			ds.log.Errorf("Starting with dnsmasq config %s drifted from the database (%v), changes are refused "+
+				"until it is reconciled with POST /sync", ds.dnsMasqConfig, err)
+			return nil
+		} else if err != nil {
+			return err
+		}
 
-	for k, v := range entries {
-		dnsCount += 1
-		ipsCount += len(v)
-		_, err = ds.SetIPByHost(k, v, false)
+		dbRecords, err := ds.GetAllIPs()
 		if err != nil {
 			return err
 		}
+		if !sameDirectives(dbRecords, fileRecords) {
+			return ds.WriteDNSMasq()
+		}
+		if status.InSync {
+			ds.log.Infof("dnsmasq config %s is in sync with the database at generation %d", ds.dnsMasqConfig, dbGen)
+		}
+		updateRecordMetrics(dbRecords)
+	} else if err = ds.loadRecords(systemContext(auditActorConfig), model.AuditOpLoad, fileRecords); err != nil {
+		return err
 	}
 
-	metrics.GetOrCreateCounter(MetricDNSCount).Set(uint64(dnsCount))
-	metrics.GetOrCreateCounter(MetricIPCount).Set(uint64(ipsCount))
+	clearSyncMetrics()
+	if err = ds.setWritten(fileRecords); err != nil {
+		return err
+	}
+
+	return ds.setGeneration(max(dbGen, fileGen))
+}
+
+// loadRecords replaces all the records in the database with the given records. Every hostname that changes gets a
+// new revision, audited as op under the actor in ctx
+func (ds *DNSMasqService) loadRecords(ctx context.Context, op string, records []model.DNSRecord) error {
+	var hostnames []string
+	entries := make(map[string]map[model.RecordType][]model.DNSRecord)
+	for _, record := range records {
+		byType, ok := entries[record.Hostname]
+		if !ok {
+			byType = make(map[model.RecordType][]model.DNSRecord)
+			entries[record.Hostname] = byType
+			hostnames = append(hostnames, record.Hostname)
+		}
+		byType[record.Type] = append(byType[record.Type], record)
+	}
+
+	// Wipe and reload the bucket in a single transaction, so a failure leaves the database as it was
+	var allRecords []model.DNSRecord
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		b, err := ds.dnsBucketTx(tx)
+		if err != nil {
+			return err
+		}
+
+		previous, err := ds.allHostRecordsTx(tx)
+		if err != nil {
+			return err
+		}
+		for hostname := range previous {
+			if err = b.Delete([]byte(hostname)); err != nil {
+				return err
+			}
+		}
+
+		// The records come from the config, so they are loaded even if the validation policy would reject them
+		for _, hostname := range hostnames {
+			for _, recordType := range model.RecordTypes {
+				typeRecords, ok := entries[hostname][recordType]
+				if !ok {
+					continue
+				}
+				if typeRecords, err = ds.setRecordsTx(tx, hostname, recordType, typeRecords, false); err != nil {
+					return err
+				}
+				allRecords = append(allRecords, typeRecords...)
+			}
+		}
+
+		for hostname := range previous {
+			if _, ok := entries[hostname]; !ok {
+				hostnames = append(hostnames, hostname)
+			}
+		}
+		for _, hostname := range hostnames {
+			after, err := ds.hostRecordsTx(tx, hostname)
+			if err != nil {
+				return err
+			}
+			if sameRecords(previous[hostname], after) {
+				continue
+			}
+			if err = ds.recordChangeTx(ctx, tx, op, hostname, previous[hostname]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	updateRecordMetrics(allRecords)
 
 	return nil
 }
 
-// ReloadDNSMasq Calls DNSMasq to reload it's config
+// updateRecordMetrics sets the hostname, ip, and per-type record gauges from the given records
+func updateRecordMetrics(records []model.DNSRecord) {
+	uniqHosts := make(map[string]struct{})
+	ipsCount := 0
+	typeCounts := make(map[model.RecordType]uint64)
+	expiring := 0
+	for _, record := range records {
+		if record.ExpiresAt != nil {
+			expiring += 1
+		}
+		// Track uniq hostnames
+		uniqHosts[record.Hostname] = struct{}{}
+		if record.IP != "" {
+			ipsCount += 1
+		}
+		typeCounts[record.RecordType()] += 1
+	}
+
+	metrics.GetOrCreateCounter(MetricDNSCount).Set(uint64(len(uniqHosts)))
+	metrics.GetOrCreateCounter(MetricIPCount).Set(uint64(ipsCount))
+	metrics.GetOrCreateCounter(MetricRecordsExpiring).Set(uint64(expiring))
+	for _, recordType := range model.RecordTypes {
+		metrics.GetOrCreateCounter(fmt.Sprintf(`%s{type="%s"}`, MetricRecordCount, recordType)).Set(typeCounts[recordType])
+	}
+}
+
+// ReloadDNSMasq Calls DNSMasq to reload it's config using the configured Reloader
 func (ds *DNSMasqService) ReloadDNSMasq() error {
+	return ds.reloadWith(ds.reloader)
+}
+
+// reloadWith Calls DNSMasq to reload using the given Reloader, counting reloads and failures
+func (ds *DNSMasqService) reloadWith(reloader Reloader) error {
+	if _, noop := reloader.(NoopReloader); noop {
+		return nil
+	}
+
 	metrics.GetOrCreateCounter(MetricDNSReloads).Inc()
-	cmd := exec.Command("sudo", "systemctl", "restart", "dnsmasq.service")
-	err := cmd.Run()
+	err := reloader.Reload()
 	if err != nil {
+		metrics.GetOrCreateCounter(MetricReloadFailures).Inc()
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			err = &ConfigError{Stage: ConfigStageReload, Err: err}
+		}
 		return err
 	}
 
 	return nil
 }
 
-// UpdateDNSMasq Syncs the in-memory DB to the DNS Masq file and reloads the service
+// UpdateDNSMasq Syncs the DB to the DNS Masq file and reloads the service.
+// Only the hosts file is reread when nothing else changed, and nothing is reloaded if neither file changed.
+// If the new config fails the preflight check, the live config is left in place and the database is restored from
+// it. If dnsmasq fails to reload, the previous config and database are restored and dnsmasq is reloaded again.
+// Changes made to the files outside the API are resolved by the sync policy first, see resolveDrift.
 func (ds *DNSMasqService) UpdateDNSMasq() error {
-	err := ds.WriteDNSMasq()
+	return ds.updateDNSMasq(false)
+}
+
+// updateDNSMasq Runs UpdateDNSMasq, doing a full reload even if neither file changed when reload is set
+func (ds *DNSMasqService) updateDNSMasq(reload bool) error {
+	ds.updateMu.Lock()
+	defer ds.updateMu.Unlock()
+
+	_, err := ds.update(ds.syncPolicy, reload)
+	return err
+}
+
+// update does the work of UpdateDNSMasq with updateMu held, resolving any changes made to the files outside the API
+// by the policy first. The status the files were found in is returned
+func (ds *DNSMasqService) update(policy string, reload bool) (model.SyncStatus, error) {
+	previous, readErr := ds.readConfigFiles()
+	var status model.SyncStatus
+	if readErr == nil {
+		var err error
+		if status, err = ds.resolveDrift(previous, policy, false); err != nil {
+			return status, err
+		}
+	}
+	prevGen, err := ds.getGeneration()
 	if err != nil {
-		return err
+		return status, err
 	}
 
-	if !ds.skipDNSMasqReload {
-		err = ds.ReloadDNSMasq()
-		if err != nil {
+	confChanged, hostsChanged, err := ds.writeDNSMasq()
+	var configErr *ConfigError
+	if errors.As(err, &configErr) && readErr == nil {
+		// The live config was never replaced, so only the database needs restoring
+		previousRecords := ds.parseConfigFiles(previous)
+		if err := ds.loadRecords(systemContext(auditActorConfig), model.AuditOpRollback, previousRecords); err != nil {
+			ds.log.Errorf("Failed to restore database after rejected dnsmasq config: %v", err)
+			return status, configErr
+		}
+		if err := ds.setWritten(previousRecords); err != nil {
+			ds.log.Errorf("Failed to record the restored dnsmasq config: %v", err)
+		}
+		configErr.RolledBack = true
+		return status, configErr
+	} else if err != nil {
+		return status, err
+	}
+
+	reloader := ds.reloader
+	if !confChanged && !reload {
+		if !hostsChanged {
+			return status, nil
+		}
+		reloader = ds.hostsReloader
+	}
+
+	err = ds.reloadWith(reloader)
+	if errors.As(err, &configErr) && readErr == nil {
+		ds.log.Errorf("Failed to reload dnsmasq, restoring generation %d of %s: %v", prevGen, ds.dnsMasqConfig, err)
+		if err := ds.rollback(previous, prevGen); err != nil {
+			ds.log.Errorf("Failed to restore previous dnsmasq config: %v", err)
+			return status, configErr
+		}
+		configErr.RolledBack = true
+		if err := ds.reloadWith(reloader); err != nil {
+			ds.log.Errorf("Failed to reload dnsmasq with the restored config: %v", err)
+		}
+		return status, configErr
+	}
+
+	return status, err
+}
+
+// ScheduleUpdate Requests an UpdateDNSMasq. With a debounce window configured, requests arriving close together are
+// coalesced into a single update. The returned channel receives the result of the update covering this request
+func (ds *DNSMasqService) ScheduleUpdate() <-chan error {
+	return ds.scheduler.Schedule(false)
+}
+
+// ScheduleReload Requests an update like ScheduleUpdate, but with dnsmasq always fully reloaded, for changes to files
+// the DNSMasqService does not manage itself
+func (ds *DNSMasqService) ScheduleReload() <-chan error {
+	return ds.scheduler.Schedule(true)
+}
+
+// FlushUpdates Runs any update still waiting out the debounce window now
+func (ds *DNSMasqService) FlushUpdates() error {
+	return ds.scheduler.Flush()
+}
+
+// rollback restores the previous DNSMasq config and hosts file, and the database records and generation they hold
+func (ds *DNSMasqService) rollback(previous configFiles, prevGen uint64) error {
+	if err := util.WriteFileAtomic(ds.dnsMasqConfig, previous.conf, dnsFileMode, 0); err != nil {
+		return err
+	}
+	if ds.hostsMode() {
+		if err := util.WriteFileAtomic(ds.hostsFile, previous.hosts, dnsFileMode, 0); err != nil {
 			return err
 		}
 	}
+	previousRecords := ds.parseConfigFiles(previous)
+	if err := ds.loadRecords(systemContext(auditActorConfig), model.AuditOpRollback, previousRecords); err != nil {
+		return err
+	}
+	if err := ds.setWritten(previousRecords); err != nil {
+		return err
+	}
 
-	return nil
+	return ds.setGeneration(prevGen)
+}
+
+// renderDNSMasq renders the records as a DNSMasq config with the generation in the header,
+// followed by any extra directives
+func renderDNSMasq(records []model.DNSRecord, gen uint64, directives ...string) string {
+	var b strings.Builder
+	b.WriteString(dnsConfigHeader)
+	b.WriteString(fmt.Sprintf("%s%d\n", dnsConfigGenerationPrefix, gen))
+	for _, directive := range directives {
+		b.WriteString(directive)
+		b.WriteByte('\n')
+	}
+	writeRecords(&b, records, renderRecord)
+
+	return b.String()
+}
+
+// withoutGeneration strips the generation line from a DNSMasq config, so configs can be compared by their records
+func withoutGeneration(data string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(data, "\n") {
+		if !strings.HasPrefix(line, dnsConfigGenerationPrefix) {
+			b.WriteString(line)
+		}
+	}
+
+	return b.String()
 }
 
-// WriteDNSMasq Writes the in-memory database out to the DNS Masq config file
+// withoutMetadata strips the metadata comments from a rendered file, so files can be compared by their directives
+func withoutMetadata(data string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(data, "\n") {
+		if !strings.HasPrefix(line, metadataCommentPrefix) {
+			b.WriteString(line)
+		}
+	}
+
+	return b.String()
+}
+
+// WriteDNSMasq Writes the database out to the DNS Masq config file as the next generation.
+// The new config is staged and checked by the preflight validator, then replaces the live config atomically,
+// keeping the previous versions as backups. In hosts output mode the host records are written to the hosts file.
+// In merge mode only the managed block of each file is replaced.
 func (ds *DNSMasqService) WriteDNSMasq() error {
+	_, _, err := ds.writeDNSMasq()
+	return err
+}
 
-	// Set our file header
-	dnsConfigData := dnsConfigHeader
-	ips, err := ds.GetAllIPs()
+// writeDNSMasq writes the DNS Masq config and hosts file, reporting which of them changed
+func (ds *DNSMasqService) writeDNSMasq() (confChanged, hostsChanged bool, err error) {
+	records, err := ds.GetAllIPs()
 	if err != nil {
-		return err
+		return false, false, err
+	}
+	gen, err := ds.getGeneration()
+	if err != nil {
+		return false, false, err
 	}
+	gen += 1
 
-	uniqHosts := make(map[string]struct{})
+	confRecords, hostsRecords := ds.splitRecords(records)
+	var directives []string
+	if ds.hostsMode() {
+		directives = append(directives, ds.hostsDirective())
+	}
+	previous, _ := os.ReadFile(ds.dnsMasqConfig)
+	confData, err := ds.mergeFile(ds.dnsMasqConfig, previous, renderDNSMasq(confRecords, gen, directives...))
+	if err != nil {
+		return false, false, err
+	}
+	// dnsmasq ignores the metadata comments, so changes to them alone do not need a reload
+	confChanged = withoutMetadata(withoutGeneration(string(previous))) != withoutMetadata(withoutGeneration(confData))
 
-	for _, ip := range ips {
-		// Track uniq hostnames
-		uniqHosts[ip.Hostname] = struct{}{}
-		dnsConfigData += fmt.Sprintf("address=/%s/%s\n", ip.Hostname, ip.IP)
+	// Stage the file and check it before replacing the live config
+	staged, err := util.StageFile(ds.dnsMasqConfig, []byte(confData), dnsFileMode)
+	if err != nil {
+		return false, false, err
+	}
+	if err = ds.preflight.Check(staged); err != nil {
+		_ = os.Remove(staged)
+		metrics.GetOrCreateCounter(MetricPreflightFailures).Inc()
+		return false, false, err
 	}
 
-	// Write out the file
-	err = os.WriteFile(ds.dnsMasqConfig, []byte(dnsConfigData), dnsFileMode)
+	// The hosts file goes first, so it exists by the time dnsmasq loads a config pointing at it
+	if ds.hostsMode() {
+		previousHosts, _ := os.ReadFile(ds.hostsFile)
+		hostsData, err := ds.mergeFile(ds.hostsFile, previousHosts, renderHosts(hostsRecords))
+		if err != nil {
+			_ = os.Remove(staged)
+			return false, false, err
+		}
+		hostsChanged = withoutMetadata(string(previousHosts)) != withoutMetadata(hostsData)
+		if string(previousHosts) != hostsData {
+			if err = util.WriteFileAtomic(ds.hostsFile, []byte(hostsData), dnsFileMode, ds.dnsMasqBackups); err != nil {
+				_ = os.Remove(staged)
+				return false, false, err
+			}
+		}
+	}
+	if err = util.CommitFile(staged, ds.dnsMasqConfig, ds.dnsMasqBackups); err != nil {
+		return false, hostsChanged, err
+	}
 
-	metrics.GetOrCreateCounter(MetricDNSCount).Set(uint64(len(uniqHosts)))
-	metrics.GetOrCreateCounter(MetricIPCount).Set(uint64(len(ips)))
+	updateRecordMetrics(records)
+	clearSyncMetrics()
+	if err = ds.setWritten(records); err != nil {
+		return confChanged, hostsChanged, err
+	}
 
-	return err
+	return confChanged, hostsChanged, ds.setGeneration(gen)
 }
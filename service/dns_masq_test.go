@@ -1,21 +1,30 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"github.com/cclose/dnsmasq-api/internal/util"
 	"github.com/cclose/dnsmasq-api/model"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	bolt "go.etcd.io/bbolt"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 )
 
 func TestDNSMasqService_BuildDatabase(t *testing.T) {
 	type fields struct {
-		db                *bolt.DB
-		dnsBucket         []byte
-		dbFilePath        string
-		dnsMasqConfig     string
-		skipDNSMasqReload bool
-		log               *logrus.Logger
+		db            *bolt.DB
+		dnsBucket     []byte
+		dbFilePath    string
+		dnsMasqConfig string
+		reloader      Reloader
+		log           *logrus.Logger
 	}
 	tests := []struct {
 		name    string
@@ -27,12 +36,12 @@ func TestDNSMasqService_BuildDatabase(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := &DNSMasqService{
-				db:                tt.fields.db,
-				dnsBucket:         tt.fields.dnsBucket,
-				dbFilePath:        tt.fields.dbFilePath,
-				dnsMasqConfig:     tt.fields.dnsMasqConfig,
-				skipDNSMasqReload: tt.fields.skipDNSMasqReload,
-				log:               tt.fields.log,
+				db:            tt.fields.db,
+				dnsBucket:     tt.fields.dnsBucket,
+				dbFilePath:    tt.fields.dbFilePath,
+				dnsMasqConfig: tt.fields.dnsMasqConfig,
+				reloader:      tt.fields.reloader,
+				log:           tt.fields.log,
 			}
 			if err := ds.BuildDatabase(); (err != nil) != tt.wantErr {
 				t.Errorf("BuildDatabase() error = %v, wantErr %v", err, tt.wantErr)
@@ -43,12 +52,12 @@ func TestDNSMasqService_BuildDatabase(t *testing.T) {
 
 func TestDNSMasqService_DeleteByHost(t *testing.T) {
 	type fields struct {
-		db                *bolt.DB
-		dnsBucket         []byte
-		dbFilePath        string
-		dnsMasqConfig     string
-		skipDNSMasqReload bool
-		log               *logrus.Logger
+		db            *bolt.DB
+		dnsBucket     []byte
+		dbFilePath    string
+		dnsMasqConfig string
+		reloader      Reloader
+		log           *logrus.Logger
 	}
 	type args struct {
 		host string
@@ -64,14 +73,14 @@ func TestDNSMasqService_DeleteByHost(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := &DNSMasqService{
-				db:                tt.fields.db,
-				dnsBucket:         tt.fields.dnsBucket,
-				dbFilePath:        tt.fields.dbFilePath,
-				dnsMasqConfig:     tt.fields.dnsMasqConfig,
-				skipDNSMasqReload: tt.fields.skipDNSMasqReload,
-				log:               tt.fields.log,
+				db:            tt.fields.db,
+				dnsBucket:     tt.fields.dnsBucket,
+				dbFilePath:    tt.fields.dbFilePath,
+				dnsMasqConfig: tt.fields.dnsMasqConfig,
+				reloader:      tt.fields.reloader,
+				log:           tt.fields.log,
 			}
-			if err := ds.DeleteByHost(tt.args.host); (err != nil) != tt.wantErr {
+			if err := ds.DeleteByHost(context.Background(), tt.args.host); (err != nil) != tt.wantErr {
 				t.Errorf("DeleteByHost() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -80,12 +89,12 @@ func TestDNSMasqService_DeleteByHost(t *testing.T) {
 
 func TestDNSMasqService_GetAllIPs(t *testing.T) {
 	type fields struct {
-		db                *bolt.DB
-		dnsBucket         []byte
-		dbFilePath        string
-		dnsMasqConfig     string
-		skipDNSMasqReload bool
-		log               *logrus.Logger
+		db            *bolt.DB
+		dnsBucket     []byte
+		dbFilePath    string
+		dnsMasqConfig string
+		reloader      Reloader
+		log           *logrus.Logger
 	}
 	tests := []struct {
 		name    string
@@ -98,12 +107,12 @@ func TestDNSMasqService_GetAllIPs(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := &DNSMasqService{
-				db:                tt.fields.db,
-				dnsBucket:         tt.fields.dnsBucket,
-				dbFilePath:        tt.fields.dbFilePath,
-				dnsMasqConfig:     tt.fields.dnsMasqConfig,
-				skipDNSMasqReload: tt.fields.skipDNSMasqReload,
-				log:               tt.fields.log,
+				db:            tt.fields.db,
+				dnsBucket:     tt.fields.dnsBucket,
+				dbFilePath:    tt.fields.dbFilePath,
+				dnsMasqConfig: tt.fields.dnsMasqConfig,
+				reloader:      tt.fields.reloader,
+				log:           tt.fields.log,
 			}
 			got, err := ds.GetAllIPs()
 			if (err != nil) != tt.wantErr {
@@ -119,12 +128,12 @@ func TestDNSMasqService_GetAllIPs(t *testing.T) {
 
 func TestDNSMasqService_GetIPByHost(t *testing.T) {
 	type fields struct {
-		db                *bolt.DB
-		dnsBucket         []byte
-		dbFilePath        string
-		dnsMasqConfig     string
-		skipDNSMasqReload bool
-		log               *logrus.Logger
+		db            *bolt.DB
+		dnsBucket     []byte
+		dbFilePath    string
+		dnsMasqConfig string
+		reloader      Reloader
+		log           *logrus.Logger
 	}
 	type args struct {
 		host string
@@ -141,12 +150,12 @@ func TestDNSMasqService_GetIPByHost(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := &DNSMasqService{
-				db:                tt.fields.db,
-				dnsBucket:         tt.fields.dnsBucket,
-				dbFilePath:        tt.fields.dbFilePath,
-				dnsMasqConfig:     tt.fields.dnsMasqConfig,
-				skipDNSMasqReload: tt.fields.skipDNSMasqReload,
-				log:               tt.fields.log,
+				db:            tt.fields.db,
+				dnsBucket:     tt.fields.dnsBucket,
+				dbFilePath:    tt.fields.dbFilePath,
+				dnsMasqConfig: tt.fields.dnsMasqConfig,
+				reloader:      tt.fields.reloader,
+				log:           tt.fields.log,
 			}
 			got, err := ds.GetIPByHost(tt.args.host)
 			if (err != nil) != tt.wantErr {
@@ -162,12 +171,12 @@ func TestDNSMasqService_GetIPByHost(t *testing.T) {
 
 func TestDNSMasqService_ReloadDNSMasq(t *testing.T) {
 	type fields struct {
-		db                *bolt.DB
-		dnsBucket         []byte
-		dbFilePath        string
-		dnsMasqConfig     string
-		skipDNSMasqReload bool
-		log               *logrus.Logger
+		db            *bolt.DB
+		dnsBucket     []byte
+		dbFilePath    string
+		dnsMasqConfig string
+		reloader      Reloader
+		log           *logrus.Logger
 	}
 	tests := []struct {
 		name    string
@@ -179,12 +188,12 @@ func TestDNSMasqService_ReloadDNSMasq(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := &DNSMasqService{
-				db:                tt.fields.db,
-				dnsBucket:         tt.fields.dnsBucket,
-				dbFilePath:        tt.fields.dbFilePath,
-				dnsMasqConfig:     tt.fields.dnsMasqConfig,
-				skipDNSMasqReload: tt.fields.skipDNSMasqReload,
-				log:               tt.fields.log,
+				db:            tt.fields.db,
+				dnsBucket:     tt.fields.dnsBucket,
+				dbFilePath:    tt.fields.dbFilePath,
+				dnsMasqConfig: tt.fields.dnsMasqConfig,
+				reloader:      tt.fields.reloader,
+				log:           tt.fields.log,
 			}
 			if err := ds.ReloadDNSMasq(); (err != nil) != tt.wantErr {
 				t.Errorf("ReloadDNSMasq() error = %v, wantErr %v", err, tt.wantErr)
@@ -195,12 +204,12 @@ func TestDNSMasqService_ReloadDNSMasq(t *testing.T) {
 
 func TestDNSMasqService_SetIPByHost(t *testing.T) {
 	type fields struct {
-		db                *bolt.DB
-		dnsBucket         []byte
-		dbFilePath        string
-		dnsMasqConfig     string
-		skipDNSMasqReload bool
-		log               *logrus.Logger
+		db            *bolt.DB
+		dnsBucket     []byte
+		dbFilePath    string
+		dnsMasqConfig string
+		reloader      Reloader
+		log           *logrus.Logger
 	}
 	type args struct {
 		hostname string
@@ -219,14 +228,14 @@ func TestDNSMasqService_SetIPByHost(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := &DNSMasqService{
-				db:                tt.fields.db,
-				dnsBucket:         tt.fields.dnsBucket,
-				dbFilePath:        tt.fields.dbFilePath,
-				dnsMasqConfig:     tt.fields.dnsMasqConfig,
-				skipDNSMasqReload: tt.fields.skipDNSMasqReload,
-				log:               tt.fields.log,
+				db:            tt.fields.db,
+				dnsBucket:     tt.fields.dnsBucket,
+				dbFilePath:    tt.fields.dbFilePath,
+				dnsMasqConfig: tt.fields.dnsMasqConfig,
+				reloader:      tt.fields.reloader,
+				log:           tt.fields.log,
 			}
-			got, err := ds.SetIPByHost(tt.args.hostname, tt.args.ips, tt.args.appendIP)
+			got, err := ds.SetIPByHost(context.Background(), tt.args.hostname, tt.args.ips, tt.args.appendIP)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("SetIPByHost() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -240,12 +249,12 @@ func TestDNSMasqService_SetIPByHost(t *testing.T) {
 
 func TestDNSMasqService_UpdateDNSMasq(t *testing.T) {
 	type fields struct {
-		db                *bolt.DB
-		dnsBucket         []byte
-		dbFilePath        string
-		dnsMasqConfig     string
-		skipDNSMasqReload bool
-		log               *logrus.Logger
+		db            *bolt.DB
+		dnsBucket     []byte
+		dbFilePath    string
+		dnsMasqConfig string
+		reloader      Reloader
+		log           *logrus.Logger
 	}
 	tests := []struct {
 		name    string
@@ -257,12 +266,12 @@ func TestDNSMasqService_UpdateDNSMasq(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := &DNSMasqService{
-				db:                tt.fields.db,
-				dnsBucket:         tt.fields.dnsBucket,
-				dbFilePath:        tt.fields.dbFilePath,
-				dnsMasqConfig:     tt.fields.dnsMasqConfig,
-				skipDNSMasqReload: tt.fields.skipDNSMasqReload,
-				log:               tt.fields.log,
+				db:            tt.fields.db,
+				dnsBucket:     tt.fields.dnsBucket,
+				dbFilePath:    tt.fields.dbFilePath,
+				dnsMasqConfig: tt.fields.dnsMasqConfig,
+				reloader:      tt.fields.reloader,
+				log:           tt.fields.log,
 			}
 			if err := ds.UpdateDNSMasq(); (err != nil) != tt.wantErr {
 				t.Errorf("UpdateDNSMasq() error = %v, wantErr %v", err, tt.wantErr)
@@ -273,12 +282,12 @@ func TestDNSMasqService_UpdateDNSMasq(t *testing.T) {
 
 func TestDNSMasqService_WriteDNSMasq(t *testing.T) {
 	type fields struct {
-		db                *bolt.DB
-		dnsBucket         []byte
-		dbFilePath        string
-		dnsMasqConfig     string
-		skipDNSMasqReload bool
-		log               *logrus.Logger
+		db            *bolt.DB
+		dnsBucket     []byte
+		dbFilePath    string
+		dnsMasqConfig string
+		reloader      Reloader
+		log           *logrus.Logger
 	}
 	tests := []struct {
 		name    string
@@ -290,12 +299,12 @@ func TestDNSMasqService_WriteDNSMasq(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := &DNSMasqService{
-				db:                tt.fields.db,
-				dnsBucket:         tt.fields.dnsBucket,
-				dbFilePath:        tt.fields.dbFilePath,
-				dnsMasqConfig:     tt.fields.dnsMasqConfig,
-				skipDNSMasqReload: tt.fields.skipDNSMasqReload,
-				log:               tt.fields.log,
+				db:            tt.fields.db,
+				dnsBucket:     tt.fields.dnsBucket,
+				dbFilePath:    tt.fields.dbFilePath,
+				dnsMasqConfig: tt.fields.dnsMasqConfig,
+				reloader:      tt.fields.reloader,
+				log:           tt.fields.log,
 			}
 			if err := ds.WriteDNSMasq(); (err != nil) != tt.wantErr {
 				t.Errorf("WriteDNSMasq() error = %v, wantErr %v", err, tt.wantErr)
@@ -306,12 +315,12 @@ func TestDNSMasqService_WriteDNSMasq(t *testing.T) {
 
 func TestDNSMasqService_openDB(t *testing.T) {
 	type fields struct {
-		db                *bolt.DB
-		dnsBucket         []byte
-		dbFilePath        string
-		dnsMasqConfig     string
-		skipDNSMasqReload bool
-		log               *logrus.Logger
+		db            *bolt.DB
+		dnsBucket     []byte
+		dbFilePath    string
+		dnsMasqConfig string
+		reloader      Reloader
+		log           *logrus.Logger
 	}
 	type args struct {
 		dbPath string
@@ -327,12 +336,12 @@ func TestDNSMasqService_openDB(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ds := &DNSMasqService{
-				db:                tt.fields.db,
-				dnsBucket:         tt.fields.dnsBucket,
-				dbFilePath:        tt.fields.dbFilePath,
-				dnsMasqConfig:     tt.fields.dnsMasqConfig,
-				skipDNSMasqReload: tt.fields.skipDNSMasqReload,
-				log:               tt.fields.log,
+				db:            tt.fields.db,
+				dnsBucket:     tt.fields.dnsBucket,
+				dbFilePath:    tt.fields.dbFilePath,
+				dnsMasqConfig: tt.fields.dnsMasqConfig,
+				reloader:      tt.fields.reloader,
+				log:           tt.fields.log,
 			}
 			if err := ds.openDB(tt.args.dbPath); (err != nil) != tt.wantErr {
 				t.Errorf("openDB() error = %v, wantErr %v", err, tt.wantErr)
@@ -437,7 +446,29 @@ func Test_removeDuplicates(t *testing.T) {
 		args args
 		want []model.DNSRecord
 	}{
-		// TODO: Add test cases.
+		{
+			name: "DuplicateIPs",
+			args: args{records: []model.DNSRecord{
+				{Hostname: "a.lan", Type: model.RecordTypeAddress, IP: "10.0.0.1"},
+				{Hostname: "a.lan", Type: model.RecordTypeAddress, IP: "10.0.0.1"},
+				{Hostname: "a.lan", Type: model.RecordTypeAddress, IP: "10.0.0.2"},
+			}},
+			want: []model.DNSRecord{
+				{Hostname: "a.lan", Type: model.RecordTypeAddress, IP: "10.0.0.1"},
+				{Hostname: "a.lan", Type: model.RecordTypeAddress, IP: "10.0.0.2"},
+			},
+		},
+		{
+			name: "SameValueDifferentTypes",
+			args: args{records: []model.DNSRecord{
+				{Hostname: "a.lan", Type: model.RecordTypeAddress, IP: "10.0.0.1"},
+				{Hostname: "a.lan", Type: model.RecordTypeHost, IP: "10.0.0.1"},
+			}},
+			want: []model.DNSRecord{
+				{Hostname: "a.lan", Type: model.RecordTypeAddress, IP: "10.0.0.1"},
+				{Hostname: "a.lan", Type: model.RecordTypeHost, IP: "10.0.0.1"},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -447,3 +478,327 @@ func Test_removeDuplicates(t *testing.T) {
 		})
 	}
 }
+
+// testLogger creates a logger that discards its output
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return logger
+}
+
+// newTestDNSMasqService creates a DNSMasqService backed by a temp DB and a dnsmasq config with the given content
+func newTestDNSMasqService(t *testing.T, configData string) *DNSMasqService {
+	t.Helper()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "api.conf")
+	require.NoError(t, os.WriteFile(configPath, []byte(configData), dnsFileMode))
+
+	config := model.Config{DnsmasqConfig: configPath, DnsmasqBackups: 2, SkipDNSMasqReload: true}
+	ds, err := NewDNSMasqService(config, WithDBFilePath(filepath.Join(dir, "dns.db")), WithLogger(testLogger()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ds.(*DNSMasqService).db.Close() })
+
+	return ds.(*DNSMasqService)
+}
+
+func TestDNSMasqService_BuildDatabaseDrift(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\n")
+
+	// Writing bumps the generation in both the file and the DB
+	_, err := ds.SetIPByHost(context.Background(), "b.lan", []string{"10.0.0.2"}, false)
+	require.NoError(t, err)
+	require.NoError(t, ds.WriteDNSMasq())
+	data, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	assert.Equal(t, dnsConfigHeader+"# Generation: 1\naddress=/a.lan/10.0.0.1\naddress=/b.lan/10.0.0.2\n",
+		withoutMetadata(string(data)))
+	gen, err := ds.getGeneration()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), gen)
+
+	// In sync, so nothing changes
+	require.NoError(t, ds.BuildDatabase())
+	records, err := ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	// A hand edit with the same generation wins
+	require.NoError(t, os.WriteFile(ds.dnsMasqConfig, append(data, "address=/c.lan/10.0.0.3\n"...), dnsFileMode))
+	require.NoError(t, ds.BuildDatabase())
+	records, err = ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+
+	// A stale file from an older generation is rewritten from the database
+	require.NoError(t, ds.WriteDNSMasq())
+	require.NoError(t, os.WriteFile(ds.dnsMasqConfig, data, dnsFileMode))
+	require.NoError(t, ds.BuildDatabase())
+	records, err = ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+	data, err = os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "address=/c.lan/10.0.0.3\n")
+	assert.FileExists(t, util.BackupPath(ds.dnsMasqConfig, 1))
+}
+
+func TestDNSMasqService_ApplyBulk(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\naddress=/b.lan/10.0.0.2\n")
+
+	results, err := ds.ApplyBulk(context.Background(), []model.DNSBulkOperation{
+		{Op: model.BulkOpUpsert, Hostname: "c.lan", IPs: []string{"10.0.0.3"}},
+		{Op: model.BulkOpAppend, Hostname: "a.lan", Wildcard: true, IPs: []string{"10.0.0.11"}},
+		{Op: model.BulkOpDelete, Hostname: "b.lan"},
+		{Op: model.BulkOpUpsert, Hostname: "www.lan", Type: model.RecordTypeCNAME,
+			Records: []model.DNSRecord{{Target: "c.lan"}}},
+		{Op: model.BulkOpAppend, Hostname: "c.lan", IPs: []string{"10.0.0.13"}},
+		{Op: model.BulkOpRemove, Hostname: "c.lan", IPs: []string{"10.0.0.3"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 6)
+	assert.Len(t, results[1].Records, 2)
+	assert.Empty(t, results[2].Records)
+	require.Len(t, results[5].Records, 1)
+	assert.Equal(t, "10.0.0.13", results[5].Records[0].IP)
+
+	records, err := ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Len(t, records, 4)
+	_, err = ds.GetIPByHost("b.lan")
+	assert.EqualError(t, err, ErrorNoIPForHost)
+
+	// One bad operation fails them all
+	results, err = ds.ApplyBulk(context.Background(), []model.DNSBulkOperation{
+		{Op: model.BulkOpDelete, Hostname: "a.lan"},
+		{Op: model.BulkOpUpsert, Hostname: "d.lan", IPs: []string{"not-an-ip"}},
+		{Op: "rename", Hostname: "c.lan"},
+		{Op: model.BulkOpUpsert, Hostname: "e.lan"},
+		{Op: model.BulkOpRemove, Hostname: "c.lan", IPs: []string{"10.0.0.99"}},
+	})
+	assert.EqualError(t, err, ErrorBulkFailed)
+	require.Len(t, results, 5)
+	assert.Empty(t, results[0].Error)
+	assert.NotEmpty(t, results[1].Error)
+	assert.Equal(t, "unknown operation 'rename'", results[2].Error)
+	assert.Equal(t, "record list is required", results[3].Error)
+	assert.Equal(t, ErrorIPNotFound, results[4].Error)
+
+	after, err := ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Equal(t, records, after)
+}
+
+func TestDNSMasqService_RecordMetadata(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\n")
+
+	meta := model.RecordMetadata{Owner: "alice", Description: "the nas", Tags: []string{"storage"}}
+	records, err := ds.SetRecordsByHost(context.Background(), "nas.lan", model.RecordTypeHost,
+		[]model.DNSRecord{{IP: "10.0.0.5", TTL: 300, RecordMetadata: meta}}, false)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "alice", records[0].Owner)
+	require.NotNil(t, records[0].CreatedAt)
+	require.NotNil(t, records[0].UpdatedAt)
+	created := *records[0].CreatedAt
+
+	// Replacing a record keeps when it was created, appending one stamps it fresh
+	records, err = ds.SetRecordsByHost(context.Background(), "nas.lan", model.RecordTypeHost,
+		[]model.DNSRecord{{IP: "10.0.0.5", RecordMetadata: model.RecordMetadata{Owner: "bob"}}, {IP: "10.0.0.6"}}, true)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "bob", records[0].Owner)
+	assert.Equal(t, created, *records[0].CreatedAt)
+	assert.False(t, records[1].CreatedAt.Before(created))
+
+	// Filters
+	records, err = ds.FindRecords(model.DNSRecordFilter{Owner: "bob"})
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	records, err = ds.FindRecords(model.DNSRecordFilter{Tag: "storage"})
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	_, err = ds.SetRecordsByHost(context.Background(), "nas.lan", model.RecordTypeHost,
+		[]model.DNSRecord{{IP: "10.0.0.5", TTL: 300, RecordMetadata: meta}}, false)
+	require.NoError(t, err)
+	records, err = ds.FindRecords(model.DNSRecordFilter{Type: model.RecordTypeHost, Tag: "storage"})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	want := records[0]
+
+	// The metadata and TTL survive a round trip through the config file
+	require.NoError(t, ds.WriteDNSMasq())
+	data, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "host-record=nas.lan,10.0.0.5,300\n")
+	require.NoError(t, os.WriteFile(ds.dnsMasqConfig, append(data, "address=/c.lan/10.0.0.3\n"...), dnsFileMode))
+	require.NoError(t, ds.BuildDatabase())
+	records, err = ds.GetRecordsByHost("nas.lan", model.RecordTypeHost)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, want.RecordMetadata.Owner, records[0].Owner)
+	assert.Equal(t, want.Tags, records[0].Tags)
+	assert.Equal(t, uint32(300), records[0].TTL)
+	assert.True(t, want.CreatedAt.Equal(*records[0].CreatedAt))
+}
+
+func TestDNSMasqService_AppendReplaceRemove(t *testing.T) {
+	ds := newTestDNSMasqService(t, "")
+	ctx := context.Background()
+
+	records, err := ds.AppendRecordsByHost(ctx, "nas.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "10.0.0.5"}})
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	records, err = ds.AppendRecordsByHost(ctx, "nas.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "10.0.0.6"}, {IP: "fd00::6"}})
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+	_, err = ds.AppendRecordsByHost(ctx, "nas.lan", model.RecordTypeCNAME, []model.DNSRecord{{Target: "files.lan"}})
+	require.NoError(t, err)
+
+	// Removing an IP leaves the other records, and the IP may be written any way
+	records, err = ds.RemoveIPByHost(ctx, "nas.lan", "", "fd00:0::6")
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+	_, err = ds.RemoveIPByHost(ctx, "nas.lan", "", "fd00::6")
+	assert.EqualError(t, err, ErrorIPNotFound)
+	_, err = ds.RemoveIPByHost(ctx, "nas.lan", model.RecordTypeHost, "10.0.0.5")
+	assert.EqualError(t, err, ErrorIPNotFound)
+	_, err = ds.RemoveIPByHost(ctx, "missing.lan", "", "10.0.0.5")
+	assert.EqualError(t, err, ErrorNoIPForHost)
+
+	// Replacing only touches the records of its type
+	records, err = ds.ReplaceRecordsByHost(ctx, "nas.lan", model.RecordTypeAddress, []model.DNSRecord{{IP: "10.0.0.7"}})
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	records, err = ds.GetIPByHost("nas.lan")
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	// The hostname goes with its last record
+	records, err = ds.RemoveIPByHost(ctx, "nas.lan", "", "10.0.0.7")
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	require.NoError(t, ds.DeleteRecordsByHost(ctx, "nas.lan", model.RecordTypeCNAME))
+	_, err = ds.AppendRecordsByHost(ctx, "nas.lan", model.RecordTypeAddress, []model.DNSRecord{{IP: "10.0.0.8"}})
+	require.NoError(t, err)
+	records, err = ds.RemoveIPByHost(ctx, "nas.lan", "", "10.0.0.8")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	_, err = ds.GetIPByHost("nas.lan")
+	assert.EqualError(t, err, ErrorNoIPForHost)
+
+	history, err := ds.GetHostHistory("nas.lan")
+	require.NoError(t, err)
+	assert.Equal(t, model.AuditOpRemove, history[len(history)-1].Operation)
+}
+
+func TestDNSMasqService_ConcurrentWriters(t *testing.T) {
+	ds := newTestDNSMasqService(t, "")
+	ctx := context.Background()
+	const writers = 20
+
+	// Appends read and write the records in one transaction, so none are lost
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*2)
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ds.SetIPByHost(ctx, "pool.lan", []string{fmt.Sprintf("10.0.1.%d", i)}, true)
+			errs <- err
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ds.ReplaceRecordsByHost(ctx, "single.lan", model.RecordTypeAddress,
+				[]model.DNSRecord{{IP: fmt.Sprintf("10.0.2.%d", i)}})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	records, err := ds.GetIPByHost("pool.lan")
+	require.NoError(t, err)
+	assert.Len(t, records, writers)
+	rev, err := ds.GetHostRevision("pool.lan")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(writers), rev)
+	records, err = ds.GetIPByHost("single.lan")
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	// Removals race appends to a hostname, and the last removal deletes it
+	errs = make(chan error, writers*2)
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ds.RemoveIPByHost(ctx, "pool.lan", "", fmt.Sprintf("10.0.1.%d", i))
+			errs <- err
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ds.AppendRecordsByHost(ctx, "other.lan", model.RecordTypeAddress,
+				[]model.DNSRecord{{IP: fmt.Sprintf("10.0.3.%d", i)}})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	_, err = ds.GetIPByHost("pool.lan")
+	assert.EqualError(t, err, ErrorNoIPForHost)
+	records, err = ds.GetIPByHost("other.lan")
+	require.NoError(t, err)
+	assert.Len(t, records, writers)
+	page, err := ds.GetAuditLog(model.AuditQuery{Hostname: "pool.lan", Limit: maxAuditLimit})
+	require.NoError(t, err)
+	assert.Len(t, page.Entries, writers*2)
+}
+
+func TestDNSMasqService_PatchRecordsByHost(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/pool.lan/10.0.0.1\naddress=/pool.lan/10.0.0.2\n")
+	ctx := context.Background()
+
+	records, err := ds.PatchRecordsByHost(ctx, "pool.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "10.0.0.3"}, {IP: "10.0.0.1"}}, []string{"10.0.0.1", "10.0.0.2"})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "10.0.0.3", records[0].IP)
+	assert.Equal(t, "10.0.0.1", records[1].IP)
+
+	// A missing IP fails the whole patch
+	_, err = ds.PatchRecordsByHost(ctx, "pool.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "10.0.0.4"}}, []string{"10.0.0.9"})
+	assert.EqualError(t, err, ErrorIPNotFound)
+	_, err = ds.PatchRecordsByHost(ctx, "pool.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "not-an-ip"}}, nil)
+	assert.Error(t, err)
+	records, err = ds.GetIPByHost("pool.lan")
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	// Removing the last IPs removes the hostname
+	records, err = ds.RemoveIPsByHost(ctx, "pool.lan", "", []string{"10.0.0.1", "10.0.0.3"})
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	_, err = ds.GetIPByHost("pool.lan")
+	assert.EqualError(t, err, ErrorNoIPForHost)
+
+	// Adding to a missing hostname creates it
+	records, err = ds.PatchRecordsByHost(ctx, "new.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "10.0.0.5"}}, nil)
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	history, err := ds.GetHostHistory("new.lan")
+	require.NoError(t, err)
+	assert.Equal(t, model.AuditOpPatch, history[0].Operation)
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/cclose/dnsmasq-api/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	MetricRecordsExpired  = "dnsmasq_records_expired_total"
+	MetricRecordsExpiring = "dnsmasq_records_expiring"
+
+	ErrorNothingToRenew = "no expiring records to renew for host"
+)
+
+// RenewRecordsByHost extends the expiry of the records for the given hostname, returning all of its records. With an
+// ExpiresAt or TTLSeconds in the request every record gets the new expiry, otherwise only the records with their own
+// TTLSeconds are extended by it. The renewal must meet the precondition in ctx, and is audited under its actor
+func (ds *DNSMasqService) RenewRecordsByHost(ctx context.Context, hostname string,
+	req model.RenewDNSRecordRequest) ([]model.DNSRecord, error) {
+	var records []model.DNSRecord
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := ds.dnsBucketTx(tx)
+		if err != nil {
+			return err
+		}
+
+		return ds.changeHostTx(ctx, tx, model.AuditOpRenew, hostname, func() error {
+			data := bucket.Get([]byte(hostname))
+			if data == nil {
+				return fmt.Errorf("%s", ErrorNoIPForHost)
+			}
+			if records, err = decodeRecords(data); err != nil {
+				return err
+			}
+
+			now := time.Now().UTC()
+			renewed := 0
+			for i, record := range records {
+				ttl := record.TTLSeconds
+				if req.TTLSeconds > 0 {
+					ttl = req.TTLSeconds
+				}
+				switch {
+				case req.ExpiresAt != nil && req.TTLSeconds == 0:
+					records[i].ExpiresAt = req.ExpiresAt
+					records[i].TTLSeconds = 0
+				case ttl > 0:
+					expiresAt := now.Add(time.Duration(ttl) * time.Second)
+					records[i].ExpiresAt = &expiresAt
+					records[i].TTLSeconds = ttl
+				default:
+					continue
+				}
+				records[i].UpdatedAt = &now
+				renewed += 1
+			}
+			if renewed == 0 {
+				return fmt.Errorf("%s", ErrorNothingToRenew)
+			}
+
+			newData, err := json.Marshal(records)
+			if err != nil {
+				return err
+			}
+
+			return bucket.Put([]byte(hostname), newData)
+		})
+	})
+
+	return records, err
+}
+
+// ReapExpired removes every expired record from the database, returning how many were removed. If any were, a
+// single config update is run for all of them, waiting out the debounce window if one is set. Removals are audited
+// as expiries by the reaper
+func (ds *DNSMasqService) ReapExpired() (int, error) {
+	now := time.Now()
+	expired := 0
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := ds.dnsBucketTx(tx)
+		if err != nil {
+			return err
+		}
+
+		// The bucket can't be changed while iterating it, so collect each changed host's records first
+		previous := make(map[string][]model.DNSRecord)
+		remaining := make(map[string][]model.DNSRecord)
+		err = bucket.ForEach(func(k, v []byte) error {
+			hostRecords, err := decodeRecords(v)
+			if err != nil {
+				return err
+			}
+			var kept []model.DNSRecord
+			for _, record := range hostRecords {
+				if record.Expired(now) {
+					ds.log.Infof("Record %s expired at %s", renderRecord(record), record.ExpiresAt.Format(time.RFC3339))
+					continue
+				}
+				kept = append(kept, record)
+			}
+			if len(kept) != len(hostRecords) {
+				expired += len(hostRecords) - len(kept)
+				previous[string(k)] = hostRecords
+				remaining[string(k)] = kept
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(remaining) > 0 {
+			if err = ds.checkDriftTx(tx); err != nil {
+				return err
+			}
+		}
+		ctx := systemContext(auditActorReaper)
+		for hostname, records := range remaining {
+			if err = ds.putHostRecordsTx(tx, hostname, records); err != nil {
+				return err
+			}
+			if err = ds.recordChangeTx(ctx, tx, model.AuditOpExpire, hostname, previous[hostname]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil || expired == 0 {
+		return 0, err
+	}
+
+	metrics.GetOrCreateCounter(MetricRecordsExpired).Add(expired)
+
+	return expired, <-ds.ScheduleUpdate()
+}
+
+// StartReaper Reaps expired records every interval until the returned stop function is called. A zero interval does
+// not start the reaper
+func (ds *DNSMasqService) StartReaper(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := ds.ReapExpired(); err != nil {
+					ds.log.Errorf("Failed to reap expired records: %v", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSMasqService_ReapExpired(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\n")
+
+	past := time.Now().Add(-time.Minute)
+	_, err := ds.SetRecordsByHost(context.Background(), "ci-1.lan", model.RecordTypeAddress, []model.DNSRecord{
+		{IP: "10.0.1.1", RecordMetadata: model.RecordMetadata{ExpiresAt: &past}},
+		{IP: "10.0.1.2", RecordMetadata: model.RecordMetadata{TTLSeconds: 3600}},
+	}, false)
+	require.NoError(t, err)
+	_, err = ds.SetRecordsByHost(context.Background(), "ci-2.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "10.0.2.1", RecordMetadata: model.RecordMetadata{ExpiresAt: &past}}}, false)
+	require.NoError(t, err)
+	require.NoError(t, ds.UpdateDNSMasq())
+
+	expired, err := ds.ReapExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 2, expired)
+	records, err := ds.GetIPByHost("ci-1.lan")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "10.0.1.2", records[0].IP)
+	_, err = ds.GetIPByHost("ci-2.lan")
+	assert.EqualError(t, err, ErrorNoIPForHost)
+
+	// The config is rewritten without the expired records
+	data, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	assert.Equal(t, dnsConfigHeader+"# Generation: 2\naddress=/a.lan/10.0.0.1\naddress=/ci-1.lan/10.0.1.2\n",
+		withoutMetadata(string(data)))
+
+	// Nothing left to reap
+	expired, err = ds.ReapExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired)
+}
+
+func TestDNSMasqService_RenewRecordsByHost(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\n")
+
+	records, err := ds.SetRecordsByHost(context.Background(), "ci.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "10.0.1.1", RecordMetadata: model.RecordMetadata{TTLSeconds: 60}}}, false)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.NotNil(t, records[0].ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *records[0].ExpiresAt, 5*time.Second)
+
+	// Renewing without a body extends by the record's own TTL, a TTL in the request replaces it
+	records, err = ds.RenewRecordsByHost(context.Background(), "ci.lan", model.RenewDNSRecordRequest{})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *records[0].ExpiresAt, 5*time.Second)
+	records, err = ds.RenewRecordsByHost(context.Background(), "ci.lan", model.RenewDNSRecordRequest{TTLSeconds: 3600})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *records[0].ExpiresAt, 5*time.Second)
+	assert.Equal(t, uint32(3600), records[0].TTLSeconds)
+
+	// Records that never expire have nothing to renew unless given an expiry
+	_, err = ds.RenewRecordsByHost(context.Background(), "a.lan", model.RenewDNSRecordRequest{})
+	assert.EqualError(t, err, ErrorNothingToRenew)
+	expiresAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	records, err = ds.RenewRecordsByHost(context.Background(), "a.lan", model.RenewDNSRecordRequest{ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+	assert.Equal(t, expiresAt, *records[0].ExpiresAt)
+
+	_, err = ds.RenewRecordsByHost(context.Background(), "missing.lan", model.RenewDNSRecordRequest{})
+	assert.EqualError(t, err, ErrorNoIPForHost)
+}
+
+func TestDNSMasqService_StartReaper(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\n")
+	past := time.Now().Add(-time.Minute)
+	_, err := ds.SetRecordsByHost(context.Background(), "ci.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "10.0.1.1", RecordMetadata: model.RecordMetadata{ExpiresAt: &past}}}, false)
+	require.NoError(t, err)
+
+	stop := ds.StartReaper(10 * time.Millisecond)
+	defer stop()
+	assert.Eventually(t, func() bool {
+		_, err := ds.GetIPByHost("ci.lan")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestDNSMasqService_MetadataOnlyChange(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "api.conf")
+	require.NoError(t, os.WriteFile(configPath, nil, dnsFileMode))
+	reloader := &countingReloader{}
+	svc, err := NewDNSMasqService(model.Config{DnsmasqConfig: configPath},
+		WithDBFilePath(filepath.Join(dir, "dns.db")), WithLogger(testLogger()), WithReloader(reloader))
+	require.NoError(t, err)
+	ds := svc.(*DNSMasqService)
+	t.Cleanup(func() { _ = ds.db.Close() })
+
+	_, err = ds.SetRecordsByHost(context.Background(), "ci.lan", model.RecordTypeAddress,
+		[]model.DNSRecord{{IP: "10.0.1.1", RecordMetadata: model.RecordMetadata{TTLSeconds: 60}}}, false)
+	require.NoError(t, err)
+	require.NoError(t, ds.UpdateDNSMasq())
+	assert.Equal(t, 1, reloader.calls)
+
+	// A renewal is written to the config, but dnsmasq has nothing to reload
+	before, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	_, err = ds.RenewRecordsByHost(context.Background(), "ci.lan", model.RenewDNSRecordRequest{TTLSeconds: 120})
+	require.NoError(t, err)
+	require.NoError(t, ds.UpdateDNSMasq())
+	after, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.NotEqual(t, string(before), string(after))
+	assert.Equal(t, 1, reloader.calls)
+}
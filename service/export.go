@@ -0,0 +1,110 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"gopkg.in/yaml.v3"
+)
+
+// exportHeader starts the hosts file and dnsmasq config exports
+const exportHeader = "# Exported by DNSMasq API\n"
+
+// RenderExport renders records in an export format. Only host records can be written to a hosts file, so the other
+// records are left out of a hosts export. The other formats hold every record
+func RenderExport(format string, records []model.DNSRecord) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case model.ExportFormatHosts:
+		var b strings.Builder
+		b.WriteString(exportHeader)
+		writeRecords(&b, filterRecords(records, model.RecordTypeHost), renderHostsLine)
+		return []byte(b.String()), nil
+	case model.ExportFormatDNSMasq:
+		var b strings.Builder
+		b.WriteString(exportHeader)
+		writeRecords(&b, records, renderRecord)
+		return []byte(b.String()), nil
+	case model.ExportFormatZone:
+		return []byte(renderZone(records)), nil
+	case model.ExportFormatCSV:
+		return renderCSV(records)
+	case model.ExportFormatYAML:
+		if records == nil {
+			records = []model.DNSRecord{}
+		}
+		return yaml.Marshal(records)
+	case model.ExportFormatJSON:
+		if records == nil {
+			records = []model.DNSRecord{}
+		}
+		data, err := json.MarshalIndent(records, "", "  ")
+		return append(data, '\n'), err
+	}
+
+	return nil, fmt.Errorf("unknown export format '%s', expected one of %s", format,
+		strings.Join(model.ExportFormats, ", "))
+}
+
+// renderCSV renders records as a CSV file with a header row of the csvColumns, as read by a CSV import
+func renderCSV(records []model.DNSRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(csvColumns); err != nil {
+		return nil, err
+	}
+
+	number := func(v uint64) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.FormatUint(v, 10)
+	}
+	for _, record := range records {
+		fields := map[string]string{
+			"hostname":    record.Hostname,
+			"type":        string(record.RecordType()),
+			"wildcard":    strconv.FormatBool(record.IsWildcard()),
+			"ip":          record.IP,
+			"target":      record.Target,
+			"text":        record.Text,
+			"port":        number(uint64(record.Port)),
+			"priority":    number(uint64(record.Priority)),
+			"weight":      number(uint64(record.Weight)),
+			"ttl":         number(uint64(record.TTL)),
+			"owner":       record.Owner,
+			"description": record.Description,
+			"tags":        strings.Join(record.Tags, ";"),
+		}
+		row := make([]string, len(csvColumns))
+		for i, column := range csvColumns {
+			row[i] = fields[column]
+		}
+		if err := writer.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+
+	return buf.Bytes(), writer.Error()
+}
+
+// ReadRecords reads the records matching the filter straight from the DB file described by the DatabaseConfig,
+// without a DNSMasqService, so nothing is written to the DB or the dnsmasq config. The file is opened read only, but
+// a running server holds it locked
+func ReadRecords(dbConfig model.DatabaseConfig, filter model.DNSRecordFilter) ([]model.DNSRecord, error) {
+	db, err := openDBFile(dbConfig.FilePath, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ds := &DNSMasqService{db: db, dnsBucket: []byte(defaultDBBucketName)}
+	WithConfig(dbConfig)(ds)
+
+	return ds.FindRecords(filter)
+}
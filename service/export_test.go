@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportRecords One record of each type, for the export tests
+var exportRecords = []model.DNSRecord{
+	{Hostname: "lan", Type: model.RecordTypeAddress, IP: "10.0.0.254",
+		RecordMetadata: model.RecordMetadata{Owner: "ops"}},
+	{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5", TTL: 300,
+		RecordMetadata: model.RecordMetadata{Description: "the NAS", Tags: []string{"home", "storage"}}},
+	{Hostname: "www.lan", Type: model.RecordTypeCNAME, Target: "nas.lan"},
+	{Hostname: "lan", Type: model.RecordTypeMX, Target: "mail.lan", Priority: 10},
+	{Hostname: "_ldap._tcp.lan", Type: model.RecordTypeSRV, Target: "nas.lan", Port: 389, Weight: 5},
+	{Hostname: "info.lan", Type: model.RecordTypeTXT, Text: "hello world"},
+	{Hostname: "5.0.0.10.in-addr.arpa", Type: model.RecordTypePTR, Target: "nas.lan"},
+}
+
+func TestRenderExport(t *testing.T) {
+	withoutMeta := make([]model.DNSRecord, len(exportRecords))
+	for i, record := range exportRecords {
+		record.RecordMetadata = model.RecordMetadata{}
+		withoutMeta[i] = record
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		want   string
+		// reimported is what importing the export again gives, nil if the format can't be imported
+		reimported []model.DNSRecord
+	}{
+		{
+			name:   "Hosts",
+			format: model.ExportFormatHosts,
+			want: exportHeader + "# meta: {\"description\":\"the NAS\",\"tags\":[\"home\",\"storage\"]}\n" +
+				"10.0.0.5\tnas.lan\n",
+			reimported: []model.DNSRecord{{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5",
+				RecordMetadata: exportRecords[1].RecordMetadata}},
+		},
+		{
+			name:       "DNSMasq",
+			format:     model.ExportFormatDNSMasq,
+			reimported: exportRecords,
+		},
+		{
+			name:   "Zone",
+			format: model.ExportFormatZone,
+			want: zoneExportHeader +
+				"*.lan.\tIN\tA\t10.0.0.254\n" +
+				"lan.\tIN\tA\t10.0.0.254\n" +
+				"nas.lan.\t300\tIN\tA\t10.0.0.5\n" +
+				"www.lan.\tIN\tCNAME\tnas.lan.\n" +
+				"lan.\tIN\tMX\t10 mail.lan.\n" +
+				"_ldap._tcp.lan.\tIN\tSRV\t0 5 389 nas.lan.\n" +
+				"info.lan.\tIN\tTXT\t\"hello world\"\n" +
+				"5.0.0.10.in-addr.arpa.\tIN\tPTR\tnas.lan.\n",
+			reimported: withoutMeta,
+		},
+		{
+			name:   "CSV",
+			format: model.ExportFormatCSV,
+			want: "hostname,type,wildcard,ip,target,text,port,priority,weight,ttl,owner,description,tags\n" +
+				"lan,address,true,10.0.0.254,,,,,,,ops,,\n" +
+				"nas.lan,host,false,10.0.0.5,,,,,,300,,the NAS,home;storage\n" +
+				"www.lan,cname,false,,nas.lan,,,,,,,,\n" +
+				"lan,mx,false,,mail.lan,,,10,,,,,\n" +
+				"_ldap._tcp.lan,srv,false,,nas.lan,,389,,5,,,,\n" +
+				"info.lan,txt,false,,,hello world,,,,,,,\n" +
+				"5.0.0.10.in-addr.arpa,ptr,false,,nas.lan,,,,,,,,\n",
+			reimported: exportRecords,
+		},
+		{
+			name:       "JSON",
+			format:     model.ExportFormatJSON,
+			reimported: exportRecords,
+		},
+		{
+			name:   "YAML",
+			format: "YAML",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := RenderExport(tt.format, exportRecords)
+			require.NoError(t, err)
+			if tt.want != "" {
+				assert.Equal(t, tt.want, string(data))
+			}
+			if tt.reimported == nil {
+				return
+			}
+
+			records, issues, err := ParseImport(tt.format, "", data)
+			require.NoError(t, err)
+			assert.Empty(t, issues)
+			assert.Equal(t, tt.reimported, records)
+		})
+	}
+
+	data, err := RenderExport(model.ExportFormatJSON, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "[]\n", string(data))
+
+	_, err = RenderExport("ldif", exportRecords)
+	assert.EqualError(t, err, "unknown export format 'ldif', expected one of hosts, dnsmasq, zone, csv, yaml, json")
+}
+
+func TestReadRecords(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/lan/10.0.0.254\n")
+	_, err := ds.SetRecordsByHost(context.Background(), "nas.lan", model.RecordTypeHost, []model.DNSRecord{
+		{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5"},
+	}, false)
+	require.NoError(t, err)
+	dbPath := ds.db.Path()
+	require.NoError(t, ds.db.Close())
+
+	dbConfig := model.DatabaseConfig{FilePath: dbPath}
+	records, err := ReadRecords(dbConfig, model.DNSRecordFilter{Type: model.RecordTypeHost})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "nas.lan", records[0].Hostname)
+
+	records, err = ReadRecords(dbConfig, model.DNSRecordFilter{})
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	_, err = ReadRecords(model.DatabaseConfig{FilePath: filepath.Join(t.TempDir(), "missing", "dns.db")},
+		model.DNSRecordFilter{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cclose/dnsmasq-api/constant/key"
+	"github.com/cclose/dnsmasq-api/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultHistoryBucketName  = "history"
+	defaultSnapshotBucketName = "snapshots"
+
+	ErrorNoHistory  = "no history found for host"
+	ErrorNoRevision = "revision not found for host"
+	ErrorNoSnapshot = "snapshot not found"
+
+	ErrorPreconditionFailed = "hostname does not match the precondition"
+)
+
+// putHostRecordsTx stores the records of a hostname within a transaction, deleting the hostname if there are none
+func (ds *DNSMasqService) putHostRecordsTx(tx *bolt.Tx, hostname string, records []model.DNSRecord) error {
+	bucket, err := ds.dnsBucketTx(tx)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return bucket.Delete([]byte(hostname))
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(hostname), data)
+}
+
+// sameRecords reports whether two lists of records are identical, metadata included
+func sameRecords(a, b []model.DNSRecord) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	aData, aErr := json.Marshal(a)
+	bData, bErr := json.Marshal(b)
+
+	return aErr == nil && bErr == nil && bytes.Equal(aData, bData)
+}
+
+// hostRevisionTx returns the current revision of a hostname within a transaction, zero if it was never changed
+func (ds *DNSMasqService) hostRevisionTx(tx *bolt.Tx, hostname string) uint64 {
+	hostBucket := tx.Bucket(ds.historyBucket).Bucket([]byte(hostname))
+	if hostBucket == nil {
+		return 0
+	}
+
+	return hostBucket.Sequence()
+}
+
+// checkPreconditionTx checks the precondition in ctx, if any, against the current revision of a hostname within a
+// transaction. exists is whether the hostname has records
+func (ds *DNSMasqService) checkPreconditionTx(ctx context.Context, tx *bolt.Tx, hostname string, exists bool) error {
+	precondition, ok := ctx.Value(key.ContextPrecondition).(model.Precondition)
+	if !ok {
+		return nil
+	}
+
+	etag := ""
+	if exists {
+		etag = model.RevisionETag(ds.hostRevisionTx(tx, hostname))
+	}
+	if !precondition.Met(etag) {
+		return fmt.Errorf("%s", ErrorPreconditionFailed)
+	}
+
+	return nil
+}
+
+// GetHostRevision retrieves the current revision of the given hostname, zero if it was never changed
+func (ds *DNSMasqService) GetHostRevision(hostname string) (uint64, error) {
+	var rev uint64
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		rev = ds.hostRevisionTx(tx, hostname)
+		return nil
+	})
+
+	return rev, err
+}
+
+// recordRevisionTx adds the records after an audited change as the next revision of the hostname, dropping the
+// oldest revisions past the configured maximum
+func (ds *DNSMasqService) recordRevisionTx(tx *bolt.Tx, entry model.AuditEntry) error {
+	hostBucket, err := tx.Bucket(ds.historyBucket).CreateBucketIfNotExists([]byte(entry.Hostname))
+	if err != nil {
+		return err
+	}
+	rev, err := hostBucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(model.HostRevision{
+		Revision:  rev,
+		Timestamp: entry.Timestamp,
+		Actor:     entry.Actor,
+		Operation: entry.Operation,
+		Records:   entry.After,
+	})
+	if err != nil {
+		return err
+	}
+	if err = hostBucket.Put(sequenceKey(rev), data); err != nil {
+		return err
+	}
+
+	if ds.maxRevisions <= 0 || rev <= uint64(ds.maxRevisions) {
+		return nil
+	}
+	oldest := sequenceKey(rev - uint64(ds.maxRevisions) + 1)
+	var expired [][]byte
+	c := hostBucket.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k, oldest) < 0; k, _ = c.Next() {
+		expired = append(expired, append([]byte(nil), k...))
+	}
+	for _, k := range expired {
+		if err = hostBucket.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetHostHistory retrieves the revisions kept for the given hostname, oldest first
+func (ds *DNSMasqService) GetHostHistory(hostname string) ([]model.HostRevision, error) {
+	var revisions []model.HostRevision
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		hostBucket := tx.Bucket(ds.historyBucket).Bucket([]byte(hostname))
+		if hostBucket == nil {
+			return fmt.Errorf("%s", ErrorNoHistory)
+		}
+
+		return hostBucket.ForEach(func(k, v []byte) error {
+			var revision model.HostRevision
+			if err := json.Unmarshal(v, &revision); err != nil {
+				return err
+			}
+			revisions = append(revisions, revision)
+			return nil
+		})
+	})
+
+	return revisions, err
+}
+
+// RevertHost sets the records of the given hostname back to those of an earlier revision, returning them. The records
+// are validated as any other change is, so a revision the API would now reject can't be brought back. The revert is
+// itself a new revision, audited under the actor in ctx
+func (ds *DNSMasqService) RevertHost(ctx context.Context, hostname string, rev uint64) ([]model.DNSRecord, error) {
+	var records []model.DNSRecord
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		hostBucket := tx.Bucket(ds.historyBucket).Bucket([]byte(hostname))
+		if hostBucket == nil {
+			return fmt.Errorf("%s", ErrorNoHistory)
+		}
+		data := hostBucket.Get(sequenceKey(rev))
+		if data == nil {
+			return fmt.Errorf("%s", ErrorNoRevision)
+		}
+		var revision model.HostRevision
+		if err := json.Unmarshal(data, &revision); err != nil {
+			return err
+		}
+		records = revision.Records
+		if err := ds.validator.Check(records); err != nil {
+			return err
+		}
+		if err := ds.checkDriftTx(tx); err != nil {
+			return err
+		}
+
+		before, err := ds.hostRecordsTx(tx, hostname)
+		if err != nil {
+			return err
+		}
+		if err = ds.putHostRecordsTx(tx, hostname, records); err != nil {
+			return err
+		}
+		if err = ds.checkShadowingTx(tx, hostname); err != nil {
+			return err
+		}
+		return ds.recordChangeTx(ctx, tx, model.AuditOpRevert, hostname, before)
+	})
+
+	return records, err
+}
+
+// allHostRecordsTx returns the records of every hostname within a transaction
+func (ds *DNSMasqService) allHostRecordsTx(tx *bolt.Tx) (map[string][]model.DNSRecord, error) {
+	bucket, err := ds.dnsBucketTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make(map[string][]model.DNSRecord)
+	err = bucket.ForEach(func(k, v []byte) error {
+		records, err := decodeRecords(v)
+		if err != nil {
+			return err
+		}
+		hosts[string(k)] = records
+		return nil
+	})
+
+	return hosts, err
+}
+
+// CreateSnapshot saves a copy of every DNS record, returning the snapshot without its records
+func (ds *DNSMasqService) CreateSnapshot(ctx context.Context, description string) (model.Snapshot, error) {
+	snapshot := model.Snapshot{
+		CreatedAt:   time.Now().UTC(),
+		Actor:       actorFromContext(ctx),
+		Description: description,
+	}
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if snapshot.Records, err = ds.allHostRecordsTx(tx); err != nil {
+			return err
+		}
+		snapshot.Hostnames = len(snapshot.Records)
+
+		bucket := tx.Bucket(ds.snapshotBucket)
+		if snapshot.ID, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(sequenceKey(snapshot.ID), data)
+	})
+	snapshot.Records = nil
+
+	return snapshot, err
+}
+
+// ListSnapshots retrieves every snapshot without its records, oldest first
+func (ds *DNSMasqService) ListSnapshots() ([]model.Snapshot, error) {
+	snapshots := []model.Snapshot{}
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ds.snapshotBucket).ForEach(func(k, v []byte) error {
+			var snapshot model.Snapshot
+			if err := json.Unmarshal(v, &snapshot); err != nil {
+				return err
+			}
+			snapshot.Records = nil
+			snapshots = append(snapshots, snapshot)
+			return nil
+		})
+	})
+
+	return snapshots, err
+}
+
+// RestoreSnapshot replaces every DNS record with those in the snapshot, returning how many hostnames changed. The
+// records of each changed hostname are validated as any other change is, and if any are rejected nothing is restored.
+// Each changed hostname gets a new revision, audited under the actor in ctx
+func (ds *DNSMasqService) RestoreSnapshot(ctx context.Context, id uint64) (int, error) {
+	changed := 0
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(ds.snapshotBucket).Get(sequenceKey(id))
+		if data == nil {
+			return fmt.Errorf("%s", ErrorNoSnapshot)
+		}
+		if err := ds.checkDriftTx(tx); err != nil {
+			return err
+		}
+		var snapshot model.Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		current, err := ds.allHostRecordsTx(tx)
+		if err != nil {
+			return err
+		}
+
+		var hostnames []string
+		for hostname := range current {
+			hostnames = append(hostnames, hostname)
+		}
+		for hostname := range snapshot.Records {
+			if _, ok := current[hostname]; !ok {
+				hostnames = append(hostnames, hostname)
+			}
+		}
+		sort.Strings(hostnames)
+
+		var restored []string
+		for _, hostname := range hostnames {
+			if sameRecords(current[hostname], snapshot.Records[hostname]) {
+				continue
+			}
+			if err = ds.validator.Check(snapshot.Records[hostname]); err != nil {
+				return err
+			}
+			if err = ds.putHostRecordsTx(tx, hostname, snapshot.Records[hostname]); err != nil {
+				return err
+			}
+			if err = ds.recordChangeTx(ctx, tx, model.AuditOpRestore, hostname, current[hostname]); err != nil {
+				return err
+			}
+			restored = append(restored, hostname)
+		}
+		// Shadowing is checked once every hostname is restored, as the snapshot is only consistent as a whole
+		for _, hostname := range restored {
+			if err = ds.checkShadowingTx(tx, hostname); err != nil {
+				return err
+			}
+		}
+		changed = len(restored)
+		return nil
+	})
+
+	return changed, err
+}
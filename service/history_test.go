@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/cclose/dnsmasq-api/constant/key"
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSMasqService_HostHistory(t *testing.T) {
+	ds := newTestDNSMasqService(t, "")
+	ctx := systemContext("deploy")
+
+	_, err := ds.SetIPByHost(ctx, "nas.lan", []string{"10.0.0.5"}, false)
+	require.NoError(t, err)
+	_, err = ds.SetIPByHost(ctx, "nas.lan", []string{"10.0.0.6"}, true)
+	require.NoError(t, err)
+	require.NoError(t, ds.DeleteByHost(ctx, "nas.lan"))
+
+	revisions, err := ds.GetHostHistory("nas.lan")
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	assert.Equal(t, uint64(1), revisions[0].Revision)
+	assert.Equal(t, "deploy", revisions[0].Actor)
+	assert.Len(t, revisions[1].Records, 2)
+	assert.Equal(t, model.AuditOpDelete, revisions[2].Operation)
+	assert.Empty(t, revisions[2].Records)
+
+	// Undo the fat-fingered delete
+	records, err := ds.RevertHost(ctx, "nas.lan", 2)
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	records, err = ds.GetIPByHost("nas.lan")
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	revisions, err = ds.GetHostHistory("nas.lan")
+	require.NoError(t, err)
+	require.Len(t, revisions, 4)
+	assert.Equal(t, model.AuditOpRevert, revisions[3].Operation)
+
+	_, err = ds.RevertHost(ctx, "nas.lan", 9)
+	assert.EqualError(t, err, ErrorNoRevision)
+	_, err = ds.RevertHost(ctx, "missing.lan", 1)
+	assert.EqualError(t, err, ErrorNoHistory)
+	_, err = ds.GetHostHistory("missing.lan")
+	assert.EqualError(t, err, ErrorNoHistory)
+}
+
+func TestDNSMasqService_HostHistoryMaxRevisions(t *testing.T) {
+	ds := newTestDNSMasqService(t, "")
+	ds.maxRevisions = 2
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		_, err := ds.SetIPByHost(context.Background(), "nas.lan", []string{ip}, false)
+		require.NoError(t, err)
+	}
+
+	revisions, err := ds.GetHostHistory("nas.lan")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, uint64(2), revisions[0].Revision)
+	assert.Equal(t, uint64(3), revisions[1].Revision)
+}
+
+func TestDNSMasqService_Snapshots(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\naddress=/b.lan/10.0.0.2\n")
+	ctx := systemContext("admin")
+
+	snapshot, err := ds.CreateSnapshot(ctx, "before the migration")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), snapshot.ID)
+	assert.Equal(t, 2, snapshot.Hostnames)
+	assert.Equal(t, "admin", snapshot.Actor)
+	assert.Nil(t, snapshot.Records)
+
+	require.NoError(t, ds.DeleteByHost(ctx, "a.lan"))
+	_, err = ds.SetIPByHost(ctx, "b.lan", []string{"10.0.0.20"}, false)
+	require.NoError(t, err)
+	_, err = ds.SetIPByHost(ctx, "c.lan", []string{"10.0.0.3"}, false)
+	require.NoError(t, err)
+	before, err := ds.GetAllIPs()
+	require.NoError(t, err)
+
+	snapshots, err := ds.ListSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "before the migration", snapshots[0].Description)
+
+	changed, err := ds.RestoreSnapshot(ctx, snapshot.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, changed)
+	records, err := ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.NotEqual(t, before, records)
+	revisions, err := ds.GetHostHistory("c.lan")
+	require.NoError(t, err)
+	assert.Equal(t, model.AuditOpRestore, revisions[len(revisions)-1].Operation)
+
+	// Restoring regenerates the config
+	require.NoError(t, ds.UpdateDNSMasq())
+	data, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	assert.Equal(t, dnsConfigHeader+"# Generation: 1\naddress=/a.lan/10.0.0.1\naddress=/b.lan/10.0.0.2\n",
+		withoutMetadata(string(data)))
+
+	// Nothing left to restore
+	changed, err = ds.RestoreSnapshot(ctx, snapshot.ID)
+	require.NoError(t, err)
+	assert.Zero(t, changed)
+	_, err = ds.RestoreSnapshot(ctx, 7)
+	assert.EqualError(t, err, ErrorNoSnapshot)
+}
+
+func TestDNSMasqService_RestoreValidation(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\n")
+	ctx := systemContext("admin")
+
+	// Records the API accepted once, under a looser policy
+	ds.validator.allowShadowing = true
+	_, err := ds.SetRecordsByHost(ctx, "a.lan", model.RecordTypeHost, []model.DNSRecord{{IP: "10.0.0.11"}}, false)
+	require.NoError(t, err)
+	_, err = ds.SetIPByHost(ctx, "x.corp", []string{"10.0.0.9"}, false)
+	require.NoError(t, err)
+	snapshot, err := ds.CreateSnapshot(ctx, "")
+	require.NoError(t, err)
+	require.NoError(t, ds.DeleteRecordsByHost(ctx, "a.lan", model.RecordTypeHost))
+	require.NoError(t, ds.DeleteByHost(ctx, "x.corp"))
+	before, err := ds.GetAllIPs()
+	require.NoError(t, err)
+
+	var validationErr *ValidationError
+	ds.validator, err = newValidator(model.ValidationConfig{AllowedZones: []string{"lan"}})
+	require.NoError(t, err)
+	_, err = ds.RevertHost(ctx, "x.corp", 1)
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "hostname", validationErr.Errors[0].Field)
+	_, err = ds.RestoreSnapshot(ctx, snapshot.ID)
+	assert.ErrorAs(t, err, &validationErr)
+
+	// Only the shadowing is rejected now, and the restore is still all or nothing
+	ds.validator, err = newValidator(model.ValidationConfig{})
+	require.NoError(t, err)
+	_, err = ds.RestoreSnapshot(ctx, snapshot.ID)
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "wildcard", validationErr.Errors[0].Field)
+	after, err := ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	revisions, err := ds.GetHostHistory("a.lan")
+	require.NoError(t, err)
+	assert.Equal(t, model.AuditOpDelete, revisions[len(revisions)-1].Operation)
+}
+
+func TestDNSMasqService_Preconditions(t *testing.T) {
+	ds := newTestDNSMasqService(t, "")
+	precondition := func(p model.Precondition) context.Context {
+		return context.WithValue(context.Background(), key.ContextPrecondition, p)
+	}
+	createOnly := precondition(model.Precondition{IfNoneMatch: []string{"*"}})
+
+	_, err := ds.SetIPByHost(createOnly, "nas.lan", []string{"10.0.0.5"}, false)
+	require.NoError(t, err)
+	_, rev, err := ds.GetRecordsByHostWithRevision("nas.lan", "")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), rev)
+
+	// Creating it again fails, as does changing it with a stale ETag
+	_, err = ds.SetIPByHost(createOnly, "nas.lan", []string{"10.0.0.6"}, false)
+	assert.EqualError(t, err, ErrorPreconditionFailed)
+	stale := precondition(model.Precondition{IfMatch: []string{model.RevisionETag(rev + 1)}})
+	_, err = ds.SetIPByHost(stale, "nas.lan", []string{"10.0.0.6"}, false)
+	assert.EqualError(t, err, ErrorPreconditionFailed)
+	assert.EqualError(t, ds.DeleteByHost(stale, "nas.lan"), ErrorPreconditionFailed)
+	records, err := ds.GetIPByHost("nas.lan")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.5", records[0].IP)
+
+	current := precondition(model.Precondition{IfMatch: []string{model.RevisionETag(rev)}})
+	_, err = ds.SetIPByHost(current, "nas.lan", []string{"10.0.0.6"}, false)
+	require.NoError(t, err)
+	rev, err = ds.GetHostRevision("nas.lan")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), rev)
+
+	// The previous ETag is now stale, for renewals too
+	assert.EqualError(t, ds.DeleteByHost(current, "nas.lan"), ErrorPreconditionFailed)
+	_, err = ds.RenewRecordsByHost(current, "nas.lan", model.RenewDNSRecordRequest{TTLSeconds: 60})
+	assert.EqualError(t, err, ErrorPreconditionFailed)
+	current = precondition(model.Precondition{IfMatch: []string{model.RevisionETag(rev)}})
+	_, err = ds.RenewRecordsByHost(current, "nas.lan", model.RenewDNSRecordRequest{TTLSeconds: 60})
+	require.NoError(t, err)
+	rev, err = ds.GetHostRevision("nas.lan")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), rev)
+	current = precondition(model.Precondition{IfMatch: []string{model.RevisionETag(rev)}})
+	require.NoError(t, ds.DeleteByHost(current, "nas.lan"))
+
+	// If-Match needs the hostname to exist, even once it has a revision
+	_, err = ds.SetIPByHost(precondition(model.Precondition{IfMatch: []string{"*"}}), "nas.lan",
+		[]string{"10.0.0.7"}, false)
+	assert.EqualError(t, err, ErrorPreconditionFailed)
+	_, err = ds.SetIPByHost(createOnly, "nas.lan", []string{"10.0.0.7"}, false)
+	require.NoError(t, err)
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Output modes for where host records are rendered
+const (
+	// OutputModeAddress renders every record into the dnsmasq config
+	OutputModeAddress = "address"
+	// OutputModeHosts renders host records into a hosts file, which dnsmasq rereads on SIGHUP, or by itself when
+	// the file is in a hostsdir. Only the other record types are left in the dnsmasq config.
+	OutputModeHosts = "hosts"
+
+	directiveAddnHosts = "addn-hosts"
+	directiveHostsDir  = "hostsdir"
+)
+
+// configFiles The contents of the managed config files
+type configFiles struct {
+	conf  []byte
+	hosts []byte
+}
+
+// hostsMode reports whether host records are rendered into the hosts file
+func (ds *DNSMasqService) hostsMode() bool {
+	return ds.outputMode == OutputModeHosts
+}
+
+// hostsDirective the dnsmasq directive that loads the hosts file
+func (ds *DNSMasqService) hostsDirective() string {
+	if ds.hostsDir {
+		return fmt.Sprintf("%s=%s", directiveHostsDir, filepath.Dir(ds.hostsFile))
+	}
+
+	return fmt.Sprintf("%s=%s", directiveAddnHosts, ds.hostsFile)
+}
+
+// DefaultRecordType the record type created for the hostname when a request only carries IPs. These are exact host
+// records, rendered as host-record or into the hosts file, unless the request asks for a wildcard. A hostname that
+// only has address records, like those loaded from address= lines, keeps its type, so a plain list of IPs replaces
+// them rather than shadowing them
+func (ds *DNSMasqService) DefaultRecordType(hostname string) (model.RecordType, error) {
+	var records []model.DNSRecord
+	err := ds.db.View(func(tx *bolt.Tx) (err error) {
+		records, err = ds.hostRecordsTx(tx, hostname)
+		return err
+	})
+
+	return defaultRecordTypeOf(records), err
+}
+
+// defaultRecordTypeOf the record type a request that only carries IPs creates for a hostname with the given records
+func defaultRecordTypeOf(records []model.DNSRecord) model.RecordType {
+	if entry := ipEntryOf(records); entry.wildcard && !entry.exact {
+		return model.RecordTypeAddress
+	}
+
+	return model.RecordTypeHost
+}
+
+// splitRecords splits records into those rendered into the dnsmasq config and those rendered into the hosts file
+func (ds *DNSMasqService) splitRecords(records []model.DNSRecord) (confRecords, hostsRecords []model.DNSRecord) {
+	if !ds.hostsMode() {
+		return records, nil
+	}
+
+	for _, record := range records {
+		if record.RecordType() == model.RecordTypeHost {
+			hostsRecords = append(hostsRecords, record)
+		} else {
+			confRecords = append(confRecords, record)
+		}
+	}
+
+	return confRecords, hostsRecords
+}
+
+// readConfigFiles reads the managed config files. A missing hosts file is treated as empty. In merge mode, a file
+// whose managed block can't be found is an error
+func (ds *DNSMasqService) readConfigFiles() (configFiles, error) {
+	var files configFiles
+	var err error
+	if files.conf, err = os.ReadFile(ds.dnsMasqConfig); err != nil {
+		return files, err
+	}
+	if err = ds.checkManagedBlock(ds.dnsMasqConfig, files.conf); err != nil {
+		return files, err
+	}
+
+	if ds.hostsMode() {
+		files.hosts, err = os.ReadFile(ds.hostsFile)
+		if err != nil && !os.IsNotExist(err) {
+			return files, err
+		}
+		if err = ds.checkManagedBlock(ds.hostsFile, files.hosts); err != nil {
+			return files, err
+		}
+	}
+
+	return files, nil
+}
+
+// parseConfigFiles parses the records out of the managed config files
+func (ds *DNSMasqService) parseConfigFiles(files configFiles) []model.DNSRecord {
+	records := ds.parseDNSMasq(ds.managedContent(files.conf))
+	if ds.hostsMode() {
+		for _, record := range parseHosts(ds.managedContent(files.hosts)) {
+			if err := checkRecordSyntax(record); err != nil {
+				ds.log.Warnf("Skipping invalid record in %s: %v", ds.hostsFile, err)
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// renderHosts renders host records as a hosts file
+func renderHosts(records []model.DNSRecord) string {
+	var b strings.Builder
+	b.WriteString(dnsConfigHeader)
+	writeRecords(&b, records, renderHostsLine)
+
+	return b.String()
+}
+
+// renderHostsLine renders a host record as a line of a hosts file
+func renderHostsLine(r model.DNSRecord) string {
+	return fmt.Sprintf("%s\t%s", r.IP, r.Hostname)
+}
+
+// parseHosts parses host records out of a hosts file, one record for every name and address pair
+func parseHosts(data string) []model.DNSRecord {
+	var records []model.DNSRecord
+	var meta model.RecordMetadata
+	for _, line := range strings.Split(data, "\n") {
+		// A metadata comment belongs to the entry on the next line
+		if lineMeta, ok := parseMetadata(line); ok {
+			meta = lineMeta
+			continue
+		}
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			meta = model.RecordMetadata{}
+			continue
+		}
+		for _, name := range fields[1:] {
+			records = append(records, model.DNSRecord{
+				Hostname: name, Type: model.RecordTypeHost, IP: fields[0], RecordMetadata: meta,
+			})
+		}
+		meta = model.RecordMetadata{}
+	}
+
+	return records
+}
+
+// newHostsReloader Creates the Reloader that has dnsmasq reread the hosts file. Unless a strategy is configured,
+// dnsmasq is sent SIGHUP using the pid file and sudo setting of the main reload config, or nothing for a hostsdir,
+// which dnsmasq watches itself
+func newHostsReloader(config model.Config) (Reloader, error) {
+	reload := config.Output.Reload
+	if reload.Strategy == "" {
+		if config.SkipDNSMasqReload || config.Output.HostsDir {
+			return NoopReloader{}, nil
+		}
+		reload = model.ReloadConfig{
+			Strategy: ReloadStrategySIGHUP,
+			PIDFile:  config.Reload.PIDFile,
+			Sudo:     config.Reload.Sudo,
+		}
+	}
+
+	return NewReloader(reload, false)
+}
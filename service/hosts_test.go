@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseHosts(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []model.DNSRecord
+	}{
+		{
+			name: "Empty",
+			data: "",
+			want: nil,
+		},
+		{
+			name: "Records",
+			data: dnsConfigHeader + "10.0.0.1\ta.lan\nfd00::1 b.lan\n",
+			want: []model.DNSRecord{
+				{Hostname: "a.lan", Type: model.RecordTypeHost, IP: "10.0.0.1"},
+				{Hostname: "b.lan", Type: model.RecordTypeHost, IP: "fd00::1"},
+			},
+		},
+		{
+			name: "AliasesAndComments",
+			data: "10.0.0.1 a.lan a # the a box\n# 10.0.0.2 b.lan\nnot-an-ip c.lan\n10.0.0.3\n",
+			want: []model.DNSRecord{
+				{Hostname: "a.lan", Type: model.RecordTypeHost, IP: "10.0.0.1"},
+				{Hostname: "a", Type: model.RecordTypeHost, IP: "10.0.0.1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseHosts(tt.data))
+		})
+	}
+}
+
+func Test_renderHosts(t *testing.T) {
+	records := []model.DNSRecord{
+		{Hostname: "a.lan", Type: model.RecordTypeHost, IP: "10.0.0.1"},
+		{Hostname: "a.lan", Type: model.RecordTypeHost, IP: "fd00::1"},
+	}
+	data := renderHosts(records)
+	assert.Equal(t, dnsConfigHeader+"10.0.0.1\ta.lan\nfd00::1\ta.lan\n", data)
+	assert.Equal(t, records, parseHosts(data))
+}
+
+// countingReloader counts its reloads
+type countingReloader struct {
+	calls int
+}
+
+func (r *countingReloader) Reload() error {
+	r.calls += 1
+	return nil
+}
+
+func (r *countingReloader) String() string {
+	return "counting"
+}
+
+func TestDNSMasqService_HostsMode(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "api.conf")
+	hostsPath := filepath.Join(dir, "hosts", "api.hosts")
+	require.NoError(t, os.Mkdir(filepath.Dir(hostsPath), 0755))
+	// Host records already in the config move to the hosts file on the first write
+	require.NoError(t, os.WriteFile(configPath, []byte("address=/lan/10.0.0.254\nhost-record=a.lan,10.0.0.1\n"), dnsFileMode))
+
+	reloader := &countingReloader{}
+	hostsReloader := &countingReloader{}
+	config := model.Config{
+		DnsmasqConfig: configPath,
+		Output:        model.OutputConfig{Mode: "hosts", HostsFile: hostsPath},
+	}
+	svc, err := NewDNSMasqService(config, WithDBFilePath(filepath.Join(dir, "dns.db")), WithLogger(testLogger()),
+		WithReloader(reloader), WithHostsReloader(hostsReloader))
+	require.NoError(t, err)
+	ds := svc.(*DNSMasqService)
+	t.Cleanup(func() { _ = ds.db.Close() })
+	recordType, err := ds.DefaultRecordType("b.lan")
+	require.NoError(t, err)
+	assert.Equal(t, model.RecordTypeHost, recordType)
+
+	require.NoError(t, ds.UpdateDNSMasq())
+	conf, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, dnsConfigHeader+"# Generation: 1\naddn-hosts="+hostsPath+"\naddress=/lan/10.0.0.254\n",
+		withoutMetadata(string(conf)))
+	hosts, err := os.ReadFile(hostsPath)
+	require.NoError(t, err)
+	assert.Equal(t, dnsConfigHeader+"10.0.0.1\ta.lan\n", withoutMetadata(string(hosts)))
+	assert.Equal(t, 1, reloader.calls)
+
+	// Changing a host record only rereads the hosts file
+	_, err = ds.SetRecordsByHost(context.Background(), "b.lan", model.RecordTypeHost, []model.DNSRecord{{IP: "10.0.0.2"}}, false)
+	require.NoError(t, err)
+	require.NoError(t, ds.UpdateDNSMasq())
+	hosts, err = os.ReadFile(hostsPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(hosts), "10.0.0.2\tb.lan\n")
+	assert.Equal(t, 1, reloader.calls)
+	assert.Equal(t, 1, hostsReloader.calls)
+
+	// Nothing changed, so nothing is reloaded
+	require.NoError(t, ds.UpdateDNSMasq())
+	assert.Equal(t, 1, reloader.calls)
+	assert.Equal(t, 1, hostsReloader.calls)
+
+	// Both files are read back into the database
+	require.NoError(t, ds.BuildDatabase())
+	records, err := ds.GetRecords(model.RecordTypeHost)
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	records, err = ds.GetRecords(model.RecordTypeAddress)
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+}
+
+func TestDNSMasqService_DefaultRecordType(t *testing.T) {
+	// Hostnames loaded from address= lines before host records were the default
+	ds := newTestDNSMasqService(t, "address=/nas.lan/10.0.0.5\naddress=/web.lan/10.0.0.20\nhost-record=db.lan,10.0.0.7\n")
+	ctx := context.Background()
+	tests := []struct {
+		hostname string
+		want     model.RecordType
+	}{
+		{hostname: "nas.lan", want: model.RecordTypeAddress},
+		{hostname: "db.lan", want: model.RecordTypeHost},
+		{hostname: "new.lan", want: model.RecordTypeHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			got, err := ds.DefaultRecordType(tt.hostname)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// A plain list of IPs, as POST /dns/:hostname sends it, replaces the address records rather than shadowing them
+	recordType, err := ds.DefaultRecordType("nas.lan")
+	require.NoError(t, err)
+	_, err = ds.SetRecordsByHost(ctx, "nas.lan", recordType, []model.DNSRecord{{IP: "10.0.0.6"}}, false)
+	require.NoError(t, err)
+	_, err = ds.ApplyBulk(ctx, []model.DNSBulkOperation{
+		{Op: model.BulkOpUpsert, Hostname: "web.lan", IPs: []string{"10.0.0.21"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ds.UpdateDNSMasq())
+	data, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	assert.Equal(t, dnsConfigHeader+"# Generation: 1\nhost-record=db.lan,10.0.0.7\naddress=/nas.lan/10.0.0.6\n"+
+		"address=/web.lan/10.0.0.21\n", withoutMetadata(string(data)))
+}
+
+func TestNewDNSMasqService_OutputMode(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "api.conf")
+	require.NoError(t, os.WriteFile(configPath, nil, dnsFileMode))
+
+	_, err := NewDNSMasqService(model.Config{DnsmasqConfig: configPath, Output: model.OutputConfig{Mode: "hosts"}},
+		WithDBFilePath(filepath.Join(dir, "dns.db")), WithLogger(testLogger()))
+	assert.Error(t, err)
+	_, err = NewDNSMasqService(model.Config{DnsmasqConfig: configPath, Output: model.OutputConfig{Mode: "bogus"}},
+		WithDBFilePath(filepath.Join(dir, "dns.db")), WithLogger(testLogger()))
+	assert.Error(t, err)
+}
+
+func Test_newHostsReloader(t *testing.T) {
+	reloader, err := newHostsReloader(model.Config{Output: model.OutputConfig{HostsDir: true}})
+	require.NoError(t, err)
+	assert.Equal(t, NoopReloader{}, reloader)
+
+	// Without its own strategy, the hosts reloader follows the main reload config
+	reloader, err = newHostsReloader(model.Config{Reload: model.ReloadConfig{Sudo: true}})
+	require.NoError(t, err)
+	assert.Equal(t, SignalReloader{PIDFile: defaultReloadPIDFile, Signal: syscall.SIGHUP, Sudo: true}, reloader)
+
+	reloader, err = newHostsReloader(model.Config{
+		Reload: model.ReloadConfig{Strategy: ReloadStrategyRestart, PIDFile: "/run/dnsmasq.pid"},
+		Output: model.OutputConfig{Reload: model.ReloadConfig{Sudo: true}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, SignalReloader{PIDFile: "/run/dnsmasq.pid", Signal: syscall.SIGHUP}, reloader)
+
+	reloader, err = newHostsReloader(model.Config{
+		Output: model.OutputConfig{Reload: model.ReloadConfig{Strategy: ReloadStrategySIGHUP, Sudo: true}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, SignalReloader{PIDFile: defaultReloadPIDFile, Signal: syscall.SIGHUP, Sudo: true}, reloader)
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cclose/dnsmasq-api/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// errImportDryRun rolls back the transaction of a dry run import
+var errImportDryRun = errors.New("import dry run")
+
+// ImportRecords imports records through the bulk operations in a single transaction, returning the changes made. A
+// merge upserts the records of each hostname and type that has none yet, reporting those that already have different
+// records as conflicts. A replace upserts every hostname and type whose records differ, and deletes the records of
+// those missing from the import. A dry run makes the same changes, validation included, then rolls them back. If any
+// operation fails, nothing is changed and ErrorBulkFailed is returned along with each operation's outcome. Every
+// change is audited under the actor in ctx
+func (ds *DNSMasqService) ImportRecords(ctx context.Context, records []model.DNSRecord,
+	opts model.ImportOptions) (model.ImportResult, error) {
+	mode, err := model.ParseImportMode(opts.Mode)
+	if err != nil {
+		return model.ImportResult{}, err
+	}
+	result := model.ImportResult{
+		Mode:      mode,
+		DryRun:    opts.DryRun,
+		Records:   len(records),
+		Changes:   []model.ImportChange{},
+		Conflicts: []model.ImportConflict{},
+		Skipped:   []model.ImportIssue{},
+	}
+
+	err = ds.db.Update(func(tx *bolt.Tx) error {
+		// A dry run changes nothing, so it may still be run while the files are drifted
+		if !opts.DryRun {
+			if err := ds.checkDriftTx(tx); err != nil {
+				return err
+			}
+		}
+		hosts, err := ds.allHostRecordsTx(tx)
+		if err != nil {
+			return err
+		}
+		ops := planImport(mode, hosts, records, &result)
+
+		results, err := ds.applyBulkTx(ctx, tx, ops)
+		if err != nil {
+			result.Results = results
+			return err
+		}
+		for i := range result.Changes {
+			result.Changes[i].After = results[i].Records
+		}
+		if opts.DryRun {
+			return errImportDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errImportDryRun) {
+		err = nil
+	}
+
+	return result, err
+}
+
+// DryRunImport runs a dry run import of the records against the DB file described by the Config, without a
+// DNSMasqService, so nothing is written to the DB or the dnsmasq config. The file is opened read only, and the import
+// is tried against a temporary copy of it, as even a dry run needs a writable transaction. A missing file is an empty
+// database
+func DryRunImport(ctx context.Context, config model.Config, records []model.DNSRecord,
+	mode string) (model.ImportResult, error) {
+	dir, err := os.MkdirTemp("", "dnsmasq-api-import-")
+	if err != nil {
+		return model.ImportResult{}, err
+	}
+	defer os.RemoveAll(dir)
+	copyPath := filepath.Join(dir, "dns.db")
+	if err = copyDBFile(config.DB.FilePath, copyPath); err != nil {
+		return model.ImportResult{}, err
+	}
+
+	ds := &DNSMasqService{
+		dnsBucket:      []byte(defaultDBBucketName),
+		auditBucket:    []byte(defaultAuditBucketName),
+		historyBucket:  []byte(defaultHistoryBucketName),
+		snapshotBucket: []byte(defaultSnapshotBucketName),
+	}
+	WithConfig(config.DB)(ds)
+	if ds.validator, err = newValidator(config.Validation); err != nil {
+		return model.ImportResult{}, err
+	}
+	if err = ds.openDB(copyPath); err != nil {
+		return model.ImportResult{}, err
+	}
+	defer ds.db.Close()
+
+	return ds.ImportRecords(ctx, records, model.ImportOptions{Mode: mode, DryRun: true})
+}
+
+// copyDBFile copies the bolt DB file at path to a new file at dst, opening it read only. Nothing is copied if there
+// is no file at path
+func copyDBFile(path, dst string) error {
+	if path == "" {
+		path = defaultDBFilePath
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := openDBFile(path, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(dst, dbFileMode)
+	})
+}
+
+// planImport works out the bulk operations that import the records, adding the change each one makes, and any
+// conflicts, to the result. Deletes come first, so the records they remove can't clash with the imported ones
+func planImport(mode string, hosts map[string][]model.DNSRecord, records []model.DNSRecord,
+	result *model.ImportResult) []model.DNSBulkOperation {
+	imported := make(map[string]map[model.RecordType][]model.DNSRecord)
+	for _, record := range records {
+		if imported[record.Hostname] == nil {
+			imported[record.Hostname] = make(map[model.RecordType][]model.DNSRecord)
+		}
+		imported[record.Hostname][record.RecordType()] = append(imported[record.Hostname][record.RecordType()], record)
+	}
+
+	var ops []model.DNSBulkOperation
+	if mode == model.ImportModeReplace {
+		for _, hostname := range sortedHostnames(hosts) {
+			for _, recordType := range model.RecordTypes {
+				existing := filterRecords(hosts[hostname], recordType)
+				if len(existing) == 0 || len(imported[hostname][recordType]) > 0 {
+					continue
+				}
+				ops = append(ops, model.DNSBulkOperation{Op: model.BulkOpDelete, Hostname: hostname, Type: recordType})
+				result.Changes = append(result.Changes, model.ImportChange{
+					Op: model.BulkOpDelete, Hostname: hostname, Type: recordType, Before: existing,
+				})
+			}
+		}
+	}
+
+	for _, hostname := range sortedHostnames(imported) {
+		for _, recordType := range model.RecordTypes {
+			typeRecords := removeDuplicates(imported[hostname][recordType])
+			if len(typeRecords) == 0 {
+				continue
+			}
+			existing := filterRecords(hosts[hostname], recordType)
+			if added, removed := diffRecords(existing, typeRecords); len(added) == 0 && len(removed) == 0 {
+				result.Unchanged += 1
+				continue
+			}
+			if mode == model.ImportModeMerge {
+				clashing := append(clashingRecords(hosts[hostname], recordType), existing...)
+				if len(clashing) > 0 {
+					result.Conflicts = append(result.Conflicts, model.ImportConflict{
+						Hostname: hostname, Type: recordType, Existing: clashing, Imported: typeRecords,
+					})
+					continue
+				}
+			}
+			ops = append(ops, model.DNSBulkOperation{
+				Op: model.BulkOpUpsert, Hostname: hostname, Type: recordType, Records: typeRecords,
+			})
+			result.Changes = append(result.Changes, model.ImportChange{
+				Op: model.BulkOpUpsert, Hostname: hostname, Type: recordType, Before: existing,
+			})
+		}
+	}
+
+	return ops
+}
+
+// clashingRecords returns the records of the other kind of IP record than the type, as a hostname can't have both
+// exact and wildcard records. Other types never clash
+func clashingRecords(records []model.DNSRecord, recordType model.RecordType) []model.DNSRecord {
+	switch recordType {
+	case model.RecordTypeHost:
+		return filterRecords(records, model.RecordTypeAddress)
+	case model.RecordTypeAddress:
+		return filterRecords(records, model.RecordTypeHost)
+	}
+
+	return nil
+}
+
+// sortedHostnames returns the hostnames of a map in order
+func sortedHostnames[T any](hosts map[string]T) []string {
+	hostnames := make([]string, 0, len(hosts))
+	for hostname := range hosts {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+
+	return hostnames
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/internal/dnsconf"
+	"github.com/cclose/dnsmasq-api/model"
+)
+
+// csvColumns The columns a CSV import may have, named after the JSON fields of a DNSRecord. Tags are separated by
+// semicolons
+var csvColumns = []string{
+	"hostname", "type", "wildcard", "ip", "target", "text", "port", "priority", "weight", "ttl",
+	"owner", "description", "tags",
+}
+
+// ParseImport parses the records out of data in an import format. name is where the data came from, for the issues.
+// Anything that is not a valid record is returned as an issue, and only data that can't be read at all is an error
+func ParseImport(format, name string, data []byte) ([]model.DNSRecord, []model.ImportIssue, error) {
+	im := &importer{name: name}
+	switch strings.ToLower(format) {
+	case model.ImportFormatHosts:
+		im.parseHosts(string(data))
+	case model.ImportFormatCSV:
+		if err := im.parseCSV(data); err != nil {
+			return nil, nil, err
+		}
+	case model.ImportFormatJSON:
+		if err := im.parseJSON(data); err != nil {
+			return nil, nil, err
+		}
+	case model.ImportFormatZone:
+		im.parseZone(string(data))
+	case model.ImportFormatDNSMasq:
+		im.addConfig(dnsconf.ParseString(string(data), name), false)
+	default:
+		return nil, nil, fmt.Errorf("unknown import format '%s', expected one of %s", format,
+			strings.Join(model.ImportFormats, ", "))
+	}
+
+	return im.records, im.issues, nil
+}
+
+// ParseImportFile reads and parses a file in an import format, as ParseImport. The conf-file and conf-dir includes of
+// a dnsmasq config are followed
+func ParseImportFile(format, path string) ([]model.DNSRecord, []model.ImportIssue, error) {
+	if !strings.EqualFold(format, model.ImportFormatDNSMasq) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ParseImport(format, path, data)
+	}
+
+	config, err := dnsconf.ParseFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	im := &importer{name: path}
+	im.addConfig(config, true)
+
+	return im.records, im.issues, nil
+}
+
+// importer Collects the records parsed out of imported data, and the issues with the rest of it
+type importer struct {
+	name    string
+	records []model.DNSRecord
+	issues  []model.ImportIssue
+}
+
+// skip records an issue with a line of the data
+func (im *importer) skip(line int, text, reason string) {
+	im.issues = append(im.issues, model.ImportIssue{File: im.name, Line: line, Text: text, Reason: reason})
+}
+
+// add adds a record read from a line of the data, skipping it if its syntax is invalid
+func (im *importer) add(line int, text string, record model.DNSRecord) {
+	if err := checkRecordSyntax(record); err != nil {
+		im.skip(line, text, err.Error())
+		return
+	}
+	im.records = append(im.records, record)
+}
+
+// addConfig adds the records of a dnsmasq config, and the issues with it. followed is whether the config's includes
+// were followed, in which case the include options are not issues themselves
+func (im *importer) addConfig(config *dnsconf.Config, followed bool) {
+	records, issues := recordsFromConfig(config)
+	im.records = append(im.records, records...)
+	for _, issue := range append(config.Issues, issues...) {
+		if key, _, _ := strings.Cut(strings.TrimSpace(issue.Text), "="); key == dnsconf.OptionConfFile ||
+			key == dnsconf.OptionConfDir {
+			if followed {
+				continue
+			}
+			issue.Reason = "includes are only followed when importing a file"
+		}
+		im.issues = append(im.issues, model.ImportIssue{
+			File: issue.File, Line: issue.Line, Text: strings.TrimSpace(issue.Text), Reason: issue.Reason,
+		})
+	}
+}
+
+// parseHosts adds the host records of a hosts file. Loopback, multicast and unspecified addresses, like those for
+// localhost and the ip6- names, are skipped, as they only make sense on the machine the file is from
+func (im *importer) parseHosts(data string) {
+	var meta model.RecordMetadata
+	for i, raw := range strings.Split(data, "\n") {
+		// A metadata comment belongs to the entry on the next line
+		if lineMeta, ok := parseMetadata(raw); ok {
+			meta = lineMeta
+			continue
+		}
+		lineMeta := meta
+		meta = model.RecordMetadata{}
+
+		line, _, _ := strings.Cut(raw, "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		text := strings.TrimSpace(raw)
+		ip := net.ParseIP(fields[0])
+		switch {
+		case ip == nil:
+			im.skip(i+1, text, fmt.Sprintf("invalid ip '%s'", fields[0]))
+		case len(fields) < 2:
+			im.skip(i+1, text, "no hostnames for the ip")
+		case ip.IsLoopback() || ip.IsMulticast() || ip.IsUnspecified():
+			im.skip(i+1, text, "loopback, multicast and unspecified addresses are not imported")
+		default:
+			for _, name := range fields[1:] {
+				im.add(i+1, text, model.DNSRecord{
+					Hostname: name, Type: model.RecordTypeHost, IP: fields[0], RecordMetadata: lineMeta,
+				})
+			}
+		}
+	}
+}
+
+// parseCSV adds the records of a CSV file. The header row names the columns, in any order, from csvColumns. Rows
+// without a type are host records, or address records if they are wildcards
+func (im *importer) parseCSV(data []byte) error {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	columns := make(map[string]int)
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !slices.Contains(csvColumns, column) {
+			return fmt.Errorf("unknown csv column '%s', expected %s", column, strings.Join(csvColumns, ", "))
+		}
+		columns[column] = i
+	}
+	if _, ok := columns["hostname"]; !ok {
+		return fmt.Errorf("csv header has no hostname column")
+	}
+
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+		text := strings.Join(row, ",")
+		if len(row) > len(header) {
+			im.skip(line, text, "has more fields than the header")
+			continue
+		}
+		record, err := csvRecord(func(column string) string {
+			if i, ok := columns[column]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		})
+		if err != nil {
+			im.skip(line, text, err.Error())
+			continue
+		}
+		im.add(line, text, record)
+	}
+}
+
+// csvRecord builds a record from the fields of a CSV row
+func csvRecord(field func(column string) string) (model.DNSRecord, error) {
+	record := model.DNSRecord{
+		Hostname: field("hostname"),
+		IP:       field("ip"),
+		Target:   field("target"),
+		Text:     field("text"),
+		RecordMetadata: model.RecordMetadata{
+			Owner:       field("owner"),
+			Description: field("description"),
+		},
+	}
+
+	wildcard := false
+	if value := field("wildcard"); value != "" {
+		var err error
+		if wildcard, err = strconv.ParseBool(value); err != nil {
+			return record, fmt.Errorf("invalid wildcard '%s'", value)
+		}
+	}
+	recordType, err := model.RequestRecordType(model.RecordType(field("type")), wildcard, model.RecordTypeHost)
+	if err != nil {
+		return record, err
+	}
+	record.Type = recordType
+
+	numbers := map[string]*uint16{"port": &record.Port, "priority": &record.Priority, "weight": &record.Weight}
+	for _, column := range []string{"port", "priority", "weight"} {
+		if value := field(column); value != "" {
+			v, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return record, fmt.Errorf("invalid %s '%s'", column, value)
+			}
+			*numbers[column] = uint16(v)
+		}
+	}
+	if value := field("ttl"); value != "" {
+		ttl, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return record, fmt.Errorf("invalid ttl '%s'", value)
+		}
+		record.TTL = uint32(ttl)
+	}
+	for _, tag := range strings.Split(field("tags"), ";") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			record.Tags = append(record.Tags, tag)
+		}
+	}
+
+	return record, nil
+}
+
+// parseJSON adds the records of a JSON list of records. Records without a type are host records, or address records
+// if they are wildcards
+func (im *importer) parseJSON(data []byte) error {
+	var records []model.DNSRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("invalid json: %v", err)
+	}
+
+	for i, record := range records {
+		text := fmt.Sprintf("[%d] %s", i, record.Hostname)
+		recordType, err := model.RequestRecordType(record.Type, record.Wildcard, model.RecordTypeHost)
+		if err != nil {
+			im.skip(0, text, err.Error())
+			continue
+		}
+		record.Type = recordType
+		record.Wildcard = false
+		im.add(0, text, record)
+	}
+
+	return nil
+}
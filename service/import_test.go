@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		data    string
+		want    []model.DNSRecord
+		skipped []int
+		wantErr string
+	}{
+		{
+			name:   "Hosts",
+			format: model.ImportFormatHosts,
+			data: "127.0.0.1 localhost\n::1 ip6-localhost ip6-loopback\n\n10.0.0.5\tnas.lan nas # the NAS\n" +
+				"bad-ip printer.lan\n10.0.0.6\n",
+			want: []model.DNSRecord{
+				{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5"},
+				{Hostname: "nas", Type: model.RecordTypeHost, IP: "10.0.0.5"},
+			},
+			skipped: []int{1, 2, 5, 6},
+		},
+		{
+			name:   "CSV",
+			format: model.ImportFormatCSV,
+			data: "hostname,type,ip,target,ttl,tags\n" +
+				"nas.lan,,10.0.0.5,,300,home;storage\n" +
+				"www.lan,cname,,nas.lan,,\n" +
+				"# a comment\n" +
+				"bad.lan,host,10.0.0.300,,,\n" +
+				"mail.lan,mx,,,,\n" +
+				"lan,address,10.0.0.254,,,\n",
+			want: []model.DNSRecord{
+				{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5", TTL: 300,
+					RecordMetadata: model.RecordMetadata{Tags: []string{"home", "storage"}}},
+				{Hostname: "www.lan", Type: model.RecordTypeCNAME, Target: "nas.lan"},
+				{Hostname: "lan", Type: model.RecordTypeAddress, IP: "10.0.0.254"},
+			},
+			skipped: []int{5, 6},
+		},
+		{
+			name:    "CSVUnknownColumn",
+			format:  model.ImportFormatCSV,
+			data:    "hostname,address\nnas.lan,10.0.0.5\n",
+			wantErr: "unknown csv column 'address', expected hostname, type, wildcard, ip, target, text, port, priority, weight, ttl, owner, description, tags",
+		},
+		{
+			name:   "JSON",
+			format: model.ImportFormatJSON,
+			data: `[{"hostname":"nas.lan","ip":"10.0.0.5","owner":"ops"},{"hostname":"lan","wildcard":true,"ip":"10.0.0.254"},` +
+				`{"hostname":"www.lan","type":"cname","wildcard":true,"target":"nas.lan"}]`,
+			want: []model.DNSRecord{
+				{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5",
+					RecordMetadata: model.RecordMetadata{Owner: "ops"}},
+				{Hostname: "lan", Type: model.RecordTypeAddress, IP: "10.0.0.254"},
+			},
+			skipped: []int{0},
+		},
+		{
+			name:    "JSONInvalid",
+			format:  model.ImportFormatJSON,
+			data:    `{"hostname":"nas.lan"}`,
+			wantErr: "invalid json: json: cannot unmarshal object into Go value of type []model.DNSRecord",
+		},
+		{
+			name:   "Zone",
+			format: model.ImportFormatZone,
+			data: "$ORIGIN lan.\n$TTL 1h\n" +
+				"@ IN SOA ns.lan. admin.lan. (\n  1 ; serial\n  3600 600 86400 300 )\n" +
+				"  IN NS ns\n" +
+				"nas 300 IN A 10.0.0.5\n" +
+				"    IN AAAA fd00::5\n" +
+				"www CNAME nas\n" +
+				"mail.example.com. IN MX 10 mx.example.com.\n" +
+				"_ldap._tcp SRV 0 5 389 nas\n" +
+				"info TXT \"hello \" \"world\"\n" +
+				"*.iot IN A 10.0.1.254\n" +
+				"bad IN A fd00::6\n" +
+				"old IN HINFO \"PC\" \"Linux\"\n",
+			want: []model.DNSRecord{
+				{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5", TTL: 300},
+				{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "fd00::5", TTL: 3600},
+				{Hostname: "www.lan", Type: model.RecordTypeCNAME, Target: "nas.lan", TTL: 3600},
+				{Hostname: "mail.example.com", Type: model.RecordTypeMX, Target: "mx.example.com", Priority: 10},
+				{Hostname: "_ldap._tcp.lan", Type: model.RecordTypeSRV, Target: "nas.lan", Port: 389, Weight: 5},
+				{Hostname: "info.lan", Type: model.RecordTypeTXT, Text: "hello world"},
+				{Hostname: "iot.lan", Type: model.RecordTypeAddress, IP: "10.0.1.254"},
+			},
+			skipped: []int{3, 6, 14, 15},
+		},
+		{
+			name:   "DNSMasq",
+			format: model.ImportFormatDNSMasq,
+			data:   "address=/lan/10.0.0.254\n# meta: {\"owner\":\"ops\"}\nhost-record=nas.lan,10.0.0.5\naddress=/ads.example/\n",
+			want: []model.DNSRecord{
+				{Hostname: "lan", Type: model.RecordTypeAddress, IP: "10.0.0.254"},
+				{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5",
+					RecordMetadata: model.RecordMetadata{Owner: "ops"}},
+			},
+			skipped: []int{4},
+		},
+		{
+			name:    "UnknownFormat",
+			format:  "ldif",
+			wantErr: "unknown import format 'ldif', expected one of hosts, csv, json, zone, dnsmasq",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, issues, err := ParseImport(tt.format, "", []byte(tt.data))
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, records)
+
+			var skipped []int
+			for _, issue := range issues {
+				skipped = append(skipped, issue.Line)
+				assert.NotEmpty(t, issue.Reason)
+			}
+			assert.Equal(t, tt.skipped, skipped)
+		})
+	}
+}
+
+func TestParseImportFile(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "conf.d")
+	require.NoError(t, os.Mkdir(confDir, 0755))
+	mainPath := filepath.Join(dir, "dnsmasq.conf")
+	require.NoError(t, os.WriteFile(mainPath, []byte("address=/lan/10.0.0.254\nconf-dir="+confDir+"\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(confDir, "nas.conf"), []byte("host-record=nas.lan,10.0.0.5\n"), 0644))
+
+	records, issues, err := ParseImportFile(model.ImportFormatDNSMasq, mainPath)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+	require.Len(t, records, 2)
+	assert.Equal(t, "nas.lan", records[1].Hostname)
+
+	_, _, err = ParseImportFile(model.ImportFormatHosts, filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestDNSMasqService_ImportRecords(t *testing.T) {
+	const config = "address=/lan/10.0.0.254\nhost-record=nas.lan,10.0.0.5\nhost-record=web.lan,10.0.0.7\n" +
+		"address=/iot.example/10.0.1.254\n"
+	imported := []model.DNSRecord{
+		{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5"},
+		{Hostname: "web.lan", Type: model.RecordTypeHost, IP: "10.0.0.8"},
+		{Hostname: "iot.example", Type: model.RecordTypeHost, IP: "10.0.1.1"},
+		{Hostname: "db.lan", Type: model.RecordTypeHost, IP: "10.0.0.9"},
+		{Hostname: "db.lan", Type: model.RecordTypeHost, IP: "10.0.0.9"},
+		{Hostname: "db", Type: model.RecordTypeHost, IP: "10.0.0.9"},
+		{Hostname: "www.lan", Type: model.RecordTypeCNAME, Target: "web.lan"},
+	}
+
+	tests := []struct {
+		name      string
+		opts      model.ImportOptions
+		changes   []string
+		unchanged int
+		conflicts []string
+		hostnames []string
+	}{
+		{
+			name:      "Merge",
+			opts:      model.ImportOptions{Mode: model.ImportModeMerge},
+			changes:   []string{"upsert db", "upsert db.lan", "upsert www.lan"},
+			unchanged: 1,
+			conflicts: []string{"iot.example", "web.lan"},
+			hostnames: []string{"db", "db.lan", "iot.example", "lan", "nas.lan", "web.lan", "www.lan"},
+		},
+		{
+			name:      "MergeDryRun",
+			opts:      model.ImportOptions{DryRun: true},
+			changes:   []string{"upsert db", "upsert db.lan", "upsert www.lan"},
+			unchanged: 1,
+			conflicts: []string{"iot.example", "web.lan"},
+			hostnames: []string{"iot.example", "lan", "nas.lan", "web.lan"},
+		},
+		{
+			name: "Replace",
+			opts: model.ImportOptions{Mode: model.ImportModeReplace},
+			changes: []string{"delete iot.example", "delete lan",
+				"upsert db", "upsert db.lan", "upsert iot.example", "upsert web.lan", "upsert www.lan"},
+			unchanged: 1,
+			hostnames: []string{"db", "db.lan", "iot.example", "nas.lan", "web.lan", "www.lan"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := newTestDNSMasqService(t, config)
+			result, err := ds.ImportRecords(systemContext("import"), imported, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, len(imported), result.Records)
+			assert.Equal(t, tt.opts.DryRun, result.DryRun)
+			assert.Equal(t, tt.unchanged, result.Unchanged)
+
+			var changes []string
+			for _, change := range result.Changes {
+				changes = append(changes, change.Op+" "+change.Hostname)
+				if change.Op == model.BulkOpUpsert {
+					assert.NotEmpty(t, change.After)
+				}
+			}
+			assert.Equal(t, tt.changes, changes)
+			var conflicts []string
+			for _, conflict := range result.Conflicts {
+				conflicts = append(conflicts, conflict.Hostname)
+				assert.NotEmpty(t, conflict.Existing)
+			}
+			assert.Equal(t, tt.conflicts, conflicts)
+
+			records, err := ds.GetAllIPs()
+			require.NoError(t, err)
+			hostnames := map[string]bool{}
+			for _, record := range records {
+				hostnames[record.Hostname] = true
+			}
+			assert.Equal(t, tt.hostnames, sortedHostnames(hostnames))
+		})
+	}
+
+	// Nothing is imported when an operation fails
+	ds := newTestDNSMasqService(t, config)
+	result, err := ds.ImportRecords(context.Background(), []model.DNSRecord{
+		{Hostname: "db.lan", Type: model.RecordTypeHost, IP: "10.0.0.9"},
+		{Hostname: "sub.lan", Type: model.RecordTypeAddress, IP: "10.0.2.254"},
+	}, model.ImportOptions{})
+	assert.EqualError(t, err, ErrorBulkFailed)
+	require.Len(t, result.Results, 2)
+	assert.NotEmpty(t, result.Results[1].Errors)
+	_, err = ds.GetIPByHost("db.lan")
+	assert.EqualError(t, err, ErrorNoIPForHost)
+
+	_, err = ds.ImportRecords(context.Background(), imported, model.ImportOptions{Mode: "overwrite"})
+	assert.EqualError(t, err, "unknown import mode 'overwrite'")
+}
+
+func TestDryRunImport(t *testing.T) {
+	ds := newTestDNSMasqService(t, "host-record=nas.lan,10.0.0.5\n")
+	require.NoError(t, ds.db.Close())
+	dbBefore, err := os.ReadFile(ds.dbFilePath)
+	require.NoError(t, err)
+
+	// The config is edited by hand, which a service would load into the database
+	require.NoError(t, os.WriteFile(ds.dnsMasqConfig, []byte("host-record=nas.lan,10.0.0.6\n"), dnsFileMode))
+	imported := []model.DNSRecord{
+		{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5"},
+		{Hostname: "db.lan", Type: model.RecordTypeHost, IP: "10.0.0.9"},
+	}
+	config := model.Config{DnsmasqConfig: ds.dnsMasqConfig, DB: model.DatabaseConfig{FilePath: ds.dbFilePath}}
+	result, err := DryRunImport(context.Background(), config, imported, model.ImportModeMerge)
+	require.NoError(t, err)
+	assert.True(t, result.DryRun)
+	require.Len(t, result.Changes, 1)
+	assert.Equal(t, "db.lan", result.Changes[0].Hostname)
+	assert.Equal(t, 1, result.Unchanged)
+
+	// Neither the database nor the config were touched
+	dbAfter, err := os.ReadFile(ds.dbFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, dbBefore, dbAfter)
+	confAfter, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "host-record=nas.lan,10.0.0.6\n", string(confAfter))
+
+	// A database that doesn't exist yet is empty
+	config.DB.FilePath = filepath.Join(t.TempDir(), "missing.db")
+	result, err = DryRunImport(context.Background(), config, imported, model.ImportModeMerge)
+	require.NoError(t, err)
+	assert.Len(t, result.Changes, 2)
+	assert.NoFileExists(t, config.DB.FilePath)
+}
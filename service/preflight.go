@@ -0,0 +1,99 @@
+package service
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/model"
+)
+
+const (
+	// preflightFilePlaceholder is replaced with the path of the staged config in the preflight command
+	preflightFilePlaceholder = "{file}"
+
+	ConfigStageValidate = "validate"
+	ConfigStageReload   = "reload"
+
+	MetricPreflightFailures = "dnsmasq_preflight_failures_total"
+	MetricReloadFailures    = "dnsmasq_reload_failures_total"
+)
+
+// defaultPreflightCommand has dnsmasq check the syntax of the staged config
+var defaultPreflightCommand = []string{"dnsmasq", "--test", "--conf-file=" + preflightFilePlaceholder}
+
+// ConfigError is returned when a new config is rejected by the preflight check or dnsmasq fails to reload with it
+type ConfigError struct {
+	// Stage is the step that failed, ConfigStageValidate or ConfigStageReload
+	Stage string `json:"stage"`
+	// Output is the combined output of the failed command
+	Output string `json:"output,omitempty"`
+	// RolledBack is true if the previous config was restored
+	RolledBack bool `json:"rolled_back"`
+
+	Err error `json:"-"`
+}
+
+func (e *ConfigError) Error() string {
+	msg := fmt.Sprintf("dnsmasq config %s failed: %v", e.Stage, e.Err)
+	if e.RolledBack {
+		msg += " (previous config restored)"
+	}
+
+	return msg
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// preflight Runs a validator command against a staged config before it replaces the live config
+type preflight struct {
+	enabled bool
+	command []string
+}
+
+// newPreflight creates a preflight from config, using dnsmasq --test if no command is configured. Unless enabled or
+// disabled in config, the preflight runs whenever its command can be found
+func newPreflight(config model.PreflightConfig) preflight {
+	command := config.Command
+	if len(command) == 0 {
+		command = defaultPreflightCommand
+	}
+
+	var enabled bool
+	if config.Enabled != nil {
+		enabled = *config.Enabled
+	} else {
+		_, err := exec.LookPath(command[0])
+		enabled = err == nil
+	}
+
+	return preflight{
+		enabled: enabled,
+		command: command,
+	}
+}
+
+// Check runs the validator against the staged config file, returning a ConfigError if it is rejected
+func (p preflight) Check(stagedPath string) error {
+	if !p.enabled {
+		return nil
+	}
+
+	args := make([]string, len(p.command))
+	for i, arg := range p.command {
+		args[i] = strings.ReplaceAll(arg, preflightFilePlaceholder, stagedPath)
+	}
+
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return &ConfigError{
+			Stage:  ConfigStageValidate,
+			Output: strings.TrimSpace(string(output)),
+			Err:    err,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeValidator the preflight config for the fake validator script in testdata
+func fakeValidator(t *testing.T) model.PreflightConfig {
+	script, err := filepath.Abs(filepath.Join("testdata", "fake-validator.sh"))
+	require.NoError(t, err)
+
+	enabled := true
+	return model.PreflightConfig{Enabled: &enabled, Command: []string{script, "{file}"}}
+}
+
+func TestPreflight_Check(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.conf")
+	bad := filepath.Join(dir, "bad.conf")
+	require.NoError(t, os.WriteFile(good, []byte("address=/a.lan/10.0.0.1\n"), dnsFileMode))
+	require.NoError(t, os.WriteFile(bad, []byte("address=/a.bad/10.0.0.1\n"), dnsFileMode))
+
+	p := newPreflight(fakeValidator(t))
+	assert.NoError(t, p.Check(good))
+
+	err := p.Check(bad)
+	var configErr *ConfigError
+	require.True(t, errors.As(err, &configErr))
+	assert.Equal(t, ConfigStageValidate, configErr.Stage)
+	assert.Contains(t, configErr.Output, "bad config at line 1")
+
+	// Disabled preflight accepts anything
+	disabled := false
+	config := fakeValidator(t)
+	config.Enabled = &disabled
+	assert.NoError(t, newPreflight(config).Check(bad))
+	assert.Equal(t, defaultPreflightCommand, newPreflight(model.PreflightConfig{Enabled: &disabled}).command)
+}
+
+func Test_newPreflight(t *testing.T) {
+	script, err := filepath.Abs(filepath.Join("testdata", "fake-validator.sh"))
+	require.NoError(t, err)
+	disabled := false
+	tests := []struct {
+		name   string
+		config model.PreflightConfig
+		want   bool
+	}{
+		{
+			name:   "UnsetWithCommand",
+			config: model.PreflightConfig{Command: []string{script, "{file}"}},
+			want:   true,
+		},
+		{
+			name:   "UnsetWithoutCommand",
+			config: model.PreflightConfig{Command: []string{filepath.Join(t.TempDir(), "missing"), "{file}"}},
+			want:   false,
+		},
+		{
+			name:   "Disabled",
+			config: model.PreflightConfig{Enabled: &disabled, Command: []string{script, "{file}"}},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, newPreflight(tt.config).enabled)
+		})
+	}
+}
+
+func TestDNSMasqService_UpdateDNSMasqRejected(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\n")
+	ds.preflight = newPreflight(fakeValidator(t))
+	before, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+
+	_, err = ds.SetIPByHost(context.Background(), "b.bad", []string{"10.0.0.2"}, false)
+	require.NoError(t, err)
+
+	err = ds.UpdateDNSMasq()
+	var configErr *ConfigError
+	require.True(t, errors.As(err, &configErr))
+	assert.Equal(t, ConfigStageValidate, configErr.Stage)
+	assert.True(t, configErr.RolledBack)
+
+	// The live config is untouched and the rejected record is gone from the database
+	after, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after))
+	_, err = ds.GetIPByHost("b.bad")
+	assert.EqualError(t, err, ErrorNoIPForHost)
+	_, err = ds.GetIPByHost("a.lan")
+	assert.NoError(t, err)
+
+	// No staged files are left behind
+	entries, err := os.ReadDir(filepath.Dir(ds.dnsMasqConfig))
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), ".tmp-")
+	}
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/cclose/dnsmasq-api/model"
+)
+
+// Reload strategies selectable in config
+const (
+	ReloadStrategyRestart = "restart"
+	ReloadStrategyReload  = "reload"
+	ReloadStrategySIGHUP  = "sighup"
+	ReloadStrategyDBus    = "dbus"
+	ReloadStrategyCommand = "command"
+	ReloadStrategyNone    = "none"
+
+	defaultReloadUnit    = "dnsmasq.service"
+	defaultReloadPIDFile = "/run/dnsmasq/dnsmasq.pid"
+)
+
+// Reloader Tells dnsmasq to pick up a changed config
+type Reloader interface {
+	Reload() error
+	// String describes the reloader for logging
+	String() string
+}
+
+// NewReloader Creates the Reloader for the configured strategy. If no strategy is set, the legacy
+// skip_dnsmasq_reload setting picks between no reload and `sudo systemctl restart dnsmasq.service`.
+func NewReloader(config model.ReloadConfig, skipReload bool) (Reloader, error) {
+	unit := config.Unit
+	if unit == "" {
+		unit = defaultReloadUnit
+	}
+
+	switch strings.ToLower(config.Strategy) {
+	case "":
+		if skipReload {
+			return NoopReloader{}, nil
+		}
+		return SystemctlReloader{Action: ReloadStrategyRestart, Unit: defaultReloadUnit, Sudo: true}, nil
+	case ReloadStrategyRestart, ReloadStrategyReload:
+		return SystemctlReloader{Action: strings.ToLower(config.Strategy), Unit: unit, Sudo: config.Sudo}, nil
+	case ReloadStrategySIGHUP:
+		pidFile := config.PIDFile
+		if pidFile == "" {
+			pidFile = defaultReloadPIDFile
+		}
+		return SignalReloader{PIDFile: pidFile, Signal: syscall.SIGHUP, Sudo: config.Sudo}, nil
+	case ReloadStrategyDBus:
+		return DBusReloader{Unit: unit}, nil
+	case ReloadStrategyCommand:
+		if len(config.Command) == 0 {
+			return nil, fmt.Errorf("reload.command is required for the %s reload strategy", ReloadStrategyCommand)
+		}
+		return CommandReloader{Command: config.Command}, nil
+	case ReloadStrategyNone:
+		return NoopReloader{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown reload strategy '%s'", config.Strategy)
+}
+
+// runReloadCommand runs a reload command, returning a ConfigError with its output if it fails
+func runReloadCommand(args ...string) error {
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return &ConfigError{
+			Stage:  ConfigStageReload,
+			Output: strings.TrimSpace(string(output)),
+			Err:    err,
+		}
+	}
+
+	return nil
+}
+
+// SystemctlReloader Reloads or restarts dnsmasq through systemctl
+type SystemctlReloader struct {
+	// Action is the systemctl verb, reload or restart
+	Action string
+	Unit   string
+	Sudo   bool
+}
+
+func (r SystemctlReloader) Reload() error {
+	args := []string{"systemctl", r.Action, r.Unit}
+	if r.Sudo {
+		args = append([]string{"sudo"}, args...)
+	}
+
+	return runReloadCommand(args...)
+}
+
+func (r SystemctlReloader) String() string {
+	return fmt.Sprintf("systemctl %s %s", r.Action, r.Unit)
+}
+
+// SignalReloader Signals the dnsmasq process found in a pidfile. SIGHUP makes dnsmasq clear its cache and reread
+// its hosts files, but not its config files
+type SignalReloader struct {
+	PIDFile string
+	Signal  syscall.Signal
+	// Sudo sends the signal with `sudo kill`, for when dnsmasq runs as another user
+	Sudo bool
+}
+
+func (r SignalReloader) Reload() error {
+	data, err := os.ReadFile(r.PIDFile)
+	if err != nil {
+		return &ConfigError{Stage: ConfigStageReload, Err: err}
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return &ConfigError{Stage: ConfigStageReload, Err: fmt.Errorf("invalid pid in %s", r.PIDFile)}
+	}
+
+	if r.Sudo {
+		return runReloadCommand("sudo", "kill", "-"+strconv.Itoa(int(r.Signal)), strconv.Itoa(pid))
+	}
+
+	process, err := os.FindProcess(pid)
+	if err == nil {
+		err = process.Signal(r.Signal)
+	}
+	if err != nil {
+		return &ConfigError{Stage: ConfigStageReload, Err: err}
+	}
+
+	return nil
+}
+
+func (r SignalReloader) String() string {
+	return fmt.Sprintf("signal %s to pid in %s", r.Signal, r.PIDFile)
+}
+
+// DBusReloader Asks systemd over D-Bus to reload the dnsmasq unit, which needs a polkit rule instead of sudo
+type DBusReloader struct {
+	Unit string
+}
+
+func (r DBusReloader) Reload() error {
+	return runReloadCommand("dbus-send", "--system", "--print-reply",
+		"--dest=org.freedesktop.systemd1", "/org/freedesktop/systemd1",
+		"org.freedesktop.systemd1.Manager.ReloadUnit", "string:"+r.Unit, "string:replace")
+}
+
+func (r DBusReloader) String() string {
+	return fmt.Sprintf("D-Bus ReloadUnit %s", r.Unit)
+}
+
+// CommandReloader Runs a custom command to reload dnsmasq
+type CommandReloader struct {
+	Command []string
+}
+
+func (r CommandReloader) Reload() error {
+	return runReloadCommand(r.Command...)
+}
+
+func (r CommandReloader) String() string {
+	return strings.Join(r.Command, " ")
+}
+
+// NoopReloader Never reloads dnsmasq
+type NoopReloader struct{}
+
+func (r NoopReloader) Reload() error {
+	return nil
+}
+
+func (r NoopReloader) String() string {
+	return "disabled"
+}
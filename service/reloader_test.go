@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReloader(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     model.ReloadConfig
+		skipReload bool
+		want       Reloader
+		wantErr    bool
+	}{
+		{
+			name: "LegacyDefault",
+			want: SystemctlReloader{Action: "restart", Unit: "dnsmasq.service", Sudo: true},
+		},
+		{
+			name:       "LegacySkip",
+			skipReload: true,
+			want:       NoopReloader{},
+		},
+		{
+			name:       "StrategyOverridesSkip",
+			config:     model.ReloadConfig{Strategy: "reload", Unit: "dnsmasq@lan.service"},
+			skipReload: true,
+			want:       SystemctlReloader{Action: "reload", Unit: "dnsmasq@lan.service"},
+		},
+		{
+			name:   "SIGHUP",
+			config: model.ReloadConfig{Strategy: "SIGHUP", Sudo: true},
+			want:   SignalReloader{PIDFile: "/run/dnsmasq/dnsmasq.pid", Signal: syscall.SIGHUP, Sudo: true},
+		},
+		{
+			name:   "DBus",
+			config: model.ReloadConfig{Strategy: "dbus"},
+			want:   DBusReloader{Unit: "dnsmasq.service"},
+		},
+		{
+			name:   "Command",
+			config: model.ReloadConfig{Strategy: "command", Command: []string{"/usr/local/bin/reload-dns"}},
+			want:   CommandReloader{Command: []string{"/usr/local/bin/reload-dns"}},
+		},
+		{
+			name:    "CommandMissing",
+			config:  model.ReloadConfig{Strategy: "command"},
+			wantErr: true,
+		},
+		{
+			name:   "None",
+			config: model.ReloadConfig{Strategy: "none"},
+			want:   NoopReloader{},
+		},
+		{
+			name:    "Unknown",
+			config:  model.ReloadConfig{Strategy: "reboot"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewReloader(tt.config, tt.skipReload)
+			assert.Equal(t, tt.wantErr, err != nil, "NewReloader() error = %v", err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCommandReloader_Reload(t *testing.T) {
+	assert.NoError(t, CommandReloader{Command: []string{"true"}}.Reload())
+
+	err := CommandReloader{Command: []string{"sh", "-c", "echo unit failed; exit 3"}}.Reload()
+	var configErr *ConfigError
+	require.True(t, errors.As(err, &configErr))
+	assert.Equal(t, ConfigStageReload, configErr.Stage)
+	assert.Equal(t, "unit failed", configErr.Output)
+}
+
+func TestSignalReloader_Reload(t *testing.T) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	pidFile := filepath.Join(t.TempDir(), "dnsmasq.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644))
+
+	require.NoError(t, SignalReloader{PIDFile: pidFile, Signal: syscall.SIGHUP}.Reload())
+	select {
+	case sig := <-sigs:
+		assert.Equal(t, syscall.SIGHUP, sig)
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGHUP not received")
+	}
+
+	assert.Error(t, SignalReloader{PIDFile: filepath.Join(t.TempDir(), "missing.pid"), Signal: syscall.SIGHUP}.Reload())
+}
+
+// failingReloader fails its first reload then succeeds, like dnsmasq refusing a config then accepting the restored one
+type failingReloader struct {
+	calls int
+}
+
+func (r *failingReloader) Reload() error {
+	r.calls += 1
+	if r.calls == 1 {
+		return errors.New("exit status 1")
+	}
+
+	return nil
+}
+
+func (r *failingReloader) String() string {
+	return "failing"
+}
+
+func TestDNSMasqService_UpdateDNSMasqRollback(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\n")
+	reloader := &failingReloader{}
+	ds.reloader = reloader
+	before, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+
+	_, err = ds.SetIPByHost(context.Background(), "b.lan", []string{"10.0.0.2"}, false)
+	require.NoError(t, err)
+
+	err = ds.UpdateDNSMasq()
+	var configErr *ConfigError
+	require.True(t, errors.As(err, &configErr))
+	assert.Equal(t, ConfigStageReload, configErr.Stage)
+	assert.True(t, configErr.RolledBack)
+	assert.Equal(t, 2, reloader.calls)
+
+	after, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after))
+	_, err = ds.GetIPByHost("b.lan")
+	assert.EqualError(t, err, ErrorNoIPForHost)
+}
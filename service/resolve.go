@@ -0,0 +1,133 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+const ErrorNoAnswer = "no record answers for name"
+
+// ipEntry The IP records of a hostname, split into the exact ones and the wildcard ones
+type ipEntry struct {
+	exact    bool
+	wildcard bool
+}
+
+// ipEntryOf returns which kinds of IP records the records hold
+func ipEntryOf(records []model.DNSRecord) ipEntry {
+	var entry ipEntry
+	for _, record := range records {
+		switch record.RecordType() {
+		case model.RecordTypeAddress:
+			entry.wildcard = true
+		case model.RecordTypeHost:
+			entry.exact = true
+		}
+	}
+
+	return entry
+}
+
+// inDomain reports whether the name is strictly under the domain, ignoring case
+func inDomain(name, domain string) bool {
+	return strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(domain))
+}
+
+// checkShadowingTx checks that the IP records of the hostname do not accidentally hide part of a wildcard within a
+// transaction: exact records for the wildcard's own name, which leave it answering only for the names under it, or a
+// wildcard nested in another, which takes every name under it from the outer one. Exact records for other names
+// under a wildcard are allowed, as they are how names are carved out of one. The check is skipped when the
+// validation policy allows shadowing
+func (ds *DNSMasqService) checkShadowingTx(tx *bolt.Tx, hostname string) error {
+	if ds.validator.allowShadowing {
+		return nil
+	}
+	hosts, err := ds.allHostRecordsTx(tx)
+	if err != nil {
+		return err
+	}
+	entry := ipEntryOf(hosts[hostname])
+	if !entry.wildcard {
+		return nil
+	}
+
+	var others []string
+	for other := range hosts {
+		others = append(others, other)
+	}
+	sort.Strings(others)
+
+	var errs []model.FieldError
+	if entry.exact {
+		errs = append(errs, model.FieldError{Field: "wildcard", Value: hostname,
+			Message: "is shadowed by the exact records for the same name"})
+	}
+	for _, other := range others {
+		if !ipEntryOf(hosts[other]).wildcard {
+			continue
+		}
+		if inDomain(hostname, other) {
+			errs = append(errs, model.FieldError{Field: "wildcard", Value: hostname,
+				Message: fmt.Sprintf("shadows the wildcard for '%s' for every name under it", other)})
+		} else if inDomain(other, hostname) {
+			errs = append(errs, model.FieldError{Field: "wildcard", Value: hostname,
+				Message: fmt.Sprintf("is shadowed by the wildcard for '%s' for every name under it", other)})
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+
+	return nil
+}
+
+// Resolve reports which records would answer an address query for the name, the way dnsmasq picks them: host and
+// cname records for exactly the name come first, then the most specific wildcard for the name or a domain above it.
+// Any other wildcards that match are listed as shadowed
+func (ds *DNSMasqService) Resolve(name string) (model.Resolution, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	resolution := model.Resolution{Name: name}
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		hosts, err := ds.allHostRecordsTx(tx)
+		if err != nil {
+			return err
+		}
+
+		// Every wildcard matching the name, most specific first
+		var wildcards []string
+		for hostname, records := range hosts {
+			exactName := strings.EqualFold(hostname, name)
+			if exactName {
+				for _, record := range records {
+					if record.RecordType() == model.RecordTypeHost || record.RecordType() == model.RecordTypeCNAME {
+						resolution.Hostname = hostname
+						resolution.Records = append(resolution.Records, record)
+					}
+				}
+			}
+			if ipEntryOf(records).wildcard && (exactName || inDomain(name, hostname)) {
+				wildcards = append(wildcards, hostname)
+			}
+		}
+		sort.Slice(wildcards, func(i, j int) bool { return len(wildcards[i]) > len(wildcards[j]) })
+
+		if resolution.Hostname == "" && len(wildcards) > 0 {
+			resolution.Hostname = wildcards[0]
+			resolution.Wildcard = true
+			resolution.Records = filterRecords(hosts[wildcards[0]], model.RecordTypeAddress)
+			wildcards = wildcards[1:]
+		}
+		if resolution.Hostname == "" {
+			return fmt.Errorf("%s", ErrorNoAnswer)
+		}
+		resolution.Shadowed = wildcards
+
+		return nil
+	})
+
+	return resolution, err
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSMasqService_Resolve(t *testing.T) {
+	ds := newTestDNSMasqService(t,
+		"address=/lan/10.0.0.254\naddress=/iot.lan/10.0.1.254\nhost-record=nas.lan,10.0.0.5\ncname=www.lan,nas.lan\n")
+
+	tests := []struct {
+		name     string
+		query    string
+		hostname string
+		wildcard bool
+		shadowed []string
+	}{
+		{name: "Exact", query: "nas.lan", hostname: "nas.lan", shadowed: []string{"lan"}},
+		{name: "ExactCase", query: "NAS.lan.", hostname: "nas.lan", shadowed: []string{"lan"}},
+		{name: "CNAME", query: "www.lan", hostname: "www.lan", shadowed: []string{"lan"}},
+		{name: "Wildcard", query: "printer.lan", hostname: "lan", wildcard: true},
+		{name: "WildcardItself", query: "lan", hostname: "lan", wildcard: true},
+		{name: "MostSpecific", query: "cam.iot.lan", hostname: "iot.lan", wildcard: true, shadowed: []string{"lan"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolution, err := ds.Resolve(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.hostname, resolution.Hostname)
+			assert.Equal(t, tt.wildcard, resolution.Wildcard)
+			assert.ElementsMatch(t, tt.shadowed, resolution.Shadowed)
+			assert.NotEmpty(t, resolution.Records)
+		})
+	}
+
+	_, err := ds.Resolve("example.com")
+	assert.EqualError(t, err, ErrorNoAnswer)
+}
+
+func TestDNSMasqService_Shadowing(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/lan/10.0.0.254\nhost-record=nas.lan,10.0.0.5\n")
+	ctx := context.Background()
+
+	// Exact records under a wildcard carve names out of it
+	_, err := ds.SetRecordsByHost(ctx, "printer.lan", model.RecordTypeHost, []model.DNSRecord{{IP: "10.0.0.9"}}, false)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		hostname   string
+		recordType model.RecordType
+	}{
+		{name: "NestedWildcard", hostname: "iot.lan", recordType: model.RecordTypeAddress},
+		{name: "OuterWildcard", hostname: "home", recordType: model.RecordTypeAddress},
+		{name: "ExactOverWildcard", hostname: "lan", recordType: model.RecordTypeHost},
+		{name: "WildcardOverExact", hostname: "nas.lan", recordType: model.RecordTypeAddress},
+	}
+	// home only shadows once there is a wildcard under it
+	_, err = ds.SetRecordsByHost(ctx, "lab.home", model.RecordTypeAddress, []model.DNSRecord{{IP: "10.0.2.254"}}, false)
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ds.SetRecordsByHost(ctx, tt.hostname, tt.recordType, []model.DNSRecord{{IP: "10.0.3.1"}}, true)
+			var validationErr *ValidationError
+			require.True(t, errors.As(err, &validationErr), "expected a validation error, got %v", err)
+			assert.Equal(t, "wildcard", validationErr.Errors[0].Field)
+		})
+	}
+
+	// The policy can allow it
+	ds.validator.allowShadowing = true
+	_, err = ds.SetRecordsByHost(ctx, "iot.lan", model.RecordTypeAddress, []model.DNSRecord{{IP: "10.0.1.254"}}, false)
+	require.NoError(t, err)
+}
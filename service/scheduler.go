@@ -0,0 +1,135 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	MetricUpdatesCoalesced = "dnsmasq_updates_coalesced_total"
+	MetricUpdatesExecuted  = "dnsmasq_updates_executed_total"
+)
+
+// updateScheduler Coalesces bursts of config updates into a single update. Each request pushes the update back by
+// the debounce window, but never past the max delay after the first pending request.
+type updateScheduler struct {
+	update   func(reload bool) error
+	window   time.Duration
+	maxDelay time.Duration
+	log      *logrus.Logger
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	first   time.Time
+	waiters []chan error
+	// reload is set when any pending request needs dnsmasq reloaded even if its files are unchanged
+	reload bool
+	// running is how many flushes are in progress, and idle is signalled as each of them finishes
+	running int
+	idle    *sync.Cond
+}
+
+// newUpdateScheduler creates an updateScheduler that calls update. A zero window runs every update immediately
+func newUpdateScheduler(update func(reload bool) error, window, maxDelay time.Duration, log *logrus.Logger) *updateScheduler {
+	s := &updateScheduler{
+		update:   update,
+		window:   window,
+		maxDelay: maxDelay,
+		log:      log,
+	}
+	s.idle = sync.NewCond(&s.mu)
+
+	return s
+}
+
+// Enabled reports whether updates are debounced
+func (s *updateScheduler) Enabled() bool {
+	return s.window > 0
+}
+
+// Schedule requests an update, returning a channel that receives the result of the update that covers it.
+// With reload set, the update reloads dnsmasq even if none of the files it checks changed
+func (s *updateScheduler) Schedule(reload bool) <-chan error {
+	done := make(chan error, 1)
+	if !s.Enabled() {
+		metrics.GetOrCreateCounter(MetricUpdatesExecuted).Inc()
+		done <- s.update(reload)
+		return done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waiters = append(s.waiters, done)
+	s.reload = s.reload || reload
+
+	now := time.Now()
+	if s.timer == nil {
+		s.first = now
+		delay := s.window
+		if s.maxDelay > 0 {
+			delay = min(delay, s.maxDelay)
+		}
+		s.timer = time.AfterFunc(delay, func() { _ = s.flush() })
+		return done
+	}
+
+	metrics.GetOrCreateCounter(MetricUpdatesCoalesced).Inc()
+	// If the timer already fired, the pending flush has not taken the waiters yet and will cover this request
+	if s.timer.Stop() {
+		delay := s.window
+		if s.maxDelay > 0 {
+			delay = min(delay, max(s.first.Add(s.maxDelay).Sub(now), 0))
+		}
+		s.timer.Reset(delay)
+	}
+
+	return done
+}
+
+// Flush runs any pending update now, returning its result. An update the timer already started is waited for, so
+// nothing is left running once Flush returns
+func (s *updateScheduler) Flush() error {
+	s.mu.Lock()
+	for {
+		if s.timer != nil && s.timer.Stop() {
+			s.mu.Unlock()
+			return s.flush()
+		}
+		if s.timer == nil && s.running == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		// The timer fired, so its flush is on its way or running
+		s.idle.Wait()
+	}
+}
+
+// flush runs the update for all pending requests and hands each of them the result
+func (s *updateScheduler) flush() error {
+	s.mu.Lock()
+	waiters, reload := s.waiters, s.reload
+	s.waiters, s.reload = nil, false
+	s.timer = nil
+	s.running += 1
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running -= 1
+		s.idle.Broadcast()
+		s.mu.Unlock()
+	}()
+
+	metrics.GetOrCreateCounter(MetricUpdatesExecuted).Inc()
+	err := s.update(reload)
+	if err != nil {
+		s.log.Errorf("Failed to apply %d coalesced dnsmasq updates: %v", len(waiters), err)
+	}
+	for _, done := range waiters {
+		done <- err
+	}
+
+	return err
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_updateScheduler_Disabled(t *testing.T) {
+	var calls atomic.Int32
+	s := newUpdateScheduler(func(bool) error {
+		calls.Add(1)
+		return errors.New("failed")
+	}, 0, 0, testLogger())
+
+	done := s.Schedule(false)
+	select {
+	case err := <-done:
+		assert.EqualError(t, err, "failed")
+	default:
+		t.Fatal("update did not run immediately")
+	}
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func Test_updateScheduler_Coalesces(t *testing.T) {
+	var calls atomic.Int32
+	s := newUpdateScheduler(func(bool) error {
+		calls.Add(1)
+		return nil
+	}, 50*time.Millisecond, time.Second, testLogger())
+
+	var results []<-chan error
+	for i := 0; i < 10; i++ {
+		results = append(results, s.Schedule(false))
+	}
+	for _, done := range results {
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("update never ran")
+		}
+	}
+	assert.Equal(t, int32(1), calls.Load())
+
+	// A later request starts a new batch
+	require.NoError(t, <-s.Schedule(false))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func Test_updateScheduler_CoalescesReload(t *testing.T) {
+	var reloads []bool
+	s := newUpdateScheduler(func(reload bool) error {
+		reloads = append(reloads, reload)
+		return nil
+	}, 50*time.Millisecond, time.Second, testLogger())
+
+	first := s.Schedule(false)
+	second := s.Schedule(true)
+	require.NoError(t, <-first)
+	require.NoError(t, <-second)
+
+	// The next batch does not inherit the reload
+	require.NoError(t, <-s.Schedule(false))
+	assert.Equal(t, []bool{true, false}, reloads)
+}
+
+func Test_updateScheduler_MaxDelay(t *testing.T) {
+	var calls atomic.Int32
+	s := newUpdateScheduler(func(bool) error {
+		calls.Add(1)
+		return nil
+	}, 40*time.Millisecond, 100*time.Millisecond, testLogger())
+
+	// Requests keep arriving inside the window, but the max delay forces a flush
+	first := s.Schedule(false)
+	start := time.Now()
+	deadline := time.After(time.Second)
+	for calls.Load() == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("max delay never flushed")
+		case <-time.After(10 * time.Millisecond):
+			s.Schedule(false)
+		}
+	}
+	require.NoError(t, <-first)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// A max delay shorter than the window bounds the first request too
+	s = newUpdateScheduler(func(bool) error { return nil }, time.Hour, 50*time.Millisecond, testLogger())
+	select {
+	case err := <-s.Schedule(false):
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("max delay never flushed the first request")
+	}
+}
+
+func Test_updateScheduler_Flush(t *testing.T) {
+	var calls atomic.Int32
+	s := newUpdateScheduler(func(bool) error {
+		calls.Add(1)
+		return nil
+	}, time.Hour, 0, testLogger())
+
+	done := s.Schedule(false)
+	require.NoError(t, s.Flush())
+	require.NoError(t, <-done)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Nothing pending
+	require.NoError(t, s.Flush())
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func Test_updateScheduler_FlushWaitsForRunning(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var finished atomic.Bool
+	s := newUpdateScheduler(func(bool) error {
+		close(started)
+		<-release
+		finished.Store(true)
+		return nil
+	}, 10*time.Millisecond, 0, testLogger())
+
+	done := s.Schedule(false)
+	<-started
+
+	// The timer's flush is still running, so Flush must wait for it
+	flushed := make(chan error, 1)
+	go func() { flushed <- s.Flush() }()
+	select {
+	case <-flushed:
+		t.Fatal("Flush returned while an update was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-flushed:
+		require.NoError(t, err)
+		assert.True(t, finished.Load())
+	case <-time.After(time.Second):
+		t.Fatal("Flush never returned")
+	}
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,400 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/fsnotify/fsnotify"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	dbMetaWrittenSuffix = ".written"
+	// defaultSyncWatchDelay is how long the watched files must be left alone before a change is reconciled
+	defaultSyncWatchDelay = time.Second
+
+	ErrorConfigDrift = "dnsmasq config was changed outside the API and the fail sync policy leaves it to be reconciled"
+
+	// MetricSyncDrifted is 1 while the managed files hold changes made outside the API, 0 otherwise
+	MetricSyncDrifted = "dnsmasq_config_drifted"
+	// MetricSyncDifferences is how many hostnames and types were changed outside the API
+	MetricSyncDifferences = "dnsmasq_config_drift_differences"
+	// MetricSyncResolved counts the drift resolved, by the side that was kept
+	MetricSyncResolved = "dnsmasq_config_drift_resolved_total"
+)
+
+// writtenKey the Meta Bucket key holding the records last written to the managed files for the DNS Bucket
+func (ds *DNSMasqService) writtenKey() []byte {
+	return []byte(string(ds.dnsBucket) + dbMetaWrittenSuffix)
+}
+
+// getWritten reads the records last written to, or loaded from, the managed files. ok is false if the database has
+// no record of them
+func (ds *DNSMasqService) getWritten() (records []model.DNSRecord, ok bool, err error) {
+	err = ds.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dbMetaBucketName))
+		if bucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		data := bucket.Get(ds.writtenKey())
+		if data == nil {
+			return nil
+		}
+		ok = true
+		records, err = decodeRecords(data)
+		return err
+	})
+
+	return records, ok, err
+}
+
+// setWritten records the records the managed files hold after a write or load, so later changes to the files can be
+// told from changes still to be written from the database
+func (ds *DNSMasqService) setWritten(records []model.DNSRecord) error {
+	if records == nil {
+		records = []model.DNSRecord{}
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dbMetaBucketName))
+		if bucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		return bucket.Put(ds.writtenKey(), data)
+	})
+}
+
+// groupRecords groups records by hostname and type
+func groupRecords(records []model.DNSRecord) map[string]map[model.RecordType][]model.DNSRecord {
+	groups := make(map[string]map[model.RecordType][]model.DNSRecord)
+	for _, record := range records {
+		if groups[record.Hostname] == nil {
+			groups[record.Hostname] = make(map[model.RecordType][]model.DNSRecord)
+		}
+		groups[record.Hostname][record.RecordType()] = append(groups[record.Hostname][record.RecordType()], record)
+	}
+
+	return groups
+}
+
+// sameDirectives reports whether two sets of records render the same directives, whatever their order and metadata
+func sameDirectives(a, b []model.DNSRecord) bool {
+	added, removed := diffRecords(a, b)
+	return len(added) == 0 && len(removed) == 0
+}
+
+// syncStatus compares the database with the records of the managed files, and both with the records last written to
+// the files, which tells the changes made to the files apart from the changes still to be written. A database from
+// before the written records were kept has no record of them, so on startup the database is taken as written, as it
+// always was, and otherwise the files are, until the next write
+func (ds *DNSMasqService) syncStatus(files configFiles, policy string, startup bool) (model.SyncStatus, error) {
+	status := model.SyncStatus{Policy: policy, Differences: []model.SyncDifference{}}
+	fileRecords := ds.parseConfigFiles(files)
+	fileGen, hasFileGen := parseGeneration(ds.managedContent(files.conf))
+	dbGen, err := ds.getGeneration()
+	if err != nil {
+		return status, err
+	}
+	dbRecords, err := ds.GetAllIPs()
+	if err != nil {
+		return status, err
+	}
+	written, ok, err := ds.getWritten()
+	if err != nil {
+		return status, err
+	}
+	if !ok && startup {
+		written = dbRecords
+	} else if !ok {
+		written = fileRecords
+	}
+	status.DBGeneration, status.FileGeneration = dbGen, fileGen
+	status.Stale = hasFileGen && fileGen < dbGen
+
+	var drifted int
+	status.Differences, drifted = syncDifferences(dbRecords, fileRecords, written)
+	status.InSync = len(status.Differences) == 0
+	status.Drifted = drifted > 0
+
+	metrics.GetOrCreateCounter(MetricSyncDifferences).Set(uint64(drifted))
+	if status.Drifted {
+		metrics.GetOrCreateCounter(MetricSyncDrifted).Set(1)
+	} else {
+		metrics.GetOrCreateCounter(MetricSyncDrifted).Set(0)
+	}
+
+	return status, nil
+}
+
+// syncDifferences compares the database records with the file records, returning each hostname and type that
+// differs, and how many of them were changed in the files, judged by the records last written to them
+func syncDifferences(dbRecords, fileRecords, written []model.DNSRecord) (diffs []model.SyncDifference, drifted int) {
+	diffs = []model.SyncDifference{}
+	dbGroups, fileGroups, writtenGroups := groupRecords(dbRecords), groupRecords(fileRecords), groupRecords(written)
+	hostnames := make(map[string]bool)
+	for _, groups := range []map[string]map[model.RecordType][]model.DNSRecord{dbGroups, fileGroups} {
+		for hostname := range groups {
+			hostnames[hostname] = true
+		}
+	}
+	for _, hostname := range sortedHostnames(hostnames) {
+		for _, recordType := range model.RecordTypes {
+			db, file := dbGroups[hostname][recordType], fileGroups[hostname][recordType]
+			if sameDirectives(db, file) {
+				continue
+			}
+
+			diff := model.SyncDifference{Hostname: hostname, Type: recordType, Source: model.SyncSourceDB, DB: db,
+				File: file}
+			if !sameDirectives(file, writtenGroups[hostname][recordType]) {
+				drifted += 1
+				diff.Source = model.SyncSourceFile
+				if !sameDirectives(db, writtenGroups[hostname][recordType]) {
+					diff.Source = model.SyncSourceBoth
+				}
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+
+	return diffs, drifted
+}
+
+// checkDriftTx refuses a change to the database within a transaction while the fail sync policy leaves the managed
+// files drifted, so the change is never committed only to be refused by the update that would write it. Files that
+// can't be read, or were never written, are left for the update to report
+func (ds *DNSMasqService) checkDriftTx(tx *bolt.Tx) error {
+	if ds.syncPolicy != model.SyncPolicyFail {
+		return nil
+	}
+	bucket := tx.Bucket([]byte(dbMetaBucketName))
+	if bucket == nil {
+		return fmt.Errorf("bucket not found")
+	}
+	data := bucket.Get(ds.writtenKey())
+	if data == nil {
+		return nil
+	}
+	written, err := decodeRecords(data)
+	if err != nil {
+		return err
+	}
+	files, err := ds.readConfigFiles()
+	if err != nil {
+		return nil
+	}
+	hosts, err := ds.allHostRecordsTx(tx)
+	if err != nil {
+		return err
+	}
+	var dbRecords []model.DNSRecord
+	for _, records := range hosts {
+		dbRecords = append(dbRecords, records...)
+	}
+
+	if _, drifted := syncDifferences(dbRecords, ds.parseConfigFiles(files), written); drifted > 0 {
+		return errors.New(ErrorConfigDrift)
+	}
+
+	return nil
+}
+
+// clearSyncMetrics resets the drift gauges once the managed files match the database
+func clearSyncMetrics() {
+	metrics.GetOrCreateCounter(MetricSyncDifferences).Set(0)
+	metrics.GetOrCreateCounter(MetricSyncDrifted).Set(0)
+}
+
+// resolveDrift resolves the changes made to the managed files outside the API by the policy, returning the status
+// they were found in. When the file wins, its changes are loaded into the database, on top of the changes still to
+// be written, and for a hostname and type changed on both sides the file's records are kept. When the database wins
+// it is left as it is, so the next write drops the changes. A stale file never wins. The fail policy leaves both
+// sides alone and returns ErrorConfigDrift
+func (ds *DNSMasqService) resolveDrift(files configFiles, policy string, startup bool) (model.SyncStatus, error) {
+	status, err := ds.syncStatus(files, policy, startup)
+	if err != nil || !status.Drifted {
+		return status, err
+	}
+
+	metrics.GetOrCreateCounter(MetricDNSDrift).Inc()
+	ds.log.Warnf("dnsmasq config %s (generation %d) was changed outside the API since the database (generation %d) "+
+		"last wrote it", ds.dnsMasqConfig, status.FileGeneration, status.DBGeneration)
+	for _, diff := range status.Differences {
+		if diff.Source == model.SyncSourceDB {
+			continue
+		}
+		added, removed := diffRecords(diff.DB, diff.File)
+		for _, line := range added {
+			ds.log.Warnf("  + %s", line)
+		}
+		for _, line := range removed {
+			ds.log.Warnf("  - %s", line)
+		}
+	}
+
+	switch {
+	case policy == model.SyncPolicyFail:
+		ds.log.Errorf("dnsmasq config %s is left as it is by the %s sync policy, and will not be written until it is "+
+			"reconciled", ds.dnsMasqConfig, policy)
+		return status, errors.New(ErrorConfigDrift)
+	case status.Stale:
+		ds.log.Warnf("dnsmasq config %s is stale, rewriting it from the database", ds.dnsMasqConfig)
+		status.Resolution = model.SyncSourceDB
+	case policy == model.SyncPolicyDBWins:
+		ds.log.Warnf("dnsmasq config %s is rewritten from the database by the %s sync policy", ds.dnsMasqConfig, policy)
+		status.Resolution = model.SyncSourceDB
+	default:
+		ds.log.Warnf("dnsmasq config %s was changed outside the API, loading the changes into the database",
+			ds.dnsMasqConfig)
+		dbRecords, err := ds.GetAllIPs()
+		if err != nil {
+			return status, err
+		}
+		if err = ds.loadRecords(systemContext(auditActorConfig), model.AuditOpLoad,
+			mergeFileChanges(dbRecords, status.Differences)); err != nil {
+			return status, err
+		}
+		status.Resolution = model.SyncSourceFile
+	}
+	metrics.GetOrCreateCounter(fmt.Sprintf(`%s{resolution="%s"}`, MetricSyncResolved, status.Resolution)).Inc()
+
+	return status, nil
+}
+
+// mergeFileChanges the database records, with the records of each hostname and type changed in the file replaced by
+// the file's
+func mergeFileChanges(dbRecords []model.DNSRecord, diffs []model.SyncDifference) []model.DNSRecord {
+	changed := make(map[string]map[model.RecordType]bool)
+	var records []model.DNSRecord
+	for _, diff := range diffs {
+		if diff.Source == model.SyncSourceDB {
+			continue
+		}
+		if changed[diff.Hostname] == nil {
+			changed[diff.Hostname] = make(map[model.RecordType]bool)
+		}
+		changed[diff.Hostname][diff.Type] = true
+		records = append(records, diff.File...)
+	}
+	for _, record := range dbRecords {
+		if !changed[record.Hostname][record.RecordType()] {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// SyncStatus Compares the database with the managed files, reporting each hostname and type that differs, and
+// whether the files were changed outside the API
+func (ds *DNSMasqService) SyncStatus() (model.SyncStatus, error) {
+	ds.updateMu.Lock()
+	defer ds.updateMu.Unlock()
+
+	files, err := ds.readConfigFiles()
+	if err != nil {
+		return model.SyncStatus{}, err
+	}
+
+	return ds.syncStatus(files, ds.syncPolicy, false)
+}
+
+// Reconcile Resolves the changes made to the managed files outside the API by the policy, or the configured one if it
+// is empty, then writes the database out and reloads dnsmasq. Nothing is written if the files were not changed. The
+// status the files were found in is returned, with the side that was kept
+func (ds *DNSMasqService) Reconcile(policy string) (model.SyncStatus, error) {
+	if policy == "" {
+		policy = ds.syncPolicy
+	}
+	policy, err := model.ParseSyncPolicy(policy)
+	if err != nil {
+		return model.SyncStatus{}, err
+	}
+
+	ds.updateMu.Lock()
+	defer ds.updateMu.Unlock()
+
+	files, err := ds.readConfigFiles()
+	if err != nil {
+		return model.SyncStatus{}, err
+	}
+	status, err := ds.syncStatus(files, policy, false)
+	if err != nil || !status.Drifted {
+		return status, err
+	}
+
+	return ds.update(policy, false)
+}
+
+// StartSyncWatcher Reconciles the managed files by the configured policy whenever they change, once they have been
+// left alone for delay, until the returned stop function is called. The directories holding the files are watched,
+// as every write replaces the files rather than changing them
+func (ds *DNSMasqService) StartSyncWatcher(delay time.Duration) (stop func(), err error) {
+	if delay <= 0 {
+		delay = defaultSyncWatchDelay
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]bool{filepath.Clean(ds.dnsMasqConfig): true}
+	if ds.hostsMode() {
+		paths[filepath.Clean(ds.hostsFile)] = true
+	}
+	dirs := make(map[string]bool)
+	for path := range paths {
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		if err = watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	timer.Stop()
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if paths[filepath.Clean(event.Name)] && event.Op != fsnotify.Chmod {
+					timer.Reset(delay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				ds.log.Errorf("Failed to watch dnsmasq config %s: %v", ds.dnsMasqConfig, err)
+			case <-timer.C:
+				if _, err := ds.Reconcile(""); err != nil {
+					ds.log.Errorf("Failed to reconcile dnsmasq config %s: %v", ds.dnsMasqConfig, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		timer.Stop()
+		_ = watcher.Close()
+	}, nil
+}
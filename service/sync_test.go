@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSyncTestService creates a DNSMasqService with the sync policy, that has written a.lan and b.lan to its config
+func newSyncTestService(t *testing.T, policy string) *DNSMasqService {
+	t.Helper()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "api.conf")
+	require.NoError(t, os.WriteFile(configPath, []byte("address=/a.lan/10.0.0.1\naddress=/b.lan/10.0.0.2\n"),
+		dnsFileMode))
+
+	config := model.Config{DnsmasqConfig: configPath, SkipDNSMasqReload: true, Sync: model.SyncConfig{Policy: policy}}
+	svc, err := NewDNSMasqService(config, WithDBFilePath(filepath.Join(dir, "dns.db")), WithLogger(testLogger()))
+	require.NoError(t, err)
+	ds := svc.(*DNSMasqService)
+	t.Cleanup(func() { _ = ds.db.Close() })
+	require.NoError(t, ds.WriteDNSMasq())
+
+	return ds
+}
+
+// editConfig replaces old with new in the config, as a hand edit would
+func editConfig(t *testing.T, ds *DNSMasqService, old, new string) {
+	t.Helper()
+	data, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	require.Contains(t, string(data), old)
+	require.NoError(t, os.WriteFile(ds.dnsMasqConfig, []byte(strings.Replace(string(data), old, new, 1)), dnsFileMode))
+}
+
+// hostIPs the IPs of each hostname in the database
+func hostIPs(t *testing.T, ds *DNSMasqService) map[string]string {
+	t.Helper()
+	records, err := ds.GetAllIPs()
+	require.NoError(t, err)
+	ips := make(map[string]string)
+	for _, record := range records {
+		ips[record.Hostname] = record.IP
+	}
+
+	return ips
+}
+
+func TestDNSMasqService_SyncStatus(t *testing.T) {
+	ds := newSyncTestService(t, "")
+
+	status, err := ds.SyncStatus()
+	require.NoError(t, err)
+	assert.True(t, status.InSync)
+	assert.False(t, status.Drifted)
+	assert.Equal(t, model.SyncPolicyFileWins, status.Policy)
+	assert.Equal(t, uint64(1), status.DBGeneration)
+	assert.Equal(t, uint64(1), status.FileGeneration)
+	assert.Empty(t, status.Differences)
+
+	// A change still to be written is not drift
+	_, err = ds.SetIPByHost(context.Background(), "c.lan", []string{"10.0.0.3"}, false)
+	require.NoError(t, err)
+	status, err = ds.SyncStatus()
+	require.NoError(t, err)
+	assert.False(t, status.InSync)
+	assert.False(t, status.Drifted)
+	require.Len(t, status.Differences, 1)
+	assert.Equal(t, model.SyncSourceDB, status.Differences[0].Source)
+	assert.Empty(t, status.Differences[0].File)
+
+	// Changes to the file are, and a hostname changed on both sides is reported as such
+	_, err = ds.SetIPByHost(context.Background(), "b.lan", []string{"10.0.0.12"}, false)
+	require.NoError(t, err)
+	editConfig(t, ds, "address=/b.lan/10.0.0.2\n", "address=/b.lan/10.0.0.22\naddress=/d.lan/10.0.0.4\n")
+	status, err = ds.SyncStatus()
+	require.NoError(t, err)
+	assert.True(t, status.Drifted)
+	var sources []string
+	for _, diff := range status.Differences {
+		sources = append(sources, diff.Hostname+" "+diff.Source)
+	}
+	assert.Equal(t, []string{"b.lan both", "c.lan db", "d.lan file"}, sources)
+}
+
+func TestDNSMasqService_Reconcile(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		resolution string
+		wantErr    string
+		ips        map[string]string
+	}{
+		{
+			name:       "FileWins",
+			policy:     model.SyncPolicyFileWins,
+			resolution: model.SyncSourceFile,
+			ips:        map[string]string{"a.lan": "10.0.0.11", "c.lan": "10.0.0.3"},
+		},
+		{
+			name:       "DBWins",
+			policy:     model.SyncPolicyDBWins,
+			resolution: model.SyncSourceDB,
+			ips:        map[string]string{"a.lan": "10.0.0.1", "c.lan": "10.0.0.3"},
+		},
+		{
+			name:    "Fail",
+			policy:  model.SyncPolicyFail,
+			wantErr: ErrorConfigDrift,
+			ips:     map[string]string{"a.lan": "10.0.0.1", "c.lan": "10.0.0.3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := newSyncTestService(t, tt.policy)
+
+			// The API deletes b.lan and adds c.lan, while a.lan is edited by hand
+			require.NoError(t, ds.DeleteByHost(context.Background(), "b.lan"))
+			_, err := ds.SetIPByHost(context.Background(), "c.lan", []string{"10.0.0.3"}, false)
+			require.NoError(t, err)
+			editConfig(t, ds, "address=/a.lan/10.0.0.1\n", "address=/a.lan/10.0.0.11\n")
+
+			err = ds.UpdateDNSMasq()
+			assert.Equal(t, tt.ips, hostIPs(t, ds))
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				data, err := os.ReadFile(ds.dnsMasqConfig)
+				require.NoError(t, err)
+				assert.Contains(t, string(data), "address=/a.lan/10.0.0.11\n")
+
+				status, err := ds.Reconcile("")
+				assert.EqualError(t, err, tt.wantErr)
+				assert.True(t, status.Drifted)
+				return
+			}
+			require.NoError(t, err)
+			// The files hold the database, so there is nothing left to reconcile
+			status, err := ds.SyncStatus()
+			require.NoError(t, err)
+			assert.True(t, status.InSync)
+
+			// A hand edit found by Reconcile is resolved by the policy, and written out
+			editConfig(t, ds, "address=/c.lan/10.0.0.3\n", "address=/c.lan/10.0.0.33\n")
+			status, err = ds.Reconcile("")
+			require.NoError(t, err)
+			assert.Equal(t, tt.resolution, status.Resolution)
+			status, err = ds.SyncStatus()
+			require.NoError(t, err)
+			assert.True(t, status.InSync)
+
+			// Nothing is written when there is nothing to reconcile
+			gen, err := ds.getGeneration()
+			require.NoError(t, err)
+			status, err = ds.Reconcile("")
+			require.NoError(t, err)
+			assert.Empty(t, status.Resolution)
+			after, err := ds.getGeneration()
+			require.NoError(t, err)
+			assert.Equal(t, gen, after)
+		})
+	}
+
+	// A policy given to Reconcile overrides the configured one
+	ds := newSyncTestService(t, model.SyncPolicyFail)
+	editConfig(t, ds, "address=/a.lan/10.0.0.1\n", "address=/a.lan/10.0.0.11\n")
+	status, err := ds.Reconcile(model.SyncPolicyFileWins)
+	require.NoError(t, err)
+	assert.Equal(t, model.SyncSourceFile, status.Resolution)
+	assert.Equal(t, "10.0.0.11", hostIPs(t, ds)["a.lan"])
+
+	_, err = ds.Reconcile("newest-wins")
+	assert.EqualError(t, err, "unknown sync policy 'newest-wins', expected one of file-wins, db-wins, fail")
+}
+
+func TestDNSMasqService_BuildDatabaseSyncPolicy(t *testing.T) {
+	// The fail policy leaves a drifted config alone on startup, and refuses to write it
+	ds := newSyncTestService(t, model.SyncPolicyFail)
+	editConfig(t, ds, "address=/a.lan/10.0.0.1\n", "address=/a.lan/10.0.0.11\n")
+	require.NoError(t, ds.BuildDatabase())
+	assert.Equal(t, "10.0.0.1", hostIPs(t, ds)["a.lan"])
+	assert.EqualError(t, ds.UpdateDNSMasq(), ErrorConfigDrift)
+
+	// The database wins by rewriting the config
+	ds = newSyncTestService(t, model.SyncPolicyDBWins)
+	editConfig(t, ds, "address=/a.lan/10.0.0.1\n", "address=/a.lan/10.0.0.11\n")
+	require.NoError(t, ds.BuildDatabase())
+	assert.Equal(t, "10.0.0.1", hostIPs(t, ds)["a.lan"])
+	data, err := os.ReadFile(ds.dnsMasqConfig)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "address=/a.lan/10.0.0.1\n")
+
+	_, err = NewDNSMasqService(model.Config{DnsmasqConfig: ds.dnsMasqConfig, Sync: model.SyncConfig{Policy: "newest"}},
+		WithDBFilePath(filepath.Join(t.TempDir(), "dns.db")), WithLogger(testLogger()))
+	assert.EqualError(t, err, "unknown sync policy 'newest', expected one of file-wins, db-wins, fail")
+}
+
+func TestDNSMasqService_FailPolicyRefusesChanges(t *testing.T) {
+	ds := newSyncTestService(t, model.SyncPolicyFail)
+	ctx := context.Background()
+	editConfig(t, ds, "address=/a.lan/10.0.0.1\n", "address=/a.lan/10.0.0.11\n")
+	before, err := ds.GetAllIPs()
+	require.NoError(t, err)
+	audit, err := ds.GetAuditLog(model.AuditQuery{})
+	require.NoError(t, err)
+
+	// Every change is refused before it is committed, so the database is left as it was
+	_, err = ds.SetIPByHost(ctx, "c.lan", []string{"10.0.0.3"}, false)
+	assert.EqualError(t, err, ErrorConfigDrift)
+	assert.EqualError(t, ds.DeleteByHost(ctx, "b.lan"), ErrorConfigDrift)
+	_, err = ds.ApplyBulk(ctx, []model.DNSBulkOperation{
+		{Op: model.BulkOpUpsert, Hostname: "c.lan", IPs: []string{"10.0.0.3"}},
+	})
+	assert.EqualError(t, err, ErrorConfigDrift)
+	_, err = ds.RevertHost(ctx, "b.lan", 1)
+	assert.EqualError(t, err, ErrorConfigDrift)
+	_, err = ds.RenewRecordsByHost(ctx, "b.lan", model.RenewDNSRecordRequest{TTLSeconds: 60})
+	assert.EqualError(t, err, ErrorConfigDrift)
+	after, err := ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	auditAfter, err := ds.GetAuditLog(model.AuditQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, audit, auditAfter)
+
+	// A dry run import changes nothing, so it is still allowed
+	result, err := ds.ImportRecords(ctx, []model.DNSRecord{{Hostname: "c.lan", Type: model.RecordTypeHost,
+		IP: "10.0.0.3"}}, model.ImportOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Len(t, result.Changes, 1)
+
+	// Once reconciled, changes are accepted again
+	_, err = ds.Reconcile(model.SyncPolicyFileWins)
+	require.NoError(t, err)
+	_, err = ds.SetIPByHost(ctx, "c.lan", []string{"10.0.0.3"}, false)
+	require.NoError(t, err)
+}
+
+func TestDNSMasqService_StartSyncWatcher(t *testing.T) {
+	ds := newSyncTestService(t, "")
+	stop, err := ds.StartSyncWatcher(10 * time.Millisecond)
+	require.NoError(t, err)
+	defer stop()
+
+	editConfig(t, ds, "address=/a.lan/10.0.0.1\n", "address=/a.lan/10.0.0.11\n")
+	assert.Eventually(t, func() bool {
+		ds.updateMu.Lock()
+		defer ds.updateMu.Unlock()
+		records, err := ds.GetIPByHost("a.lan")
+		return err == nil && len(records) == 1 && records[0].IP == "10.0.0.11"
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,7 @@
+#!/bin/sh
+# Stands in for `dnsmasq --test` in tests. Rejects any config mentioning a host under .bad
+if grep -q '\.bad[/,]' "$1"; then
+  echo "dnsmasq: bad config at line $(grep -n '\.bad[/,]' "$1" | head -1 | cut -d: -f1) of $1"
+  exit 1
+fi
+echo "dnsmasq: syntax check OK."
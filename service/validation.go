@@ -0,0 +1,190 @@
+package service
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/model"
+)
+
+const (
+	maxHostnameLength = 253
+	maxLabelLength    = 63
+)
+
+// ValidationError is returned when records are rejected by the syntax rules or the validation policy
+type ValidationError struct {
+	Errors []model.FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	var msgs []string
+	for _, fieldErr := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", fieldErr.Field, fieldErr.Message))
+	}
+
+	return "invalid records: " + strings.Join(msgs, "; ")
+}
+
+// validator Checks records against the syntax rules and the configured policy
+type validator struct {
+	zones          []string
+	cidrs          []netip.Prefix
+	forbiddenNames []string
+	allowShadowing bool
+}
+
+// newValidator creates a validator from the validation policy in config
+func newValidator(config model.ValidationConfig) (validator, error) {
+	v := validator{forbiddenNames: config.ForbiddenNames, allowShadowing: config.AllowShadowing}
+	for _, zone := range config.AllowedZones {
+		zone = strings.ToLower(strings.Trim(zone, "."))
+		if err := checkHostname(zone); err != "" {
+			return v, fmt.Errorf("validation.allowed_zones: zone '%s' %s", zone, err)
+		}
+		v.zones = append(v.zones, zone)
+	}
+	for _, cidr := range config.AllowedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return v, fmt.Errorf("validation.allowed_cidrs: %v", err)
+		}
+		v.cidrs = append(v.cidrs, prefix.Masked())
+	}
+
+	return v, nil
+}
+
+// Check validates records, returning a ValidationError listing every invalid field
+func (v validator) Check(records []model.DNSRecord) error {
+	var errs []model.FieldError
+	for i, record := range records {
+		prefix := ""
+		if len(records) > 1 {
+			prefix = fmt.Sprintf("records[%d].", i)
+		}
+		for _, fieldErr := range append(checkRecord(record), v.checkPolicy(record)...) {
+			fieldErr.Field = prefix + fieldErr.Field
+			errs = append(errs, fieldErr)
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+
+	return nil
+}
+
+// checkPolicy checks a record against the allowed zones and CIDRs and the forbidden names
+func (v validator) checkPolicy(r model.DNSRecord) []model.FieldError {
+	var errs []model.FieldError
+	hostname := strings.ToLower(r.Hostname)
+	if len(v.zones) > 0 {
+		inZone := false
+		for _, zone := range v.zones {
+			inZone = inZone || hostname == zone || strings.HasSuffix(hostname, "."+zone)
+		}
+		if !inZone {
+			errs = append(errs, model.FieldError{Field: "hostname", Value: r.Hostname,
+				Message: "not in an allowed zone (" + strings.Join(v.zones, ", ") + ")"})
+		}
+	}
+	for _, pattern := range v.forbiddenNames {
+		if matched, _ := path.Match(strings.ToLower(pattern), hostname); matched {
+			errs = append(errs, model.FieldError{Field: "hostname", Value: r.Hostname, Message: "is a forbidden name"})
+			break
+		}
+	}
+
+	if addr, err := netip.ParseAddr(r.IP); err == nil && len(v.cidrs) > 0 {
+		allowed := false
+		for _, cidr := range v.cidrs {
+			allowed = allowed || cidr.Contains(addr.Unmap())
+		}
+		if !allowed {
+			errs = append(errs, model.FieldError{Field: "ip", Value: r.IP, Message: "not in an allowed network"})
+		}
+	}
+
+	return errs
+}
+
+// checkRecord checks the syntax of a record's fields, so nothing can break or inject into the rendered directive
+func checkRecord(r model.DNSRecord) []model.FieldError {
+	var errs []model.FieldError
+	if msg := checkHostname(r.Hostname); msg != "" {
+		errs = append(errs, model.FieldError{Field: "hostname", Value: r.Hostname, Message: msg})
+	}
+
+	switch r.RecordType() {
+	case model.RecordTypeAddress, model.RecordTypeHost:
+		if addr, err := netip.ParseAddr(r.IP); err != nil {
+			errs = append(errs, model.FieldError{Field: "ip", Value: r.IP, Message: "not a valid IP address"})
+		} else if addr.Zone() != "" {
+			errs = append(errs, model.FieldError{Field: "ip", Value: r.IP, Message: "may not have a zone"})
+		}
+	case model.RecordTypeTXT:
+		if strings.ContainsFunc(r.Text, func(c rune) bool { return c < ' ' || c == 0x7f || c == '"' || c == '\\' }) {
+			errs = append(errs, model.FieldError{Field: "text", Value: r.Text,
+				Message: "may not contain quotes, backslashes or control characters"})
+		}
+	}
+	if r.TTL != 0 && r.RecordType() != model.RecordTypeHost && r.RecordType() != model.RecordTypeCNAME {
+		errs = append(errs, model.FieldError{Field: "ttl", Value: strconv.FormatUint(uint64(r.TTL), 10),
+			Message: "only host and cname records take a TTL"})
+	}
+	if r.Target != "" {
+		if msg := checkHostname(r.Target); msg != "" {
+			errs = append(errs, model.FieldError{Field: "target", Value: r.Target, Message: msg})
+		}
+	}
+	// Anything else missing for the record type
+	if err := r.Validate(); err != nil && len(errs) == 0 {
+		errs = append(errs, model.FieldError{Field: "record", Message: err.Error()})
+	}
+
+	return errs
+}
+
+// checkRecordSyntax checks the syntax of a record, without the validation policy
+func checkRecordSyntax(r model.DNSRecord) error {
+	if errs := checkRecord(r); len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+
+	return nil
+}
+
+// checkHostname checks a hostname follows RFC 1123, returning what is wrong with it. Labels may also start with an
+// underscore, for service names like _ldap._tcp
+func checkHostname(hostname string) string {
+	if hostname == "" {
+		return "is required"
+	}
+	if len(hostname) > maxHostnameLength {
+		return fmt.Sprintf("is longer than %d characters", maxHostnameLength)
+	}
+
+	for _, label := range strings.Split(hostname, ".") {
+		if label == "" {
+			return "has an empty label"
+		}
+		if len(label) > maxLabelLength {
+			return fmt.Sprintf("has a label longer than %d characters", maxLabelLength)
+		}
+		for i, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			case c == '-' && i != 0 && i != len(label)-1:
+			case c == '_' && i == 0:
+			default:
+				return fmt.Sprintf("has an invalid character %q in label '%s'", c, label)
+			}
+		}
+	}
+
+	return ""
+}
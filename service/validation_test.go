@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cclose/dnsmasq-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkHostname(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostname string
+		wantErr  bool
+	}{
+		{name: "Simple", hostname: "nas.lan"},
+		{name: "SingleLabel", hostname: "nas"},
+		{name: "Hyphen", hostname: "my-nas.lan"},
+		{name: "DigitsFirst", hostname: "1password.lan"},
+		{name: "ServiceLabels", hostname: "_ldap._tcp.lan"},
+		{name: "Empty", hostname: "", wantErr: true},
+		{name: "Slash", hostname: "evil/10.0.0.1", wantErr: true},
+		{name: "Comma", hostname: "a.lan,10.0.0.1", wantErr: true},
+		{name: "Newline", hostname: "a.lan\naddress=/#/10.0.0.1", wantErr: true},
+		{name: "Space", hostname: "a lan", wantErr: true},
+		{name: "Hash", hostname: "#", wantErr: true},
+		{name: "LeadingHyphen", hostname: "-a.lan", wantErr: true},
+		{name: "TrailingHyphen", hostname: "a-.lan", wantErr: true},
+		{name: "EmptyLabel", hostname: "a..lan", wantErr: true},
+		{name: "TrailingDot", hostname: "a.lan.", wantErr: true},
+		{name: "LongLabel", hostname: "a234567890123456789012345678901234567890123456789012345678901234.lan", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := checkHostname(tt.hostname)
+			assert.Equal(t, tt.wantErr, msg != "", msg)
+		})
+	}
+}
+
+func Test_validator_Check(t *testing.T) {
+	v, err := newValidator(model.ValidationConfig{
+		AllowedZones:   []string{"lan", ".home.arpa."},
+		AllowedCIDRs:   []string{"10.0.0.0/8", "fd00::/8"},
+		ForbiddenNames: []string{"router.lan", "*.infra.lan"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		record     model.DNSRecord
+		wantFields []string
+	}{
+		{
+			name:   "Valid",
+			record: model.DNSRecord{Hostname: "nas.lan", Type: model.RecordTypeAddress, IP: "10.0.0.5"},
+		},
+		{
+			name:   "ValidIPv6",
+			record: model.DNSRecord{Hostname: "nas.home.arpa", Type: model.RecordTypeHost, IP: "fd00::5"},
+		},
+		{
+			name:       "BadIP",
+			record:     model.DNSRecord{Hostname: "nas.lan", Type: model.RecordTypeAddress, IP: "10.0.0.999"},
+			wantFields: []string{"ip"},
+		},
+		{
+			name:       "OutsideCIDRs",
+			record:     model.DNSRecord{Hostname: "nas.lan", Type: model.RecordTypeAddress, IP: "192.168.1.5"},
+			wantFields: []string{"ip"},
+		},
+		{
+			name:       "OutsideZones",
+			record:     model.DNSRecord{Hostname: "nas.example.com", Type: model.RecordTypeAddress, IP: "10.0.0.5"},
+			wantFields: []string{"hostname"},
+		},
+		{
+			name:       "Forbidden",
+			record:     model.DNSRecord{Hostname: "ns1.infra.lan", Type: model.RecordTypeAddress, IP: "10.0.0.5"},
+			wantFields: []string{"hostname"},
+		},
+		{
+			name:       "InjectedTarget",
+			record:     model.DNSRecord{Hostname: "www.lan", Type: model.RecordTypeCNAME, Target: "nas.lan\nserver=8.8.8.8"},
+			wantFields: []string{"target"},
+		},
+		{
+			name:       "QuotedText",
+			record:     model.DNSRecord{Hostname: "nas.lan", Type: model.RecordTypeTXT, Text: `a" b`},
+			wantFields: []string{"text"},
+		},
+		{
+			name:       "MissingTarget",
+			record:     model.DNSRecord{Hostname: "www.lan", Type: model.RecordTypeCNAME},
+			wantFields: []string{"record"},
+		},
+		{
+			name:       "AddressTTL",
+			record:     model.DNSRecord{Hostname: "nas.lan", Type: model.RecordTypeAddress, IP: "10.0.0.5", TTL: 60},
+			wantFields: []string{"ttl"},
+		},
+		{
+			name:   "HostTTL",
+			record: model.DNSRecord{Hostname: "nas.lan", Type: model.RecordTypeHost, IP: "10.0.0.5", TTL: 60},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.Check([]model.DNSRecord{tt.record})
+			if len(tt.wantFields) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var validationErr *ValidationError
+			require.True(t, errors.As(err, &validationErr), err)
+			var fields []string
+			for _, fieldErr := range validationErr.Errors {
+				fields = append(fields, fieldErr.Field)
+			}
+			assert.Equal(t, tt.wantFields, fields)
+		})
+	}
+
+	_, err = newValidator(model.ValidationConfig{AllowedCIDRs: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+	_, err = newValidator(model.ValidationConfig{AllowedZones: []string{"bad zone"}})
+	assert.Error(t, err)
+}
+
+func TestDNSMasqService_SetRecordsByHostValidation(t *testing.T) {
+	ds := newTestDNSMasqService(t, "address=/a.lan/10.0.0.1\n")
+
+	_, err := ds.SetIPByHost(context.Background(), "evil/host", []string{"10.0.0.2", "10.0.0.999"}, false)
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr), err)
+	assert.Equal(t, []model.FieldError{
+		{Field: "records[0].hostname", Value: "evil/host", Message: "has an invalid character '/' in label 'evil/host'"},
+		{Field: "records[1].hostname", Value: "evil/host", Message: "has an invalid character '/' in label 'evil/host'"},
+		{Field: "records[1].ip", Value: "10.0.0.999", Message: "not a valid IP address"},
+	}, validationErr.Errors)
+
+	records, err := ds.GetAllIPs()
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+}
@@ -0,0 +1,372 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/cclose/dnsmasq-api/model"
+)
+
+// zoneToken A word of a zone file entry. Quoted strings are a single token, without their quotes
+type zoneToken struct {
+	text   string
+	quoted bool
+}
+
+// zoneEntry A logical line of a zone file, which parentheses can spread over several lines
+type zoneEntry struct {
+	line int
+	raw  string
+	// indented entries have no owner name, and belong to the previous owner
+	indented bool
+	tokens   []zoneToken
+}
+
+// zoneEntries splits a zone file into its entries, dropping comments and blank lines
+func zoneEntries(data string) []zoneEntry {
+	var entries []zoneEntry
+	var entry zoneEntry
+	depth := 0
+	for i, raw := range strings.Split(data, "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		if depth == 0 {
+			entry = zoneEntry{line: i + 1, raw: raw, indented: strings.HasPrefix(raw, " ") || strings.HasPrefix(raw, "\t")}
+		} else {
+			entry.raw += "\n" + raw
+		}
+
+		var token strings.Builder
+		inToken, inQuotes, quoted := false, false, false
+		flush := func() {
+			if inToken {
+				entry.tokens = append(entry.tokens, zoneToken{text: token.String(), quoted: quoted})
+			}
+			token.Reset()
+			inToken, quoted = false, false
+		}
+	scan:
+		for j := 0; j < len(raw); j++ {
+			c := raw[j]
+			switch {
+			case c == '\\' && j+1 < len(raw):
+				// \DDD is a decimal byte, anything else is the escaped character itself
+				if j+3 < len(raw) && isDigits(raw[j+1:j+4]) {
+					v, _ := strconv.Atoi(raw[j+1 : j+4])
+					token.WriteByte(byte(v))
+					j += 3
+				} else {
+					token.WriteByte(raw[j+1])
+					j++
+				}
+				inToken = true
+			case inQuotes:
+				if c == '"' {
+					inQuotes = false
+					flush()
+				} else {
+					token.WriteByte(c)
+				}
+			case c == ';':
+				break scan
+			case c == '"':
+				flush()
+				inToken, inQuotes, quoted = true, true, true
+			case c == '(':
+				flush()
+				depth++
+			case c == ')':
+				flush()
+				depth = max(depth-1, 0)
+			case c == ' ' || c == '\t':
+				flush()
+			default:
+				token.WriteByte(c)
+				inToken = true
+			}
+		}
+		flush()
+
+		if depth == 0 && len(entry.tokens) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	if depth > 0 && len(entry.tokens) > 0 {
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// isDigits reports whether s is made only of decimal digits
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return s != ""
+}
+
+// parseZone adds the records of an RFC 1035 zone file. A and AAAA records are host records, except for wildcard
+// owners like *.example.com, which are address records for example.com, as dnsmasq has no wildcard that leaves out
+// the domain itself. An A or AAAA record for example.com with the same IP is folded into the wildcard. $ORIGIN and
+// $TTL are followed, and the TTL is kept for host and cname records, the only ones dnsmasq takes one for. SOA and NS
+// records, and types dnsmasq can't serve, are skipped
+func (im *importer) parseZone(data string) {
+	origin := ""
+	var defaultTTL uint32
+	owner := ""
+	for _, entry := range zoneEntries(data) {
+		tokens := entry.tokens
+		text := strings.TrimSpace(entry.raw)
+
+		if directive := strings.ToUpper(tokens[0].text); strings.HasPrefix(directive, "$") {
+			switch {
+			case len(tokens) < 2:
+				im.skip(entry.line, text, fmt.Sprintf("%s needs a value", directive))
+			case directive == "$ORIGIN":
+				origin = zoneName(tokens[1].text, origin)
+			case directive == "$TTL":
+				ttl, ok := parseZoneTTL(tokens[1].text)
+				if !ok {
+					im.skip(entry.line, text, fmt.Sprintf("invalid ttl '%s'", tokens[1].text))
+					continue
+				}
+				defaultTTL = ttl
+			default:
+				im.skip(entry.line, text, fmt.Sprintf("%s is not supported", directive))
+			}
+			continue
+		}
+
+		if !entry.indented {
+			owner = zoneName(tokens[0].text, origin)
+			tokens = tokens[1:]
+		}
+		if owner == "" {
+			im.skip(entry.line, text, "no owner name")
+			continue
+		}
+
+		// The TTL and class are both optional, and may come in either order
+		ttl := defaultTTL
+		class := "IN"
+		for len(tokens) > 0 {
+			if v, ok := parseZoneTTL(tokens[0].text); ok {
+				ttl = v
+			} else if word := strings.ToUpper(tokens[0].text); word == "IN" || word == "CH" || word == "HS" || word == "CS" {
+				class = word
+			} else {
+				break
+			}
+			tokens = tokens[1:]
+		}
+		if len(tokens) == 0 {
+			im.skip(entry.line, text, "no record type")
+			continue
+		}
+		if class != "IN" {
+			im.skip(entry.line, text, fmt.Sprintf("only IN class records are imported, not %s", class))
+			continue
+		}
+
+		record, err := zoneRecord(owner, strings.ToUpper(tokens[0].text), tokens[1:], origin)
+		if err != nil {
+			im.skip(entry.line, text, err.Error())
+			continue
+		}
+		if record.RecordType() == model.RecordTypeHost || record.RecordType() == model.RecordTypeCNAME {
+			record.TTL = ttl
+		}
+		im.add(entry.line, text, record)
+	}
+
+	// An exported wildcard is a record for both *.<name> and <name>, which is a single address record again
+	wildcards := make(map[string]bool)
+	for _, record := range im.records {
+		if record.RecordType() == model.RecordTypeAddress {
+			wildcards[record.Hostname+"/"+record.IP] = true
+		}
+	}
+	var records []model.DNSRecord
+	for _, record := range im.records {
+		if record.RecordType() != model.RecordTypeHost || !wildcards[record.Hostname+"/"+record.IP] {
+			records = append(records, record)
+		}
+	}
+	im.records = records
+}
+
+// zoneRecord builds a record from the type and data of a zone file entry
+func zoneRecord(owner, rrType string, rdata []zoneToken, origin string) (model.DNSRecord, error) {
+	record := model.DNSRecord{Hostname: owner}
+	wildcard := strings.HasPrefix(owner, "*.")
+	if wildcard && rrType != "A" && rrType != "AAAA" {
+		return record, fmt.Errorf("only A and AAAA records can be wildcards, not %s records", rrType)
+	}
+
+	need := map[string]int{"A": 1, "AAAA": 1, "CNAME": 1, "PTR": 1, "MX": 2, "SRV": 4, "TXT": 1}
+	if n, ok := need[rrType]; ok && len(rdata) < n {
+		return record, fmt.Errorf("%s record needs %d fields", rrType, n)
+	}
+
+	switch rrType {
+	case "A", "AAAA":
+		ip := net.ParseIP(rdata[0].text)
+		if ip == nil || (ip.To4() != nil) != (rrType == "A") {
+			return record, fmt.Errorf("invalid ip '%s' for %s record", rdata[0].text, rrType)
+		}
+		record.Type = model.RecordTypeHost
+		record.IP = rdata[0].text
+		if wildcard {
+			record.Type = model.RecordTypeAddress
+			record.Hostname = strings.TrimPrefix(owner, "*.")
+		}
+	case "CNAME":
+		record.Type = model.RecordTypeCNAME
+		record.Target = zoneName(rdata[0].text, origin)
+	case "PTR":
+		record.Type = model.RecordTypePTR
+		record.Target = zoneName(rdata[0].text, origin)
+	case "TXT":
+		// The strings of a TXT record are joined into one
+		var text strings.Builder
+		for _, token := range rdata {
+			text.WriteString(token.text)
+		}
+		record.Type = model.RecordTypeTXT
+		record.Text = text.String()
+	case "MX":
+		priority, err := strconv.ParseUint(rdata[0].text, 10, 16)
+		if err != nil {
+			return record, fmt.Errorf("invalid preference '%s' for MX record", rdata[0].text)
+		}
+		record.Type = model.RecordTypeMX
+		record.Priority = uint16(priority)
+		record.Target = zoneName(rdata[1].text, origin)
+	case "SRV":
+		var values [3]uint16
+		for i, name := range []string{"priority", "weight", "port"} {
+			v, err := strconv.ParseUint(rdata[i].text, 10, 16)
+			if err != nil {
+				return record, fmt.Errorf("invalid %s '%s' for SRV record", name, rdata[i].text)
+			}
+			values[i] = uint16(v)
+		}
+		record.Type = model.RecordTypeSRV
+		record.Priority, record.Weight, record.Port = values[0], values[1], values[2]
+		record.Target = zoneName(rdata[3].text, origin)
+	case "SOA", "NS":
+		return record, fmt.Errorf("%s records are not imported, dnsmasq answers for its own zones", rrType)
+	default:
+		return record, fmt.Errorf("%s records are not supported by dnsmasq", rrType)
+	}
+
+	return record, nil
+}
+
+// zoneName makes a name of a zone file absolute, without its trailing dot. @ is the origin, and names without a
+// trailing dot are relative to it
+func zoneName(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.TrimSuffix(name, ".")
+	case origin == "":
+		return name
+	}
+
+	return name + "." + origin
+}
+
+// parseZoneTTL parses a zone file TTL, either seconds or a BIND style duration like 1h30m
+func parseZoneTTL(s string) (uint32, bool) {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, false
+	}
+	if ttl, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(ttl), true
+	}
+
+	units := map[byte]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+	var total, value uint64
+	digits := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= '0' && c <= '9' {
+			value = value*10 + uint64(c-'0')
+			digits = true
+			continue
+		}
+		unit, ok := units[c|0x20]
+		if !ok || !digits {
+			return 0, false
+		}
+		total += value * unit
+		value, digits = 0, false
+	}
+	total += value
+	if total > uint64(^uint32(0)) {
+		return 0, false
+	}
+
+	return uint32(total), true
+}
+
+// zoneExportHeader starts a zone file export. It only holds the resource records, for including into a zone
+const zoneExportHeader = "; Exported by DNSMasq API\n"
+
+// renderZone renders records as the resource records of a zone file, with absolute names. Address records answer for
+// the hostname and every name under it, so each is an A or AAAA record for both the hostname and the *. wildcard
+func renderZone(records []model.DNSRecord) string {
+	var b strings.Builder
+	b.WriteString(zoneExportHeader)
+	for _, record := range records {
+		for _, line := range zoneLines(record) {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+
+	return b.String()
+}
+
+// zoneLines renders a record as the zone file lines for it
+func zoneLines(r model.DNSRecord) []string {
+	name := r.Hostname + "."
+	ttl := ""
+	if r.TTL != 0 {
+		ttl = fmt.Sprintf("%d\t", r.TTL)
+	}
+	line := func(owner, rrType, rdata string) string {
+		return fmt.Sprintf("%s\t%sIN\t%s\t%s", owner, ttl, rrType, rdata)
+	}
+
+	switch r.RecordType() {
+	case model.RecordTypeAddress, model.RecordTypeHost:
+		rrType := "A"
+		if ip := net.ParseIP(r.IP); ip != nil && ip.To4() == nil {
+			rrType = "AAAA"
+		}
+		if r.RecordType() == model.RecordTypeHost {
+			return []string{line(name, rrType, r.IP)}
+		}
+		return []string{line("*."+name, rrType, r.IP), line(name, rrType, r.IP)}
+	case model.RecordTypeCNAME:
+		return []string{line(name, "CNAME", r.Target+".")}
+	case model.RecordTypeTXT:
+		return []string{line(name, "TXT", fmt.Sprintf("\"%s\"", r.Text))}
+	case model.RecordTypeSRV:
+		return []string{line(name, "SRV", fmt.Sprintf("%d %d %d %s.", r.Priority, r.Weight, r.Port, r.Target))}
+	case model.RecordTypeMX:
+		return []string{line(name, "MX", fmt.Sprintf("%d %s.", r.Priority, r.Target))}
+	case model.RecordTypePTR:
+		return []string{line(name, "PTR", r.Target+".")}
+	}
+
+	return nil
+}